@@ -2,165 +2,774 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"belt-presense/internal/api"
 	"belt-presense/internal/config"
 	"belt-presense/internal/database"
+	"belt-presense/internal/deadletter"
 	"belt-presense/internal/handler"
+	"belt-presense/internal/health"
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+	"belt-presense/internal/sink"
+	"belt-presense/internal/tracing"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"gopkg.in/natefinch/lumberjack.v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
-	log.Println("Starting Belt_presense Service...")
-	cfg := config.LoadConfig()
-	setupLogging(cfg.LogToConsole)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "deadletters":
+			os.Exit(runDeadLetters(os.Args[2:]))
+		}
+	}
+
+	configPath := flag.String("config", "", "path to a YAML config file (defaults to $CONFIG_FILE)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := logging.Setup(logging.Options{
+		Level:      cfg.Logging.Level,
+		Format:     cfg.Logging.Format,
+		File:       cfg.Logging.File,
+		Console:    cfg.Logging.Console,
+		PHIPolicy:  cfg.Logging.PHIPolicy,
+		PHIFields:  cfg.Logging.PHIFields,
+		PHIHashKey: cfg.Logging.PHIHashKey,
+	}); err != nil {
+		fatal("Failed to set up logging", "error", err)
+	}
+	slog.Info("Starting Belt_presense Service")
 	logConfiguration(cfg)
 
-	apiEndpoint := cfg.PresenseAPIEndpoint
-	apiKey := cfg.PresenseAPIKey
-	if cfg.UseTestURL {
-		log.Println("Using test URL")
-		apiEndpoint = cfg.TestAPIEndpoint
-		apiKey = cfg.TestAPIKey
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: "belt-presense",
+	})
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Error flushing traces", "error", err)
+		}
+	}()
 
-	if apiEndpoint == "" || apiKey == "" {
-		log.Fatal("FATAL: API endpoint and key must be set in .env file")
+	if cfg.Destinations.UseTest {
+		slog.Info("Using test URL")
 	}
 
-	repo, err := database.NewRepository(cfg.DBPath)
+	repo, err := database.NewRepository(cfg.Database.Path)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", "error", err)
 	}
 	defer repo.Close()
 
-	processor, err := handler.NewBeltProcessor(repo, apiEndpoint, apiKey, cfg.DataSource, cfg.WriteToFile)
+	opts, routes, err := processorOptions(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize processor: %v", err)
+		fatal("Failed to resolve destination routes", "error", err)
+	}
+	sinks, err := sink.FromConfig(cfg)
+	if err != nil {
+		fatal("Failed to initialize output sinks", "error", err)
+	}
+	slog.Info("Output sinks enabled", "sinks", sinks.Sinks())
+	deadLetters, err := newDeadLetterRecorder(cfg, repo)
+	if err != nil {
+		fatal("Failed to initialize dead-letter handling", "error", err)
+	}
+	processor, err := handler.NewBeltProcessor(repo, sinks, deadLetters, opts)
+	if err != nil {
+		fatal("Failed to initialize processor", "error", err)
 	}
 
+	metrics.RegisterCacheSizes(processor.CacheSizes)
+	reloader := &configReloader{path: *configPath, current: cfg, opts: opts, routes: routes, processor: processor}
+
 	mqttClient, err := handler.InitializeMQTT(cfg, processor)
 	if err != nil {
-		log.Fatalf("Failed to initialize MQTT client: %v", err)
+		fatal("Failed to initialize MQTT client", "error", err)
 	}
 	defer mqttClient.Disconnect(250)
 
+	consumers, err := topicConsumers(cfg.Kafka.ActiveSubscriptions(), processor)
+	if err != nil {
+		fatal("Failed to set up Kafka subscriptions", "error", err)
+	}
+
+	checker := health.NewChecker()
+	for _, c := range consumers {
+		checker.AddLiveness(c.checkName(), c.heartbeat.Within("kafka consumer "+c.name, 30*time.Second))
+	}
+	checker.AddReadiness("mqtt", func(ctx context.Context) error {
+		if !mqttClient.IsConnectionOpen() {
+			return fmt.Errorf("not connected to MQTT broker")
+		}
+		return nil
+	})
+	checker.AddReadiness("database", func(ctx context.Context) error {
+		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		return repo.Ping(pingCtx)
+	})
+	checker.AddReadiness("delivery", processor.CheckDelivery)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		<-sigChan
-		log.Println("Shutdown signal received, closing consumers...")
-		cancel()
+		for sig := range sigChan {
+			if sig == syscall.SIGHUP {
+				slog.Info("SIGHUP received, reloading configuration")
+				if _, err := reloader.Reload(); err != nil {
+					slog.Error("Configuration reload rejected", "error", err)
+				}
+				continue
+			}
+			slog.Info("Shutdown signal received, closing consumers")
+			cancel()
+			return
+		}
 	}()
 
 	var wg sync.WaitGroup
-	wg.Add(3) // MQTT, Kafka Consumer, Housekeeping
+	wg.Add(7 + len(consumers)) // MQTT, Kafka consumers, Housekeeping, Dedupe snapshots, Sinks, Dead letters, Admin API, systemd watchdog
 
 	go func() {
 		defer wg.Done()
 		<-ctx.Done()
-		log.Println("Shutting down MQTT client...")
+		slog.Info("Shutting down MQTT client")
 	}()
 
+	for _, c := range consumers {
+		go func() {
+			defer wg.Done()
+			runConsumer(ctx, cfg, c)
+		}()
+	}
+
+	// Start the housekeeping goroutine
 	go func() {
 		defer wg.Done()
-		runConsumer(ctx, cfg, cfg.VitalsTopic, processor.RouteVitalsMessage)
+		processor.RunHousekeepingCycle(ctx)
 	}()
 
-	// Start the housekeeping goroutine
 	go func() {
 		defer wg.Done()
-		processor.RunHousekeepingCycle(ctx)
+		processor.RunDedupeSnapshots(ctx, cfg.Dedupe.SnapshotInterval)
 	}()
 
-	log.Println("🚀 Service started successfully. Waiting for messages...")
+	go func() {
+		defer wg.Done()
+		sinks.Run(ctx)
+	}()
+
+	go func() {
+		defer wg.Done()
+		deadLetters.Run(ctx)
+	}()
+
+	go func() {
+		defer wg.Done()
+		if cfg.Admin.Addr == "" {
+			return
+		}
+		server := api.NewServer(cfg.Admin.Addr, cfg.Admin.Token, processor, repo, checker, reloader)
+		if err := server.Run(ctx); err != nil {
+			slog.Error("Admin API stopped with error", "error", err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		checker.RunWatchdog(ctx)
+	}()
+
+	slog.Info("Service started successfully, waiting for messages")
 	wg.Wait()
-	log.Println("All services closed. Exiting.")
+	slog.Info("All services closed, exiting")
+}
+
+// topicConsumer is one Kafka consumer and the handler for each topic it
+// reads.
+type topicConsumer struct {
+	name      string
+	topics    []string
+	handlers  map[string]func(context.Context, []byte)
+	heartbeat *health.Heartbeat
+}
+
+// checkName keeps the original liveness check name for the default consumer.
+func (c *topicConsumer) checkName() string {
+	if c.name == "default" {
+		return "kafka_poll"
+	}
+	return "kafka_poll_" + c.name
 }
 
-func runConsumer(ctx context.Context, cfg *config.Config, topic string, handlerFunc func([]byte)) {
+// topicConsumers groups subscriptions by consumer name, in the order the
+// consumers first appear.
+func topicConsumers(subs []config.SubscriptionConfig, processor *handler.BeltProcessor) ([]*topicConsumer, error) {
+	var consumers []*topicConsumer
+	byName := make(map[string]*topicConsumer)
+	for _, sub := range subs {
+		handle, ok := processor.TopicHandler(sub.Handler)
+		if !ok {
+			return nil, fmt.Errorf("topic %s: unknown handler %q", sub.Topic, sub.Handler)
+		}
+		c := byName[sub.Consumer]
+		if c == nil {
+			c = &topicConsumer{
+				name:      sub.Consumer,
+				handlers:  make(map[string]func(context.Context, []byte)),
+				heartbeat: &health.Heartbeat{},
+			}
+			byName[sub.Consumer] = c
+			consumers = append(consumers, c)
+		}
+		c.topics = append(c.topics, sub.Topic)
+		c.handlers[sub.Topic] = handle
+	}
+	return consumers, nil
+}
+
+func runConsumer(ctx context.Context, cfg *config.Config, c *topicConsumer) {
+	logger := slog.With("consumer", c.name)
 	kafkaConfig := &kafka.ConfigMap{
-		"bootstrap.servers": cfg.KafkaBrokers,
-		"group.id":          cfg.ConsumerGroup,
+		"bootstrap.servers": cfg.Kafka.Brokers,
+		"group.id":          cfg.Kafka.ConsumerGroup,
 		"auto.offset.reset": "earliest",
 	}
 
 	consumer, err := kafka.NewConsumer(kafkaConfig)
 	if err != nil {
-		log.Fatalf("Failed to create consumer for topic %s: %v", topic, err)
+		fatal("Failed to create Kafka consumer", "consumer", c.name, "error", err)
 	}
 	defer consumer.Close()
 
-	if err := consumer.Subscribe(topic, nil); err != nil {
-		log.Fatalf("Failed to subscribe to topic %s: %v", topic, err)
+	if err := consumer.SubscribeTopics(c.topics, nil); err != nil {
+		fatal("Failed to subscribe to topics", "consumer", c.name, "topics", c.topics, "error", err)
 	}
 
-	log.Printf("Consumer started for topic '%s' with group ID '%s'", topic, cfg.ConsumerGroup)
+	logger.Info("Consumer started", "groupID", cfg.Kafka.ConsumerGroup, "topics", c.topics)
+
+	lagTicker := time.NewTicker(15 * time.Second)
+	defer lagTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Stopping consumer for topic: %s", topic)
+			logger.Info("Stopping consumer")
 			return
+		case <-lagTicker.C:
+			recordConsumerLag(consumer)
 		default:
 			ev := consumer.Poll(100)
+			c.heartbeat.Beat()
 			if ev == nil {
 				continue
 			}
 			switch e := ev.(type) {
 			case *kafka.Message:
-				handlerFunc(e.Value)
+				topic := *e.TopicPartition.Topic
+				metrics.KafkaMessagesConsumed.WithLabelValues(topic).Inc()
+				handle, ok := c.handlers[topic]
+				if !ok {
+					logger.Warn("Message from unsubscribed topic, skipping", logging.KeyTopic, topic)
+					continue
+				}
+				handleMessage(ctx, logger.With(logging.KeyTopic, topic), e, handle)
 			case kafka.Error:
-				fmt.Fprintf(os.Stderr, "%% Kafka Error: %v\n", e)
+				metrics.KafkaErrors.Inc()
+				logger.Error("Kafka error", "error", e, "code", e.Code().String())
 			}
 		}
 	}
 }
 
-func setupLogging(logToConsole bool) {
-	logFile := &lumberjack.Logger{
-		Filename:   "./logs/presense.log", // Create log in the root directory
-		MaxSize:    5,
-		MaxBackups: 3,
-		MaxAge:     28,
-		Compress:   true,
+func handleMessage(ctx context.Context, logger *slog.Logger, msg *kafka.Message, handlerFunc func(context.Context, []byte)) {
+	ctx, span := tracing.Tracer().Start(tracing.ExtractKafka(ctx, msg.Headers), "kafka.consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", *msg.TopicPartition.Topic),
+			attribute.Int("messaging.kafka.destination.partition", int(msg.TopicPartition.Partition)),
+			attribute.Int64("messaging.kafka.message.offset", int64(msg.TopicPartition.Offset)),
+		))
+	defer span.End()
+
+	msgLogger := logger.With(logging.KeyPartition, msg.TopicPartition.Partition, logging.KeyOffset, int64(msg.TopicPartition.Offset))
+	ctx = handler.WithMessageType(ctx, headerValue(msg.Headers, handler.TypeHeader))
+	headers := make([]models.Header, len(msg.Headers))
+	for i, h := range msg.Headers {
+		headers[i] = models.Header{Key: h.Key, Value: h.Value}
+	}
+	ctx = deadletter.WithSource(logging.WithContext(ctx, msgLogger), deadletter.Source{
+		Topic:     *msg.TopicPartition.Topic,
+		Partition: msg.TopicPartition.Partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Headers:   headers,
+	})
+	handlerFunc(ctx, msg.Value)
+}
+
+func headerValue(headers []kafka.Header, key string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Key, key) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func recordConsumerLag(consumer *kafka.Consumer) {
+	assigned, err := consumer.Assignment()
+	if err != nil || len(assigned) == 0 {
+		return
+	}
+	positions, err := consumer.Position(assigned)
+	if err != nil {
+		slog.Warn("Failed to read consumer positions", "error", err)
+		return
+	}
+	for _, tp := range positions {
+		if tp.Topic == nil || tp.Offset < 0 {
+			continue
+		}
+		_, high, err := consumer.GetWatermarkOffsets(*tp.Topic, tp.Partition)
+		if err != nil {
+			continue
+		}
+		lag := high - int64(tp.Offset)
+		if lag < 0 {
+			lag = 0
+		}
+		metrics.KafkaConsumerLag.WithLabelValues(*tp.Topic, strconv.Itoa(int(tp.Partition))).Set(float64(lag))
+	}
+}
+
+// processorOptions also returns the resolved routes, the default first, that
+// the options' routes were built from.
+func processorOptions(cfg *config.Config) (handler.Options, []config.RouteConfig, error) {
+	routes, err := resolveRoutes(cfg)
+	if err != nil {
+		return handler.Options{}, nil, err
+	}
+	opts := baseOptions(cfg)
+	opts.DefaultRoute, opts.Routes, err = handlerRoutes(routes, cfg.Sinks.Presense.Timeout)
+	return opts, routes, err
+}
+
+// baseOptions returns the processor settings other than its routes.
+func baseOptions(cfg *config.Config) handler.Options {
+	opts := handler.Options{
+		BatchSize:         cfg.Batching.Size,
+		AllowedFacilities: cfg.Facilities.Allowed,
+		DeviceTypes:       cfg.Kafka.DeviceTypes,
+		Reorder: handler.ReorderOptions{
+			MaxWait:    cfg.Reorder.MaxWait,
+			MaxPackets: cfg.Reorder.MaxPackets,
+			LatePolicy: cfg.Reorder.LatePolicy,
+		},
+	}
+	if cfg.Dedupe.Enabled {
+		opts.DedupeWindow = cfg.Dedupe.Window
+	}
+	if cfg.Deliveries.Record {
+		opts.RecordDeliveries = true
+		opts.DeliveryRetention = cfg.Deliveries.Retention
+	}
+	return opts
+}
+
+// resolveRoutes returns the default route followed by the configured ones,
+// with their secret files read.
+func resolveRoutes(cfg *config.Config) ([]config.RouteConfig, error) {
+	defaultRoute, err := cfg.DefaultRoute()
+	if err != nil {
+		return nil, err
 	}
-	if logToConsole {
-		mw := io.MultiWriter(os.Stdout, logFile)
-		log.SetOutput(mw)
+	routes, err := cfg.ResolvedRoutes()
+	if err != nil {
+		return nil, err
+	}
+	return append([]config.RouteConfig{defaultRoute}, routes...), nil
+}
+
+// handlerRoutes builds routes, the default first, each with its HTTP client
+// and authenticator.
+func handlerRoutes(routes []config.RouteConfig, timeout time.Duration) (handler.Route, []handler.Route, error) {
+	// Routes with the same transport share one client and connection pool.
+	clients := make(map[config.TransportConfig]*http.Client)
+	handlerRoute := func(r config.RouteConfig) (handler.Route, error) {
+		client, ok := clients[r.Transport]
+		if !ok {
+			var err error
+			client, err = sink.ClientFromConfig(r.Transport, timeout)
+			if err != nil {
+				return handler.Route{}, fmt.Errorf("route %s: %w", r.Name, err)
+			}
+			clients[r.Transport] = client
+		}
+		return newHandlerRoute(r, client), nil
+	}
+
+	defRoute, err := handlerRoute(routes[0])
+	if err != nil {
+		return handler.Route{}, nil, err
+	}
+	var out []handler.Route
+	for _, r := range routes[1:] {
+		route, err := handlerRoute(r)
+		if err != nil {
+			return handler.Route{}, nil, err
+		}
+		out = append(out, route)
+	}
+	return defRoute, out, nil
+}
+
+// closeIdleConnections releases the idle connections of the routes' clients
+// once they have been replaced. Requests still in flight finish normally.
+func closeIdleConnections(opts handler.Options) {
+	opts.DefaultRoute.Client.CloseIdleConnections()
+	for _, r := range opts.Routes {
+		r.Client.CloseIdleConnections()
+	}
+}
+
+func newHandlerRoute(r config.RouteConfig, client *http.Client) handler.Route {
+	return handler.Route{
+		Name:        r.Name,
+		Facilities:  r.Facilities,
+		DeviceTypes: r.DeviceTypes,
+		EndpointURL: r.Endpoint,
+		Auth:        sink.AuthFromConfig(r, client),
+		Client:      client,
+		DataSource:  r.DataSource,
+		Payload: handler.PayloadOptions{
+			OmitPatientDetails: r.Payload.OmitPatientDetails,
+			OmitVitals:         r.Payload.OmitVitals,
+			OmitArrhythmia:     r.Payload.OmitArrhythmia,
+			MarkGaps:           r.Payload.MarkGaps,
+		},
+	}
+}
+
+// configReloader re-reads the configuration on SIGHUP or an admin request and
+// applies it when only settings that can change at runtime differ.
+type configReloader struct {
+	mu      sync.Mutex
+	path    string
+	current *config.Config
+	// opts and the resolved routes it was built from, as last applied.
+	opts      handler.Options
+	routes    []config.RouteConfig
+	processor *handler.BeltProcessor
+}
+
+// Reload returns the paths of the settings it applied. A reload is all or
+// nothing: if the new file is invalid or changes a restart-only setting, the
+// running configuration is left untouched.
+func (r *configReloader) Reload() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.Load(r.path)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	changed := config.Diff(r.current, next)
+	if blocked := config.RequiresRestart(changed); len(blocked) > 0 {
+		return nil, &config.RestartRequiredError{Fields: blocked}
+	}
+	if len(changed) == 0 {
+		slog.Info("Configuration reloaded, no changes")
+		return changed, nil
+	}
+
+	// Clients and authenticators, with their connection pools and cached
+	// OAuth2 tokens, are kept unless a route, its credentials (including
+	// those read from secret files) or its transport changed.
+	routes, err := resolveRoutes(next)
+	if err != nil {
+		return nil, err
+	}
+	opts := baseOptions(next)
+	routesChanged := !reflect.DeepEqual(routes, r.routes)
+	if routesChanged {
+		opts.DefaultRoute, opts.Routes, err = handlerRoutes(routes, next.Sinks.Presense.Timeout)
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		log.SetOutput(logFile)
+		opts.DefaultRoute, opts.Routes = r.opts.DefaultRoute, r.opts.Routes
+	}
+	if next.Logging.Level != r.current.Logging.Level {
+		if err := logging.SetLevel(next.Logging.Level); err != nil {
+			return nil, err
+		}
+	}
+	r.processor.Reconfigure(opts)
+	if routesChanged {
+		closeIdleConnections(r.opts)
 	}
+	r.current, r.opts, r.routes = next, opts, routes
+	slog.Info("Configuration reloaded", "changed", changed)
+	return changed, nil
 }
 
 func logConfiguration(cfg *config.Config) {
-	log.Println("--- Service Configuration ---")
-	log.Printf("Kafka Brokers: %s", cfg.KafkaBrokers)
-	log.Printf("MQTT Broker URL: %s", cfg.MQTTBroker)
-	log.Printf("Presense API Endpoint: %s", cfg.PresenseAPIEndpoint)
-	log.Printf("Data Source: %s", cfg.DataSource)
-	log.Printf("DB Path: %s", cfg.DBPath)
-
-	if cfg.PresenseAPIKey != "" {
-		log.Println("Presense API Key: [SET]")
-	} else {
-		log.Println("Presense API Key: [NOT SET]")
+	slog.Info("Service configuration",
+		"kafkaBrokers", cfg.Kafka.Brokers,
+		"subscriptions", subscriptionSummary(cfg.Kafka.ActiveSubscriptions()),
+		"mqttBrokerURL", cfg.MQTT.BrokerURL,
+		"presenseAPIEndpoint", cfg.Destinations.Active().Endpoint,
+		"presenseAuth", cfg.Destinations.Active().Auth.AuthType(),
+		"routes", routeNames(cfg.Routing.Routes),
+		"dataSource", cfg.Destinations.DataSource,
+		"batchSize", cfg.Batching.Size,
+		"allowedFacilities", cfg.Facilities.Allowed,
+		"dbPath", cfg.Database.Path,
+		"adminAddr", cfg.Admin.Addr,
+		"logLevel", cfg.Logging.Level,
+		"logFormat", cfg.Logging.Format,
+		"logFile", cfg.Logging.File,
+		"logPHIPolicy", cfg.Logging.PHIPolicy,
+		"logPHIHashKey", secretState(cfg.Logging.PHIHashKey),
+		"tracingExporter", cfg.Tracing.Exporter,
+		"presenseAPIKey", secretState(cfg.Destinations.Active().APIKey),
+		"adminToken", secretState(cfg.Admin.Token),
+		"mqttPassword", secretState(cfg.MQTT.Password),
+	)
+}
+
+func subscriptionSummary(subs []config.SubscriptionConfig) []string {
+	out := make([]string, len(subs))
+	for i, sub := range subs {
+		out[i] = fmt.Sprintf("%s=%s@%s", sub.Topic, sub.Handler, sub.Consumer)
 	}
+	return out
+}
 
-	if cfg.MQTTPassword != "" {
-		log.Println("MQTT Password: [SET]")
-	} else {
-		log.Println("MQTT Password: [NOT SET]")
+// runValidate implements the "validate" command: it loads the configuration
+// exactly as the service would and reports every problem at once.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a YAML config file (defaults to $CONFIG_FILE)")
+	fs.Parse(args)
+
+	cfg, loadErr := config.Load(*configPath)
+	errs := errors.Join(loadErr, cfg.Validate())
+	if errs == nil {
+		fmt.Println("Configuration is valid.")
+		return 0
+	}
+	fmt.Fprintln(os.Stderr, "Configuration has errors:")
+	for _, line := range strings.Split(errs.Error(), "\n") {
+		fmt.Fprintf(os.Stderr, "  - %s\n", line)
+	}
+	return 1
+}
+
+func routeNames(routes []config.RouteConfig) []string {
+	names := make([]string, len(routes))
+	for i, r := range routes {
+		names[i] = r.Name
+	}
+	return names
+}
+
+func newDeadLetterRecorder(cfg *config.Config, repo *database.Repository) (*deadletter.Recorder, error) {
+	opts := deadletter.Options{
+		Brokers:   cfg.Kafka.Brokers,
+		Topic:     cfg.DeadLetters.KafkaTopic,
+		Retention: cfg.DeadLetters.Retention,
+	}
+	if cfg.DeadLetters.SQLite {
+		opts.Repo = repo
+	}
+	return deadletter.NewRecorder(opts)
+}
+
+// runDeadLetters implements the "deadletters" command for inspecting and
+// replaying dead letters stored in SQLite.
+func runDeadLetters(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: deadletters list [-reason R] [-limit N] [-all] | show <id> | replay [-dry-run] (<id>... | -reason R)")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	fs := flag.NewFlagSet("deadletters "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a YAML config file (defaults to $CONFIG_FILE)")
+	reason := fs.String("reason", "", "only dead letters with this reason")
+	limit := fs.Int("limit", 50, "maximum number of dead letters to list")
+	all := fs.Bool("all", false, "include dead letters that were already replayed")
+	dryRun := fs.Bool("dry-run", false, "show what would be replayed without replaying")
+	fs.Parse(args[1:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+	repo, err := database.NewRepository(cfg.Database.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Opening database: %v\n", err)
+		return 1
+	}
+	defer repo.Close()
+
+	var letters []models.DeadLetter
+	switch {
+	case args[0] == "list", args[0] == "replay" && fs.NArg() == 0 && *reason != "":
+		letters, err = repo.GetDeadLetters(database.DeadLetterFilter{Reason: *reason, IncludeReplayed: *all, Limit: *limit})
+	case (args[0] == "show" || args[0] == "replay") && fs.NArg() > 0:
+		letters, err = deadLettersByID(repo, fs.Args())
+	default:
+		return usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Reading dead letters: %v\n", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		for _, dl := range letters {
+			replayed := ""
+			if dl.ReplayedAt != nil {
+				replayed = " (replayed)"
+			}
+			source := "-"
+			if dl.Topic != "" {
+				source = fmt.Sprintf("%s[%d]@%d", dl.Topic, dl.Partition, dl.Offset)
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s%s\n", dl.ID, time.UnixMilli(dl.CreatedAt).Format(time.RFC3339),
+				dl.Origin, dl.Reason, source, dl.Error, replayed)
+		}
+		return 0
+	case "show":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		for _, dl := range letters {
+			enc.Encode(struct {
+				models.DeadLetter
+				Payload string `json:"payload"`
+			}{dl, string(dl.Payload)})
+		}
+		return 0
 	}
-	log.Println("---------------------------")
+
+	if *dryRun {
+		for _, dl := range letters {
+			fmt.Printf("would replay %d (%s, %s)\n", dl.ID, dl.Origin, dl.Reason)
+		}
+		return 0
+	}
+	opts, _, err := processorOptions(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Resolving routes: %v\n", err)
+		return 1
+	}
+	replayer, err := deadletter.NewReplayer(repo, cfg.Kafka.Brokers,
+		[]sink.Sink{sink.PresenseFromConfig(cfg)},
+		func(facilityID, deviceType string) sink.Destination {
+			route := handler.ResolveRoute(opts, facilityID, deviceType)
+			return route.Destination()
+		})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer replayer.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	failed := 0
+	for _, dl := range letters {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := replayer.Replay(ctx, &dl); err != nil {
+			fmt.Fprintf(os.Stderr, "replay %d failed: %v\n", dl.ID, err)
+			failed++
+			continue
+		}
+		fmt.Printf("replayed %d (%s, %s)\n", dl.ID, dl.Origin, dl.Reason)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func deadLettersByID(repo *database.Repository, ids []string) ([]models.DeadLetter, error) {
+	letters := make([]models.DeadLetter, 0, len(ids))
+	for _, arg := range ids {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", arg)
+		}
+		dl, err := repo.GetDeadLetter(id)
+		if err != nil {
+			return nil, err
+		}
+		if dl == nil {
+			return nil, fmt.Errorf("dead letter %d not found", id)
+		}
+		letters = append(letters, *dl)
+	}
+	return letters, nil
+}
+
+func secretState(value string) string {
+	if value != "" {
+		return "[SET]"
+	}
+	return "[NOT SET]"
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -41,8 +41,9 @@ try {
     
     # Copy all necessary files into the package directory
     Copy-Item -Path ./$BinaryName -Destination $PackageDir
-    # MODIFICATION: Copy .env.prod and rename it to .env for the package.
-    Copy-Item -Path .\.env.prod -Destination "$PackageDir\.env"
+    # Package the production settings as .env; secrets are provisioned on
+    # the target as systemd credentials (see install.sh).
+    Copy-Item -Path .\deploy\production.env -Destination "$PackageDir\.env"
     Copy-Item -Path .\install.sh -Destination $PackageDir
     Copy-Item -Path .\uninstall.sh -Destination $PackageDir
 
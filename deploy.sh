@@ -40,8 +40,20 @@ dos2unix uninstall.sh
 
 # Copy all necessary files into the package directory
 cp ./${BINARY_NAME} "$PACKAGE_DIR/"
-# Copy .env.prod and rename it to .env for the package
-cp ./.env.prod "${PACKAGE_DIR}/.env"
+# Package the production settings as .env. Secrets are provisioned on the
+# target as systemd credentials (see install.sh), never shipped. The guard
+# checks every credential install.sh loads, under its variable name.
+SECRETS=$(sed -n 's/^SECRETS="\(.*\)"$/\1/p' install.sh)
+if [ -z "$SECRETS" ]; then
+    echo "Could not read SECRETS from install.sh" >&2
+    exit 1
+fi
+SECRET_VARS=$(echo "$SECRETS" | tr 'a-z ' 'A-Z|')
+if grep -Eq "^(${SECRET_VARS}|[A-Z_]*_SECRET)=" ./deploy/production.env; then
+    echo "deploy/production.env contains secrets; move them to the target's credentials directory" >&2
+    exit 1
+fi
+cp ./deploy/production.env "${PACKAGE_DIR}/.env"
 cp ./install.sh "$PACKAGE_DIR/"
 # cp ./uninstall.sh "$PACKAGE_DIR/" # Uncomment if you have this file
 
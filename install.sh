@@ -7,6 +7,10 @@ set -e
 SERVICE_NAME="belt-presense"
 BINARY_NAME="belt-presense-svc"
 INSTALL_DIR="/opt/belt-presense"
+# Secrets are read from here and passed to the service as systemd
+# credentials, one file per secret named after the lower-cased variable.
+CREDENTIALS_DIR="/etc/belt-presense/credentials"
+SECRETS="presense_api_key test_api_key presense_client_secret test_client_secret presense_hmac_secret test_hmac_secret mqtt_password admin_token log_phi_hash_key"
 # --- End Configuration ---
 
 SCRIPT_DIR=$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )
@@ -35,6 +39,23 @@ sudo chown -R ubuntu:ubuntu $INSTALL_DIR
 echo "--- Verifying contents of $INSTALL_DIR ---"
 sudo ls -la $INSTALL_DIR
 
+echo "Checking credentials in $CREDENTIALS_DIR..."
+sudo mkdir -p "$CREDENTIALS_DIR"
+sudo chown root:root "$CREDENTIALS_DIR"
+sudo chmod 700 "$CREDENTIALS_DIR"
+LOAD_CREDENTIALS=""
+for secret in $SECRETS; do
+    if sudo test -f "$CREDENTIALS_DIR/$secret"; then
+        sudo chmod 600 "$CREDENTIALS_DIR/$secret"
+        LOAD_CREDENTIALS+="LoadCredential=$secret:$CREDENTIALS_DIR/$secret"$'\n'
+    fi
+done
+if [ -z "$LOAD_CREDENTIALS" ]; then
+    echo "No credentials found in $CREDENTIALS_DIR. Create one file per secret, e.g.:" >&2
+    echo "  sudo install -m 600 /dev/stdin $CREDENTIALS_DIR/presense_api_key" >&2
+    exit 1
+fi
+
 # Create the systemd service file
 echo "Creating systemd service file..."
 sudo tee /etc/systemd/system/$SERVICE_NAME.service > /dev/null <<EOF
@@ -46,7 +67,10 @@ After=network.target
 User=ubuntu
 Group=ubuntu
 WorkingDirectory=$INSTALL_DIR
+Type=notify
+NotifyAccess=main
 ExecStart=$INSTALL_DIR/$BINARY_NAME
+${LOAD_CREDENTIALS}WatchdogSec=60
 Restart=always
 RestartSec=5
 LimitNOFILE=65536
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"belt-presense/internal/config"
+	"belt-presense/internal/database"
+	"belt-presense/internal/handler"
+	"belt-presense/internal/health"
+	"belt-presense/internal/logging"
+	"belt-presense/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Reloader re-reads the configuration file and applies what it can without a
+// restart, returning the settings that changed.
+type Reloader interface {
+	Reload() ([]string, error)
+}
+
+// Server exposes the admin HTTP API used by ward IT to inspect and operate
+// the running service.
+type Server struct {
+	processor  *handler.BeltProcessor
+	db         *database.Repository
+	checker    *health.Checker
+	reloader   Reloader
+	token      string
+	httpServer *http.Server
+}
+
+func NewServer(addr, token string, processor *handler.BeltProcessor, repo *database.Repository, checker *health.Checker, reloader Reloader) *Server {
+	s := &Server{
+		processor: processor,
+		db:        repo,
+		checker:   checker,
+		reloader:  reloader,
+		token:     token,
+	}
+	s.httpServer = &http.Server{
+		Addr:              addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", s.handleLiveness)
+	mux.HandleFunc("GET /readyz", s.handleReadiness)
+	mux.Handle("GET /api/patients", s.requireToken(s.handleListPatients))
+	mux.Handle("GET /api/patients/{patientID}", s.requireToken(s.handleGetPatient))
+	mux.Handle("POST /api/patients/{patientID}/start", s.requireToken(s.handleStartPatient))
+	mux.Handle("POST /api/patients/{patientID}/stop", s.requireToken(s.handleStopPatient))
+	mux.Handle("POST /api/patients/{patientID}/flush", s.requireToken(s.handleFlushPatient))
+	mux.Handle("GET /api/sessions", s.requireToken(s.handleListSessions))
+	mux.Handle("GET /api/delivery/queues", s.requireToken(s.handleDeliveryQueues))
+	mux.Handle("GET /api/deliveries", s.requireToken(s.handleListDeliveries))
+	mux.Handle("GET /api/log-level", s.requireToken(s.handleGetLogLevel))
+	mux.Handle("PUT /api/log-level", s.requireToken(s.handleSetLogLevel))
+	mux.Handle("POST /api/config/reload", s.requireToken(s.handleReloadConfig))
+	return mux
+}
+
+// Run serves until ctx is cancelled, then shuts down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	if s.token == "" {
+		slog.Warn("Admin API: ADMIN_TOKEN not set, /api endpoints are disabled")
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("Admin API listening", "addr", s.httpServer.Addr)
+		errCh <- s.httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return s.httpServer.Shutdown(shutdownCtx)
+	}
+}
+
+func (s *Server) requireToken(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusServiceUnavailable, "admin API disabled: ADMIN_TOKEN not set")
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, s.checker.Live(r.Context()))
+}
+
+func (s *Server) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, s.checker.Ready(r.Context()))
+}
+
+func (s *Server) handleListPatients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.processor.ActivePatients())
+}
+
+func (s *Server) handleGetPatient(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.processor.PatientStatus(r.PathValue("patientID"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "patient not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+type startRequest struct {
+	FacilityID string `json:"facilityId"`
+	PatchID    string `json:"patchId"`
+}
+
+func (s *Server) handleStartPatient(w http.ResponseWriter, r *http.Request) {
+	var req startRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	patientID := r.PathValue("patientID")
+	if err := s.processor.StartPatient(patientID, req.FacilityID, req.PatchID); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.Info("Admin API: started monitoring patient", logging.KeyPatient, patientID)
+	status, _ := s.processor.PatientStatus(patientID)
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleStopPatient(w http.ResponseWriter, r *http.Request) {
+	patientID := r.PathValue("patientID")
+	if err := s.processor.StopPatient(patientID); err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	slog.Info("Admin API: stopped monitoring patient", logging.KeyPatient, patientID)
+	writeJSON(w, http.StatusOK, map[string]string{"patientId": patientID, "status": "stopped"})
+}
+
+func (s *Server) handleFlushPatient(w http.ResponseWriter, r *http.Request) {
+	patientID := r.PathValue("patientID")
+	flushed, err := s.processor.FlushPatient(r.Context(), patientID)
+	if err != nil {
+		writeProcessorError(w, err)
+		return
+	}
+	slog.Info("Admin API: flushed pending packets", logging.KeyPatient, patientID, "packets", flushed)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"patientId": patientID, "flushedPackets": flushed})
+}
+
+func (s *Server) handleDeliveryQueues(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.processor.DeliveryQueues())
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.db.GetSessions(r.URL.Query().Get("status"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// handleListDeliveries lists delivery records newest first, optionally for
+// one patient or result.
+func (s *Server) handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	filter := database.DeliveryFilter{
+		PatientID: r.URL.Query().Get("patientId"),
+		Result:    r.URL.Query().Get("result"),
+		Limit:     100,
+	}
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		filter.Limit = limit
+	}
+	deliveries, err := s.db.GetDeliveries(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.Delivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelRequest{Level: logging.Level()})
+}
+
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if err := logging.SetLevel(req.Level); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.Info("Admin API: log level changed", "level", logging.Level())
+	writeJSON(w, http.StatusOK, logLevelRequest{Level: logging.Level()})
+}
+
+func (s *Server) handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	changed, err := s.reloader.Reload()
+	var restartErr *config.RestartRequiredError
+	switch {
+	case errors.As(err, &restartErr):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"error": err.Error(), "restartRequired": restartErr.Fields})
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.Info("Admin API: configuration reloaded", "changed", changed)
+	if changed == nil {
+		changed = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"changed": changed})
+}
+
+func writeHealthReport(w http.ResponseWriter, report health.Report) {
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeProcessorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, handler.ErrPatientNotActive) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Admin API: error encoding response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
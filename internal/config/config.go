@@ -1,63 +1,386 @@
 package config
 
 import (
-	"log"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	KafkaBrokers string
-	VitalsTopic  string
-	// BPSPO2Topic      string // REMOVED
-	ConsumerGroup       string
-	PresenseAPIKey      string
-	PresenseAPIEndpoint string
-	TestAPIEndpoint     string
-	TestAPIKey          string
-	DataSource          string
-	DBPath              string
-	WriteToFile         bool
-	LogToConsole        bool
-	UseTestURL          bool
-	MQTTBroker          string
-	MQTTClientID        string
-	MQTTUsername        string
-	MQTTPassword        string
-}
-
-func LoadConfig() *Config {
-	err := godotenv.Load() // Looks for ".env" in the current directory
-	if err != nil {
-		log.Println("No .env file found, using environment variables or default values")
+	Kafka        KafkaConfig        `yaml:"kafka"`
+	MQTT         MQTTConfig         `yaml:"mqtt"`
+	Destinations DestinationsConfig `yaml:"destinations"`
+	Routing      RoutingConfig      `yaml:"routing"`
+	Sinks        SinksConfig        `yaml:"sinks"`
+	DeadLetters  DeadLettersConfig  `yaml:"deadLetters"`
+	Deliveries   DeliveriesConfig   `yaml:"deliveries"`
+	FHIR         FHIRConfig         `yaml:"fhir"`
+	HL7          HL7Config          `yaml:"hl7"`
+	Batching     BatchingConfig     `yaml:"batching"`
+	Dedupe       DedupeConfig       `yaml:"dedupe"`
+	Reorder      ReorderConfig      `yaml:"reorder"`
+	Facilities   FacilitiesConfig   `yaml:"facilities"`
+	Database     DatabaseConfig     `yaml:"database"`
+	Logging      LoggingConfig      `yaml:"logging"`
+	Admin        AdminConfig        `yaml:"admin"`
+	Tracing      TracingConfig      `yaml:"tracing"`
+}
+
+type KafkaConfig struct {
+	Brokers string `yaml:"brokers"`
+	// VitalsTopic is consumed with the "vitals" handler when Subscriptions
+	// is empty.
+	VitalsTopic   string               `yaml:"vitalsTopic"`
+	Subscriptions []SubscriptionConfig `yaml:"subscriptions"`
+	ConsumerGroup string               `yaml:"consumerGroup"`
+	// DeviceTypes maps deviceType values to message types (ecg, bpspo2,
+	// temperature) for producers that set neither the message-type header
+	// nor a type field. Both sides are matched without regard to case.
+	DeviceTypes map[string]string `yaml:"deviceTypes"`
+}
+
+// Topic handlers a subscription can use.
+var TopicHandlers = []string{"vitals", "ecg", "bpspo2", "temperature", "control"}
+
+type SubscriptionConfig struct {
+	Topic   string `yaml:"topic"`
+	Handler string `yaml:"handler"`
+	// Consumer names the Kafka consumer that reads the topic; subscriptions
+	// with the same name share one. Defaults to "default".
+	Consumer string `yaml:"consumer"`
+}
+
+// ActiveSubscriptions returns the configured subscriptions, or the vitals
+// topic alone when none are configured.
+func (k KafkaConfig) ActiveSubscriptions() []SubscriptionConfig {
+	subs := k.Subscriptions
+	if len(subs) == 0 {
+		subs = []SubscriptionConfig{{Topic: k.VitalsTopic, Handler: "vitals"}}
+	}
+	out := make([]SubscriptionConfig, len(subs))
+	for i, sub := range subs {
+		if sub.Consumer == "" {
+			sub.Consumer = "default"
+		}
+		out[i] = sub
+	}
+	return out
+}
+
+// consumesTopic reports whether topic is one the service reads.
+func (k KafkaConfig) consumesTopic(topic string) bool {
+	for _, sub := range k.ActiveSubscriptions() {
+		if sub.Topic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+type MQTTConfig struct {
+	BrokerURL string `yaml:"brokerURL"`
+	ClientID  string `yaml:"clientID"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+type DestinationsConfig struct {
+	Presense   EndpointConfig `yaml:"presense"`
+	Test       EndpointConfig `yaml:"test"`
+	UseTest    bool           `yaml:"useTest"`
+	DataSource string         `yaml:"dataSource"`
+	// WriteToFile enables the file sink; kept for existing deployments,
+	// prefer sinks.file.enabled.
+	WriteToFile bool `yaml:"writeToFile"`
+	// Payload applies to the default route.
+	Payload PayloadOptions `yaml:"payload"`
+}
+
+type EndpointConfig struct {
+	Endpoint  string          `yaml:"endpoint"`
+	APIKey    string          `yaml:"apiKey"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Transport TransportConfig `yaml:"transport"`
+}
+
+// TransportConfig tunes the HTTP client used for a destination. Zero values
+// keep Go's defaults.
+type TransportConfig struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile string `yaml:"caFile"`
+	// CertFile and KeyFile are a client certificate for mutual TLS.
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// Proxy is the proxy URL; empty uses HTTPS_PROXY and NO_PROXY from the
+	// environment, and "none" connects directly.
+	Proxy string `yaml:"proxy"`
+	// Timeout bounds a whole request; it defaults to sinks.presense.timeout.
+	Timeout               time.Duration `yaml:"timeout"`
+	DialTimeout           time.Duration `yaml:"dialTimeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tlsHandshakeTimeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"responseHeaderTimeout"`
+	IdleConnTimeout       time.Duration `yaml:"idleConnTimeout"`
+	MaxIdleConns          int           `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost   int           `yaml:"maxIdleConnsPerHost"`
+	MaxConnsPerHost       int           `yaml:"maxConnsPerHost"`
+}
+
+// Auth types a destination can use.
+const (
+	AuthBearer = "bearer"
+	AuthOAuth2 = "oauth2"
+	AuthHMAC   = "hmac"
+)
+
+// AuthConfig selects how requests to a destination are authenticated. The
+// default, bearer, sends the API key as a bearer token.
+type AuthConfig struct {
+	Type string `yaml:"type"`
+	// OAuth2 client credentials grant.
+	TokenURL         string   `yaml:"tokenURL"`
+	ClientID         string   `yaml:"clientID"`
+	ClientSecret     string   `yaml:"clientSecret"`
+	ClientSecretFile string   `yaml:"clientSecretFile"`
+	Scopes           []string `yaml:"scopes"`
+	// RefreshBefore renews a cached token this long before it expires.
+	RefreshBefore time.Duration `yaml:"refreshBefore"`
+	// HMAC request signing.
+	KeyID      string `yaml:"keyID"`
+	Secret     string `yaml:"secret"`
+	SecretFile string `yaml:"secretFile"`
+}
+
+// AuthType returns Type, or bearer when it is unset.
+func (a AuthConfig) AuthType() string {
+	if a.Type == "" {
+		return AuthBearer
+	}
+	return a.Type
+}
+
+// Active returns the endpoint selected by UseTest.
+func (d DestinationsConfig) Active() EndpointConfig {
+	if d.UseTest {
+		return d.Test
 	}
+	return d.Presense
+}
+
+type DeadLettersConfig struct {
+	// SQLite stores dead letters in the database, where the deadletters
+	// command can list and replay them.
+	SQLite bool `yaml:"sqlite"`
+	// KafkaTopic, when set, also publishes dead letters to this topic.
+	KafkaTopic string        `yaml:"kafkaTopic"`
+	Retention  time.Duration `yaml:"retention"`
+}
 
+type DeliveriesConfig struct {
+	// Record stores the outcome and decoded response of every batch sent to
+	// the Presense API in the deliveries table.
+	Record    bool          `yaml:"record"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+// FHIRConfig shapes the bundles sent by sinks with format fhir.
+type FHIRConfig struct {
+	// BundleType is transaction or collection.
+	BundleType string `yaml:"bundleType"`
+	// PatientSystem, EncounterSystem and DeviceSystem are the identifier
+	// systems the receiving server knows patient, admission and device IDs
+	// by.
+	PatientSystem   string `yaml:"patientSystem"`
+	EncounterSystem string `yaml:"encounterSystem"`
+	DeviceSystem    string `yaml:"deviceSystem"`
+	// ECGSampleRate is the belt's ECG sampling rate in Hz. Zero leaves the
+	// waveform out.
+	ECGSampleRate float64 `yaml:"ecgSampleRate"`
+}
+
+// HL7Config fills the header of the messages sent by sinks with format
+// hl7v2.
+type HL7Config struct {
+	SendingApplication string `yaml:"sendingApplication"`
+	// SendingFacility defaults to the batch's facility ID.
+	SendingFacility      string `yaml:"sendingFacility"`
+	ReceivingApplication string `yaml:"receivingApplication"`
+	ReceivingFacility    string `yaml:"receivingFacility"`
+	// ProcessingID is P (production), T (training) or D (debugging).
+	ProcessingID string `yaml:"processingID"`
+	// PatientIDAuthority is the assigning authority of patient IDs.
+	PatientIDAuthority string `yaml:"patientIDAuthority"`
+}
+
+type BatchingConfig struct {
+	Size int `yaml:"size"`
+}
+
+type DedupeConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is how many recent packets per patient are remembered.
+	Window int `yaml:"window"`
+	// SnapshotInterval is how often windows are saved to the database so
+	// they survive a restart.
+	SnapshotInterval time.Duration `yaml:"snapshotInterval"`
+}
+
+type ReorderConfig struct {
+	// MaxWait is how long packets are held for a missing earlier packet
+	// before it is given up on. Zero disables reordering.
+	MaxWait time.Duration `yaml:"maxWait"`
+	// MaxPackets caps the packets held per patient.
+	MaxPackets int `yaml:"maxPackets"`
+	// LatePolicy handles packets arriving after later ones were released:
+	// drop, next (append to the next batch) or standalone.
+	LatePolicy string `yaml:"latePolicy"`
+}
+
+type FacilitiesConfig struct {
+	// Allowed restricts which facilities may start monitoring sessions.
+	// Empty allows every facility.
+	Allowed []string `yaml:"allowed"`
+}
+
+type DatabaseConfig struct {
+	Path string `yaml:"path"`
+}
+
+type LoggingConfig struct {
+	Level     string   `yaml:"level"`
+	Format    string   `yaml:"format"`
+	File      string   `yaml:"file"`
+	Console   bool     `yaml:"console"`
+	PHIPolicy string   `yaml:"phiPolicy"`
+	PHIFields []string `yaml:"phiFields"`
+	// PHIHashKey keys the hash policy's HMAC; prefer LOG_PHI_HASH_KEY.
+	PHIHashKey string `yaml:"phiHashKey"`
+}
+
+type AdminConfig struct {
+	// Addr is where the admin, metrics and health server listens. It
+	// defaults to loopback only; listening on other interfaces is opt-in.
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+func Defaults() *Config {
 	return &Config{
-		KafkaBrokers: getEnv("KAFKA_BROKERS", "localhost:9092"),
-		VitalsTopic:  getEnv("VITALS_TOPIC", "patient-vitals-data-topic"),
-		// BPSPO2Topic:      getEnv("BPSPO2_TOPIC", "patient-bpspo2-data-topic"), // REMOVED
-		ConsumerGroup:       getEnv("CONSUMER_GROUP", "belt_presense"),
-		PresenseAPIEndpoint: getEnv("PRESENSE_API_ENDPOINT", "https://vitals.presense.icu/data"),
-		PresenseAPIKey:      getEnv("PRESENSE_API_KEY", "PT1YzV4wGK1OsZSmGDMBIaYC7muCIu6f3Dnl4qOO"),
-		TestAPIEndpoint:     getEnv("TEST_API_ENDPOINT", "https://staging-vitals.presense.icu/data"),
-		TestAPIKey:          getEnv("TEST_API_KEY", "814xqfGJWSVfKgfFOvQz24MLAuRsuDA3"),
-		DataSource:          getEnv("DATA_SOURCE", "DefaultSource"),
-		DBPath:              getEnv("DB_PATH", "presense.db"),
-		WriteToFile:         strings.EqualFold(getEnv("WRITE_TO_FILE", "false"), "true"),
-		LogToConsole:        strings.EqualFold(getEnv("LOG_TO_CONSOLE", "false"), "true"),
-		UseTestURL:          strings.EqualFold(getEnv("USE_TEST_URL", "false"), "true"),
-		MQTTBroker:          getEnv("MQTT_BROKER_URL", "tcp://localhost:1883"),
-		MQTTClientID:        getEnv("MQTT_CLIENT_ID", "MqttCallService_local"),
-		MQTTUsername:        getEnv("MQTT_USERNAME", ""),
-		MQTTPassword:        getEnv("MQTT_PASSWORD", ""),
-	}
-}
-
-func getEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
-	}
-	return fallback
+		Kafka: KafkaConfig{
+			Brokers:       "localhost:9092",
+			VitalsTopic:   "patient-vitals-data-topic",
+			ConsumerGroup: "belt_presense",
+		},
+		MQTT: MQTTConfig{
+			BrokerURL: "tcp://localhost:1883",
+			ClientID:  "MqttCallService_local",
+		},
+		Destinations: DestinationsConfig{
+			Presense:   EndpointConfig{Endpoint: "https://vitals.presense.icu/data"},
+			Test:       EndpointConfig{Endpoint: "https://staging-vitals.presense.icu/data"},
+			DataSource: "DefaultSource",
+		},
+		Sinks: defaultSinks(),
+		DeadLetters: DeadLettersConfig{
+			SQLite:    true,
+			Retention: 30 * 24 * time.Hour,
+		},
+		Deliveries: DeliveriesConfig{
+			Record:    true,
+			Retention: 7 * 24 * time.Hour,
+		},
+		FHIR: FHIRConfig{
+			BundleType:      "transaction",
+			PatientSystem:   "urn:belt-presense:patient",
+			EncounterSystem: "urn:belt-presense:admission",
+			DeviceSystem:    "urn:belt-presense:device",
+			ECGSampleRate:   125,
+		},
+		HL7: HL7Config{
+			SendingApplication: "BELT_PRESENSE",
+			ProcessingID:       "P",
+		},
+		Batching: BatchingConfig{Size: 30},
+		Dedupe: DedupeConfig{
+			Enabled:          true,
+			Window:           1000,
+			SnapshotInterval: 5 * time.Second,
+		},
+		Reorder: ReorderConfig{
+			MaxWait:    2 * time.Second,
+			MaxPackets: 50,
+			LatePolicy: "next",
+		},
+		Database: DatabaseConfig{Path: "presense.db"},
+		Logging: LoggingConfig{
+			Level:     "info",
+			Format:    "text",
+			File:      "./logs/presense.log",
+			PHIPolicy: "mask",
+		},
+		Admin: AdminConfig{Addr: "127.0.0.1:8090"},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1.0,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, then the optional YAML file
+// at path, then environment variables (including a .env file in the working
+// directory). Unknown YAML keys and unparseable environment values are
+// reported together, alongside a best-effort Config; call Validate on the
+// result for semantic checks.
+func Load(path string) (*Config, error) {
+	if err := godotenv.Load(); err != nil {
+		slog.Info("No .env file found, using environment variables or default values")
+	}
+
+	cfg := Defaults()
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	var fileErr error
+	if path != "" {
+		fileErr = cfg.loadFile(path)
+	}
+	return cfg, errors.Join(fileErr, cfg.applyEnv())
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(c)
+	var typeErr *yaml.TypeError
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return nil
+	case errors.As(err, &typeErr):
+		errs := make([]error, len(typeErr.Errors))
+		for i, msg := range typeErr.Errors {
+			errs[i] = fmt.Errorf("%s: %s", path, msg)
+		}
+		return errors.Join(errs...)
+	default:
+		return fmt.Errorf("%s: %w", path, err)
+	}
 }
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// restartRequired lists the settings, by path or section prefix, that are
+// read once at start-up and so cannot be changed by a reload.
+var restartRequired = []string{
+	"kafka.brokers",
+	"kafka.vitalsTopic",
+	"kafka.subscriptions",
+	"kafka.consumerGroup",
+	"mqtt.",
+	"database.",
+	"admin.",
+	"tracing.",
+	"sinks.",
+	"deadLetters.",
+	"deliveries.",
+	"fhir.",
+	"hl7.",
+	"dedupe.",
+	"destinations.writeToFile",
+	"logging.format",
+	"logging.file",
+	"logging.console",
+	"logging.phiPolicy",
+	"logging.phiFields",
+	"logging.phiHashKey",
+}
+
+// Diff returns the dotted paths (as used in the YAML file) of every setting
+// that differs between a and b.
+func Diff(a, b *Config) []string {
+	fa, fb := flatten(a), flatten(b)
+	var changed []string
+	for path, va := range fa {
+		if !reflect.DeepEqual(va, fb[path]) {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// RequiresRestart filters paths down to those a reload cannot apply.
+func RequiresRestart(paths []string) []string {
+	var blocked []string
+	for _, path := range paths {
+		for _, prefix := range restartRequired {
+			if path == prefix || (strings.HasSuffix(prefix, ".") && strings.HasPrefix(path, prefix)) {
+				blocked = append(blocked, path)
+				break
+			}
+		}
+	}
+	return blocked
+}
+
+func flatten(c *Config) map[string]interface{} {
+	out := make(map[string]interface{})
+	flattenValue("", reflect.ValueOf(c).Elem(), out)
+	return out
+}
+
+func flattenValue(prefix string, v reflect.Value, out map[string]interface{}) {
+	if v.Kind() != reflect.Struct {
+		out[prefix] = v.Interface()
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if field.Anonymous && len(tag) > 1 && tag[1] == "inline" {
+			flattenValue(prefix, v.Field(i), out)
+			continue
+		}
+		name := tag[0]
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		flattenValue(path, v.Field(i), out)
+	}
+}
+
+// RestartRequiredError rejects a reload that touches settings only read at
+// start-up.
+type RestartRequiredError struct {
+	Fields []string
+}
+
+func (e *RestartRequiredError) Error() string {
+	return fmt.Sprintf("changes to %s require a restart; nothing was applied", strings.Join(e.Fields, ", "))
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		changed []string
+		restart []string
+	}{
+		{"nothing", func(c *Config) {}, nil, nil},
+		{"batch size reloads", func(c *Config) { c.Batching.Size = 10 }, []string{"batching.size"}, nil},
+		{"log level reloads", func(c *Config) { c.Logging.Level = "debug" }, []string{"logging.level"}, nil},
+		{"allowed facilities reload", func(c *Config) { c.Facilities.Allowed = []string{"F-1"} },
+			[]string{"facilities.allowed"}, nil},
+		{"destination key reloads", func(c *Config) { c.Destinations.Presense.APIKey = "new" },
+			[]string{"destinations.presense.apiKey"}, nil},
+		{"write to file needs a restart", func(c *Config) { c.Destinations.WriteToFile = true },
+			[]string{"destinations.writeToFile"}, []string{"destinations.writeToFile"}},
+		{"inline sink options use the YAML path", func(c *Config) { c.Sinks.Presense.Workers = 8 },
+			[]string{"sinks.presense.workers"}, []string{"sinks.presense.workers"}},
+		{"a section prefix matches every field in it", func(c *Config) {
+			c.MQTT.ClientID = "other"
+			c.Database.Path = "other.db"
+		}, []string{"database.path", "mqtt.clientID"}, []string{"database.path", "mqtt.clientID"}},
+		{"device types reload", func(c *Config) { c.Kafka.DeviceTypes = map[string]string{"x": "ecg"} },
+			[]string{"kafka.deviceTypes"}, nil},
+		{"PHI hash key needs a restart", func(c *Config) { c.Logging.PHIHashKey = "k" },
+			[]string{"logging.phiHashKey"}, []string{"logging.phiHashKey"}},
+		{"mixed", func(c *Config) {
+			c.Reorder.MaxWait = time.Second
+			c.Dedupe.Window = 10
+			c.Kafka.Brokers = "other:9092"
+		}, []string{"dedupe.window", "kafka.brokers", "reorder.maxWait"}, []string{"dedupe.window", "kafka.brokers"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := Defaults(), Defaults()
+			tt.modify(b)
+			changed := Diff(a, b)
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("Diff() = %v, want %v", changed, tt.changed)
+			}
+			if restart := RequiresRestart(changed); !reflect.DeepEqual(restart, tt.restart) {
+				t.Errorf("RequiresRestart() = %v, want %v", restart, tt.restart)
+			}
+		})
+	}
+}
+
+func TestFlattenUsesYAMLPaths(t *testing.T) {
+	paths := flatten(Defaults())
+	for _, path := range []string{"kafka.brokers", "sinks.kafka.messageTimeout", "sinks.mllp.ackTimeout", "logging.phiFields", "fhir.bundleType"} {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("flatten() has no %s", path)
+		}
+	}
+}
+
+func TestRestartRequiredError(t *testing.T) {
+	var err error = &RestartRequiredError{Fields: []string{"kafka.brokers", "sinks.presense.workers"}}
+	var target *RestartRequiredError
+	if !errors.As(err, &target) || len(target.Fields) != 2 {
+		t.Fatalf("errors.As(%v) failed", err)
+	}
+	if got, want := err.Error(), "changes to kafka.brokers, sinks.presense.workers require a restart; nothing was applied"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
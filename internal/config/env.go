@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type envBinding struct {
+	key   string
+	apply func(string) error
+}
+
+// secretKeys may also be read from KEY_FILE or the secrets directory, so
+// credentials need not live in .env.
+var secretKeys = map[string]bool{
+	"MQTT_PASSWORD":          true,
+	"PRESENSE_API_KEY":       true,
+	"PRESENSE_CLIENT_SECRET": true,
+	"PRESENSE_HMAC_SECRET":   true,
+	"TEST_API_KEY":           true,
+	"TEST_CLIENT_SECRET":     true,
+	"TEST_HMAC_SECRET":       true,
+	"ADMIN_TOKEN":            true,
+	"LOG_PHI_HASH_KEY":       true,
+}
+
+// envBindings maps each supported environment variable onto its config
+// field. Environment values override the config file.
+func (c *Config) envBindings() []envBinding {
+	return []envBinding{
+		{"KAFKA_BROKERS", stringVar(&c.Kafka.Brokers)},
+		{"VITALS_TOPIC", stringVar(&c.Kafka.VitalsTopic)},
+		{"CONSUMER_GROUP", stringVar(&c.Kafka.ConsumerGroup)},
+		{"MQTT_BROKER_URL", stringVar(&c.MQTT.BrokerURL)},
+		{"MQTT_CLIENT_ID", stringVar(&c.MQTT.ClientID)},
+		{"MQTT_USERNAME", stringVar(&c.MQTT.Username)},
+		{"MQTT_PASSWORD", stringVar(&c.MQTT.Password)},
+		{"PRESENSE_API_ENDPOINT", stringVar(&c.Destinations.Presense.Endpoint)},
+		{"PRESENSE_API_KEY", stringVar(&c.Destinations.Presense.APIKey)},
+		{"PRESENSE_CLIENT_SECRET", stringVar(&c.Destinations.Presense.Auth.ClientSecret)},
+		{"PRESENSE_HMAC_SECRET", stringVar(&c.Destinations.Presense.Auth.Secret)},
+		{"TEST_API_ENDPOINT", stringVar(&c.Destinations.Test.Endpoint)},
+		{"TEST_API_KEY", stringVar(&c.Destinations.Test.APIKey)},
+		{"TEST_CLIENT_SECRET", stringVar(&c.Destinations.Test.Auth.ClientSecret)},
+		{"TEST_HMAC_SECRET", stringVar(&c.Destinations.Test.Auth.Secret)},
+		{"USE_TEST_URL", boolVar(&c.Destinations.UseTest)},
+		{"DATA_SOURCE", stringVar(&c.Destinations.DataSource)},
+		{"WRITE_TO_FILE", boolVar(&c.Destinations.WriteToFile)},
+		{"BATCH_SIZE", intVar(&c.Batching.Size)},
+		{"ALLOWED_FACILITIES", listVar(&c.Facilities.Allowed)},
+		{"DB_PATH", stringVar(&c.Database.Path)},
+		{"DEAD_LETTER_TOPIC", stringVar(&c.DeadLetters.KafkaTopic)},
+		{"LOG_LEVEL", stringVar(&c.Logging.Level)},
+		{"LOG_FORMAT", stringVar(&c.Logging.Format)},
+		{"LOG_FILE", stringVar(&c.Logging.File)},
+		{"LOG_TO_CONSOLE", boolVar(&c.Logging.Console)},
+		{"LOG_PHI_POLICY", stringVar(&c.Logging.PHIPolicy)},
+		{"LOG_PHI_FIELDS", listVar(&c.Logging.PHIFields)},
+		{"LOG_PHI_HASH_KEY", stringVar(&c.Logging.PHIHashKey)},
+		{"ADMIN_ADDR", stringVar(&c.Admin.Addr)},
+		{"ADMIN_TOKEN", stringVar(&c.Admin.Token)},
+		{"TRACING_EXPORTER", stringVar(&c.Tracing.Exporter)},
+		{"TRACING_OTLP_ENDPOINT", stringVar(&c.Tracing.Endpoint)},
+		{"TRACING_OTLP_INSECURE", boolVar(&c.Tracing.Insecure)},
+		{"TRACING_SAMPLE_RATIO", floatVar(&c.Tracing.SampleRatio)},
+	}
+}
+
+func (c *Config) applyEnv() error {
+	secretsDir := secretsDirectory()
+	var errs []error
+	for _, b := range c.envBindings() {
+		value, ok := os.LookupEnv(b.key)
+		if secretKeys[b.key] {
+			var err error
+			value, ok, err = lookupSecret(b.key, secretsDir)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := b.apply(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// secretsDirectory is SECRETS_DIR if set, otherwise the directory systemd
+// populates from LoadCredential= directives.
+func secretsDirectory() string {
+	if dir := os.Getenv("SECRETS_DIR"); dir != "" {
+		return dir
+	}
+	return os.Getenv("CREDENTIALS_DIRECTORY")
+}
+
+// lookupSecret resolves a secret from, in order: the KEY variable, a file
+// named by KEY_FILE, or a file in dir named after the lower-cased key (e.g.
+// presense_api_key). Setting both KEY and KEY_FILE is an error.
+func lookupSecret(key, dir string) (string, bool, error) {
+	value, hasValue := os.LookupEnv(key)
+	path, hasFile := os.LookupEnv(key + "_FILE")
+	switch {
+	case hasValue && hasFile:
+		return "", false, fmt.Errorf("%s and %s_FILE are both set; use one", key, key)
+	case hasValue:
+		return value, true, nil
+	case hasFile:
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", key, err)
+		}
+		return secret, true, nil
+	}
+
+	if dir == "" {
+		return "", false, nil
+	}
+	path = filepath.Join(dir, strings.ToLower(key))
+	secret, err := readSecretFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %w", key, err)
+	}
+	return secret, true, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func stringVar(dst *string) func(string) error {
+	return func(v string) error {
+		*dst = v
+		return nil
+	}
+}
+
+func boolVar(dst *bool) func(string) error {
+	return func(v string) error {
+		parsed, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+		*dst = parsed
+		return nil
+	}
+}
+
+func intVar(dst *int) func(string) error {
+	return func(v string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*dst = parsed
+		return nil
+	}
+}
+
+func floatVar(dst *float64) func(string) error {
+	return func(v string) error {
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*dst = parsed
+		return nil
+	}
+}
+
+func listVar(dst *[]string) func(string) error {
+	return func(v string) error {
+		var values []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		*dst = values
+		return nil
+	}
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type RoutingConfig struct {
+	// Routes are matched in order; the first whose facilities and device
+	// types both match a batch wins. Batches matching no route use the
+	// default route built from the destinations section.
+	Routes []RouteConfig `yaml:"routes"`
+}
+
+type RouteConfig struct {
+	Name        string   `yaml:"name"`
+	Facilities  []string `yaml:"facilities"`
+	DeviceTypes []string `yaml:"deviceTypes"`
+	// Destination reuses the endpoint, API key, auth and transport of
+	// destinations.presense or destinations.test. Endpoint, APIKey,
+	// APIKeyFile, Auth and Transport override it.
+	Destination string          `yaml:"destination"`
+	Endpoint    string          `yaml:"endpoint"`
+	APIKey      string          `yaml:"apiKey"`
+	APIKeyFile  string          `yaml:"apiKeyFile"`
+	Auth        AuthConfig      `yaml:"auth"`
+	Transport   TransportConfig `yaml:"transport"`
+	DataSource  string          `yaml:"dataSource"`
+	Payload     PayloadOptions  `yaml:"payload"`
+}
+
+// PayloadOptions trims the Presense payload for destinations that must not
+// receive some of it. The zero value sends everything.
+type PayloadOptions struct {
+	OmitPatientDetails bool `yaml:"omitPatientDetails"`
+	OmitVitals         bool `yaml:"omitVitals"`
+	OmitArrhythmia     bool `yaml:"omitArrhythmia"`
+	// MarkGaps adds a gap marker to sensor data items that follow missing
+	// packets.
+	MarkGaps bool `yaml:"markGaps"`
+}
+
+// DefaultRoute is the route used for batches no configured route matches,
+// with its auth secret files read.
+func (c *Config) DefaultRoute() (RouteConfig, error) {
+	active := c.Destinations.Active()
+	auth, err := resolveAuth(active.Auth)
+	if err != nil {
+		name := "presense"
+		if c.Destinations.UseTest {
+			name = "test"
+		}
+		err = fmt.Errorf("destinations.%s.auth.%w", name, err)
+	}
+	return RouteConfig{
+		Name:       "default",
+		Endpoint:   active.Endpoint,
+		APIKey:     active.APIKey,
+		Auth:       auth,
+		Transport:  active.Transport,
+		DataSource: c.Destinations.DataSource,
+		Payload:    c.Destinations.Payload,
+	}, err
+}
+
+// resolveAuth reads a's secret files and defaults how early OAuth2 tokens
+// are refreshed.
+func resolveAuth(a AuthConfig) (AuthConfig, error) {
+	var errs []error
+	if a.ClientSecretFile != "" {
+		secret, err := readSecretFile(a.ClientSecretFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("clientSecretFile: %w", err))
+		}
+		a.ClientSecret = secret
+	}
+	if a.SecretFile != "" {
+		secret, err := readSecretFile(a.SecretFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("secretFile: %w", err))
+		}
+		a.Secret = secret
+	}
+	if a.AuthType() == AuthOAuth2 && a.RefreshBefore == 0 {
+		a.RefreshBefore = time.Minute
+	}
+	return a, errors.Join(errs...)
+}
+
+// ResolvedRoutes returns the configured routes with destination references,
+// key files and the default data source filled in.
+func (c *Config) ResolvedRoutes() ([]RouteConfig, error) {
+	routes := make([]RouteConfig, len(c.Routing.Routes))
+	var errs []error
+	for i, r := range c.Routing.Routes {
+		var base EndpointConfig
+		switch strings.ToLower(r.Destination) {
+		case "presense":
+			base = c.Destinations.Presense
+		case "test":
+			base = c.Destinations.Test
+		}
+		if r.Endpoint == "" {
+			r.Endpoint = base.Endpoint
+		}
+		if r.APIKeyFile != "" {
+			key, err := readSecretFile(r.APIKeyFile)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("routing.routes[%d].apiKeyFile: %w", i, err))
+			}
+			r.APIKey = key
+		}
+		if r.APIKey == "" {
+			r.APIKey = base.APIKey
+		}
+		if r.Auth.Type == "" {
+			r.Auth = base.Auth
+		}
+		auth, err := resolveAuth(r.Auth)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("routing.routes[%d].auth.%w", i, err))
+		}
+		r.Auth = auth
+		if r.Transport == (TransportConfig{}) {
+			r.Transport = base.Transport
+		}
+		if r.DataSource == "" {
+			r.DataSource = c.Destinations.DataSource
+		}
+		routes[i] = r
+	}
+	return routes, errors.Join(errs...)
+}
+
+func (c *Config) validateRouting() error {
+	routes, err := c.ResolvedRoutes()
+	errs := []error{err}
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	names := make(map[string]bool, len(routes))
+	for i, r := range routes {
+		field := fmt.Sprintf("routing.routes[%d]", i)
+		if r.Name == "" {
+			add(field+".name", "must not be empty")
+		} else if names[r.Name] {
+			add(field+".name", "duplicate route name %q", r.Name)
+		}
+		names[r.Name] = true
+		if len(r.Facilities) == 0 && len(r.DeviceTypes) == 0 {
+			add(field, "must list facilities, deviceTypes or both")
+		}
+		if r.Destination != "" && !oneOf(strings.ToLower(r.Destination), "presense", "test") {
+			add(field+".destination", "must be presense or test; got %q", r.Destination)
+		}
+		if c.Routing.Routes[i].APIKey != "" && r.APIKeyFile != "" {
+			add(field+".apiKey", "apiKey and apiKeyFile are both set; use one")
+		}
+		if err := checkURL(r.Endpoint, "http", "https"); err != nil {
+			add(field+".endpoint", "%v", err)
+		}
+		if c.Routing.Routes[i].Auth.ClientSecret != "" && r.Auth.ClientSecretFile != "" {
+			add(field+".auth.clientSecret", "clientSecret and clientSecretFile are both set; use one")
+		}
+		if c.Routing.Routes[i].Auth.Secret != "" && r.Auth.SecretFile != "" {
+			add(field+".auth.secret", "secret and secretFile are both set; use one")
+		}
+		if r.Auth.AuthType() == AuthBearer && r.APIKey == "" {
+			add(field+".apiKey", "must be set directly, via apiKeyFile or by its destination")
+		}
+		errs = append(errs, validateAuth(field+".auth", r.Auth), validateTransport(field+".transport", r.Transport))
+	}
+	return errors.Join(errs...)
+}
+
+// validateAuth checks that a has what its type needs. The bearer API key is
+// checked by the caller, which knows where it may come from.
+func validateAuth(field string, a AuthConfig) error {
+	var errs []error
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, name, fmt.Sprintf(format, args...)))
+	}
+	switch a.AuthType() {
+	case AuthBearer:
+	case AuthOAuth2:
+		if err := checkURL(a.TokenURL, "http", "https"); err != nil {
+			add("tokenURL", "%v", err)
+		}
+		if a.ClientID == "" {
+			add("clientID", "must be set for oauth2")
+		}
+		if a.ClientSecret == "" {
+			add("clientSecret", "must be set for oauth2, directly or via clientSecretFile")
+		}
+		if a.RefreshBefore < 0 {
+			add("refreshBefore", "must not be negative")
+		}
+	case AuthHMAC:
+		if a.Secret == "" {
+			add("secret", "must be set for hmac, directly or via secretFile")
+		}
+	default:
+		add("type", "must be bearer, oauth2 or hmac; got %q", a.Type)
+	}
+	return errors.Join(errs...)
+}
+
+// validateTransport checks t's settings and that its certificate files load.
+func validateTransport(field string, t TransportConfig) error {
+	var errs []error
+	add := func(name, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, name, fmt.Sprintf(format, args...)))
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			add("caFile", "%v", err)
+		} else if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			add("caFile", "no PEM certificates in %s", t.CAFile)
+		}
+	}
+	switch {
+	case (t.CertFile == "") != (t.KeyFile == ""):
+		add("certFile", "certFile and keyFile must be set together")
+	case t.CertFile != "":
+		if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			add("certFile", "%v", err)
+		}
+	}
+	if t.Proxy != "" && t.Proxy != "none" {
+		if err := checkURL(t.Proxy, "http", "https", "socks5"); err != nil {
+			add("proxy", "%v", err)
+		}
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"timeout", t.Timeout},
+		{"dialTimeout", t.DialTimeout},
+		{"tlsHandshakeTimeout", t.TLSHandshakeTimeout},
+		{"responseHeaderTimeout", t.ResponseHeaderTimeout},
+		{"idleConnTimeout", t.IdleConnTimeout},
+	} {
+		if d.value < 0 {
+			add(d.name, "must not be negative")
+		}
+	}
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"maxIdleConns", t.MaxIdleConns},
+		{"maxIdleConnsPerHost", t.MaxIdleConnsPerHost},
+		{"maxConnsPerHost", t.MaxConnsPerHost},
+	} {
+		if n.value < 0 {
+			add(n.name, "must not be negative, got %d", n.value)
+		}
+	}
+	return errors.Join(errs...)
+}
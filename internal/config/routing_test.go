@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolvedRoutes(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "pilot-key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Defaults()
+	c.Destinations.DataSource = "Belt"
+	c.Destinations.Presense = EndpointConfig{Endpoint: "https://presense.example.com/api", APIKey: "prod-key",
+		Transport: TransportConfig{CAFile: "prod-ca.pem"}}
+	c.Destinations.Test = EndpointConfig{Endpoint: "https://test.example.com/api", APIKey: "test-key",
+		Auth:      AuthConfig{Type: AuthOAuth2, TokenURL: "https://test.example.com/token", ClientID: "belt"},
+		Transport: TransportConfig{CAFile: "test-ca.pem", Proxy: "none"}}
+	c.Routing.Routes = []RouteConfig{
+		{Name: "inherits", Facilities: []string{"F-1"}, Destination: "Test"},
+		{Name: "overrides", Facilities: []string{"F-2"}, Destination: "test", APIKeyFile: keyFile,
+			Auth:      AuthConfig{Type: AuthHMAC, Secret: "s"},
+			Transport: TransportConfig{Timeout: time.Second}, DataSource: "Pilot"},
+		{Name: "own endpoint", Facilities: []string{"F-3"}, Endpoint: "https://other.example.com/api", APIKey: "own-key"},
+	}
+
+	routes, err := c.ResolvedRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherits, overrides, own := routes[0], routes[1], routes[2]
+
+	if inherits.Endpoint != "https://test.example.com/api" || inherits.APIKey != "test-key" || inherits.DataSource != "Belt" {
+		t.Errorf("inherited route = %+v", inherits)
+	}
+	if inherits.Auth.AuthType() != AuthOAuth2 || inherits.Auth.ClientID != "belt" || inherits.Auth.RefreshBefore != time.Minute {
+		t.Errorf("inherited auth = %+v, want the test destination's OAuth2 with the default refresh", inherits.Auth)
+	}
+	if inherits.Transport != c.Destinations.Test.Transport {
+		t.Errorf("inherited transport = %+v", inherits.Transport)
+	}
+
+	if overrides.APIKey != "file-key" {
+		t.Errorf("API key = %q, want the key file's contents", overrides.APIKey)
+	}
+	if overrides.Auth.AuthType() != AuthHMAC || overrides.Auth.ClientID != "" {
+		t.Errorf("auth = %+v, want only the route's own", overrides.Auth)
+	}
+	// A transport replaces the destination's as a whole.
+	if overrides.Transport != (TransportConfig{Timeout: time.Second}) || overrides.DataSource != "Pilot" {
+		t.Errorf("overriding route = %+v", overrides)
+	}
+
+	if own.Endpoint != "https://other.example.com/api" || own.APIKey != "own-key" || own.Transport != (TransportConfig{}) || own.Auth.AuthType() != AuthBearer {
+		t.Errorf("route with its own endpoint = %+v", own)
+	}
+}
+
+func TestResolvedRoutesMissingKeyFile(t *testing.T) {
+	c := Defaults()
+	c.Routing.Routes = []RouteConfig{{Name: "pilot", Endpoint: "https://other.example.com/api", APIKeyFile: filepath.Join(t.TempDir(), "missing")}}
+	_, err := c.ResolvedRoutes()
+	if err == nil || !strings.Contains(err.Error(), "routing.routes[0].apiKeyFile") {
+		t.Errorf("ResolvedRoutes() = %v, want an apiKeyFile error", err)
+	}
+}
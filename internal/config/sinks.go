@@ -0,0 +1,263 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// SinksConfig enables the outputs each processed batch is delivered to.
+// Every sink has its own queue, workers and retry policy.
+type SinksConfig struct {
+	Presense PresenseSinkConfig `yaml:"presense"`
+	File     FileSinkConfig     `yaml:"file"`
+	MQTT     MQTTSinkConfig     `yaml:"mqtt"`
+	Webhook  WebhookSinkConfig  `yaml:"webhook"`
+	Kafka    KafkaSinkConfig    `yaml:"kafka"`
+	MLLP     MLLPSinkConfig     `yaml:"mllp"`
+}
+
+// Sink output formats.
+const (
+	FormatPresense = "presense"
+	FormatFHIR     = "fhir"
+	FormatHL7      = "hl7v2"
+)
+
+type SinkOptions struct {
+	Enabled bool `yaml:"enabled"`
+	// Format is what the sink sends: the Presense payload, a FHIR R4 Bundle
+	// of Observations or an HL7 v2 ORU^R01 message. The Presense sink only
+	// sends its own format, and the MLLP sink only HL7 v2.
+	Format    string `yaml:"format"`
+	QueueSize int    `yaml:"queueSize"`
+	// Workers is how many patients are delivered to in parallel; each
+	// patient's batches are always sent one at a time, in order.
+	Workers int         `yaml:"workers"`
+	Retry   RetryConfig `yaml:"retry"`
+	// MaxQueueAge evicts batches that have waited longer, oldest first.
+	// Zero lets batches wait indefinitely.
+	MaxQueueAge time.Duration `yaml:"maxQueueAge"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
+type PresenseSinkConfig struct {
+	SinkOptions `yaml:",inline"`
+	Timeout     time.Duration `yaml:"timeout"`
+	// MaxInFlight caps concurrent requests across all destinations; workers
+	// beyond it wait, so one throttled destination does not hold up others.
+	MaxInFlight int             `yaml:"maxInFlight"`
+	RateLimit   RateLimitConfig `yaml:"rateLimit"`
+}
+
+// RateLimitConfig is a token bucket applied to each destination endpoint.
+// A zero PerSecond disables it.
+type RateLimitConfig struct {
+	PerSecond float64 `yaml:"perSecond"`
+	Burst     int     `yaml:"burst"`
+}
+
+type FileSinkConfig struct {
+	SinkOptions `yaml:",inline"`
+	Dir         string `yaml:"dir"`
+}
+
+type MQTTSinkConfig struct {
+	SinkOptions `yaml:",inline"`
+	// Topic may contain {facility} and {patient} placeholders. The sink
+	// connects to mqtt.brokerURL with its own client ID.
+	Topic    string `yaml:"topic"`
+	QoS      int    `yaml:"qos"`
+	Retained bool   `yaml:"retained"`
+	ClientID string `yaml:"clientID"`
+}
+
+type WebhookSinkConfig struct {
+	SinkOptions `yaml:",inline"`
+	URL         string            `yaml:"url"`
+	Headers     map[string]string `yaml:"headers"`
+	Timeout     time.Duration     `yaml:"timeout"`
+}
+
+type KafkaSinkConfig struct {
+	SinkOptions `yaml:",inline"`
+	// Brokers defaults to kafka.brokers.
+	Brokers        string        `yaml:"brokers"`
+	Topic          string        `yaml:"topic"`
+	Compression    string        `yaml:"compression"`
+	MessageTimeout time.Duration `yaml:"messageTimeout"`
+}
+
+// MLLPSinkConfig sends HL7 v2 messages to an MLLP receiver, one at a time
+// over a persistent connection.
+type MLLPSinkConfig struct {
+	SinkOptions `yaml:",inline"`
+	// Addr is the receiver's host:port.
+	Addr        string        `yaml:"addr"`
+	DialTimeout time.Duration `yaml:"dialTimeout"`
+	// AckTimeout bounds the wait for each message's acknowledgement.
+	AckTimeout time.Duration `yaml:"ackTimeout"`
+}
+
+func defaultSinkOptions(enabled bool, workers int) SinkOptions {
+	return SinkOptions{
+		Enabled:   enabled,
+		Format:    FormatPresense,
+		QueueSize: 1000,
+		Workers:   workers,
+		Retry: RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: time.Second,
+			MaxBackoff:     30 * time.Second,
+		},
+	}
+}
+
+func defaultSinks() SinksConfig {
+	mllpOptions := defaultSinkOptions(false, 1)
+	mllpOptions.Format = FormatHL7
+	return SinksConfig{
+		Presense: PresenseSinkConfig{
+			SinkOptions: defaultSinkOptions(true, 16),
+			Timeout:     15 * time.Second,
+			MaxInFlight: 4,
+		},
+		File: FileSinkConfig{SinkOptions: defaultSinkOptions(false, 1), Dir: "../processed_data"},
+		MQTT: MQTTSinkConfig{
+			SinkOptions: defaultSinkOptions(false, 2),
+			Topic:       "belt/processed/{facility}/{patient}",
+			QoS:         1,
+			ClientID:    "belt_presense_sink",
+		},
+		Webhook: WebhookSinkConfig{SinkOptions: defaultSinkOptions(false, 2), Timeout: 10 * time.Second},
+		Kafka: KafkaSinkConfig{
+			SinkOptions:    defaultSinkOptions(false, 4),
+			Topic:          "belt-presense-processed",
+			Compression:    "snappy",
+			MessageTimeout: 30 * time.Second,
+		},
+		MLLP: MLLPSinkConfig{
+			SinkOptions: mllpOptions,
+			DialTimeout: 5 * time.Second,
+			AckTimeout:  30 * time.Second,
+		},
+	}
+}
+
+// usesFormat reports whether any enabled sink sends format.
+func (c *Config) usesFormat(format string) bool {
+	s := c.Sinks
+	file := s.File.SinkOptions
+	file.Enabled = file.Enabled || c.Destinations.WriteToFile
+	for _, o := range []SinkOptions{s.Presense.SinkOptions, file, s.MQTT.SinkOptions, s.Webhook.SinkOptions, s.Kafka.SinkOptions, s.MLLP.SinkOptions} {
+		if o.Enabled && o.Format == format {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateSinks() error {
+	var errs []error
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	checkOptions := func(name string, o SinkOptions) {
+		if !o.Enabled {
+			return
+		}
+		field := "sinks." + name
+		if o.QueueSize < 1 {
+			add(field+".queueSize", "must be at least 1, got %d", o.QueueSize)
+		}
+		if o.Workers < 1 {
+			add(field+".workers", "must be at least 1, got %d", o.Workers)
+		}
+		if o.Retry.MaxAttempts < 1 {
+			add(field+".retry.maxAttempts", "must be at least 1, got %d", o.Retry.MaxAttempts)
+		}
+		if o.Retry.InitialBackoff < 0 || o.Retry.MaxBackoff < o.Retry.InitialBackoff {
+			add(field+".retry", "backoffs must satisfy 0 <= initialBackoff <= maxBackoff")
+		}
+		if o.MaxQueueAge < 0 {
+			add(field+".maxQueueAge", "must not be negative")
+		}
+		if !oneOf(o.Format, FormatPresense, FormatFHIR, FormatHL7) {
+			add(field+".format", "must be presense, fhir or hl7v2; got %q", o.Format)
+		} else if name == "presense" && o.Format != FormatPresense {
+			add(field+".format", "must be presense for the Presense sink")
+		} else if name == "mllp" && o.Format != FormatHL7 {
+			add(field+".format", "must be hl7v2 for the MLLP sink")
+		}
+	}
+
+	s := c.Sinks
+	checkOptions("presense", s.Presense.SinkOptions)
+	checkOptions("file", s.File.SinkOptions)
+	checkOptions("mqtt", s.MQTT.SinkOptions)
+	checkOptions("webhook", s.Webhook.SinkOptions)
+	checkOptions("kafka", s.Kafka.SinkOptions)
+	checkOptions("mllp", s.MLLP.SinkOptions)
+
+	if s.Presense.Enabled {
+		if s.Presense.MaxInFlight < 1 {
+			add("sinks.presense.maxInFlight", "must be at least 1, got %d", s.Presense.MaxInFlight)
+		}
+		if r := s.Presense.RateLimit; r.PerSecond < 0 {
+			add("sinks.presense.rateLimit.perSecond", "must not be negative")
+		} else if r.PerSecond > 0 && r.Burst < 1 {
+			add("sinks.presense.rateLimit.burst", "must be at least 1 when perSecond is set, got %d", r.Burst)
+		}
+	}
+	if s.File.Enabled && s.File.Dir == "" {
+		add("sinks.file.dir", "must be set when the file sink is enabled")
+	}
+	if s.MQTT.Enabled {
+		if strings.TrimSpace(s.MQTT.Topic) == "" {
+			add("sinks.mqtt.topic", "must be set when the MQTT sink is enabled")
+		}
+		if s.MQTT.QoS < 0 || s.MQTT.QoS > 2 {
+			add("sinks.mqtt.qos", "must be 0, 1 or 2, got %d", s.MQTT.QoS)
+		}
+		if s.MQTT.ClientID == "" || s.MQTT.ClientID == c.MQTT.ClientID {
+			add("sinks.mqtt.clientID", "must be set and differ from mqtt.clientID")
+		}
+	}
+	if s.Webhook.Enabled {
+		if err := checkURL(s.Webhook.URL, "http", "https"); err != nil {
+			add("sinks.webhook.url", "%v", err)
+		}
+	}
+	if s.Kafka.Enabled {
+		if s.Kafka.Topic == "" {
+			add("sinks.kafka.topic", "must be set when the Kafka sink is enabled")
+		} else if c.Kafka.consumesTopic(s.Kafka.Topic) {
+			add("sinks.kafka.topic", "must differ from the consumed topics")
+		}
+		if !oneOf(strings.ToLower(s.Kafka.Compression), "none", "gzip", "snappy", "lz4", "zstd") {
+			add("sinks.kafka.compression", "must be none, gzip, snappy, lz4 or zstd; got %q", s.Kafka.Compression)
+		}
+		if s.Kafka.MessageTimeout < time.Second {
+			add("sinks.kafka.messageTimeout", "must be at least 1s, got %s", s.Kafka.MessageTimeout)
+		}
+	}
+	if s.MLLP.Enabled {
+		if _, _, err := net.SplitHostPort(s.MLLP.Addr); err != nil {
+			add("sinks.mllp.addr", "must be host:port: %v", err)
+		}
+		if s.MLLP.DialTimeout < 0 {
+			add("sinks.mllp.dialTimeout", "must not be negative")
+		}
+		if s.MLLP.AckTimeout <= 0 {
+			add("sinks.mllp.ackTimeout", "must be positive, got %s", s.MLLP.AckTimeout)
+		}
+	}
+	return errors.Join(errs...)
+}
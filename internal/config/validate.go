@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Validate checks the whole configuration and returns every problem found,
+// joined, rather than stopping at the first.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if strings.TrimSpace(c.Kafka.Brokers) == "" {
+		add("kafka.brokers", "must not be empty")
+	}
+	if len(c.Kafka.Subscriptions) == 0 && c.Kafka.VitalsTopic == "" {
+		add("kafka.vitalsTopic", "must not be empty when kafka.subscriptions is empty")
+	}
+	seenTopics := make(map[string]bool)
+	for i, sub := range c.Kafka.Subscriptions {
+		field := fmt.Sprintf("kafka.subscriptions[%d]", i)
+		if sub.Topic == "" {
+			add(field+".topic", "must not be empty")
+		} else if seenTopics[sub.Topic] {
+			add(field+".topic", "topic %q is subscribed more than once", sub.Topic)
+		}
+		seenTopics[sub.Topic] = true
+		if !oneOf(sub.Handler, TopicHandlers...) {
+			add(field+".handler", "must be one of %s; got %q", strings.Join(TopicHandlers, ", "), sub.Handler)
+		}
+	}
+	if c.Kafka.ConsumerGroup == "" {
+		add("kafka.consumerGroup", "must not be empty")
+	}
+
+	for deviceType, messageType := range c.Kafka.DeviceTypes {
+		if !oneOf(strings.ToLower(strings.TrimSpace(messageType)), "ecg", "bpspo2", "temperature") {
+			add("kafka.deviceTypes."+deviceType, "must be ecg, bpspo2 or temperature; got %q", messageType)
+		}
+	}
+
+	if err := checkURL(c.MQTT.BrokerURL, "tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss"); err != nil {
+		add("mqtt.brokerURL", "%v", err)
+	}
+	if c.MQTT.ClientID == "" {
+		add("mqtt.clientID", "must not be empty")
+	}
+	if c.MQTT.Username != "" && c.MQTT.Password == "" {
+		add("mqtt.password", "must be set when mqtt.username is set")
+	}
+
+	for _, ep := range []struct {
+		name string
+		EndpointConfig
+	}{{"presense", c.Destinations.Presense}, {"test", c.Destinations.Test}} {
+		if ep.Endpoint == "" {
+			continue
+		}
+		if err := checkURL(ep.Endpoint, "http", "https"); err != nil {
+			add("destinations."+ep.name+".endpoint", "%v", err)
+		}
+	}
+	active, activeName := c.Destinations.Active(), "presense"
+	if c.Destinations.UseTest {
+		activeName = "test"
+	}
+	if active.Endpoint == "" {
+		add("destinations."+activeName+".endpoint", "must be set for the active destination")
+	}
+	if active.Auth.AuthType() == AuthBearer && active.APIKey == "" {
+		add("destinations."+activeName+".apiKey", "must be set for the active destination")
+	}
+	if defaultRoute, err := c.DefaultRoute(); err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, validateAuth("destinations."+activeName+".auth", defaultRoute.Auth),
+			validateTransport("destinations."+activeName+".transport", defaultRoute.Transport))
+	}
+
+	errs = append(errs, c.validateRouting(), c.validateSinks())
+
+	if c.Batching.Size < 1 || c.Batching.Size > 1000 {
+		add("batching.size", "must be between 1 and 1000, got %d", c.Batching.Size)
+	}
+
+	if c.Dedupe.Enabled {
+		if c.Dedupe.Window < 1 {
+			add("dedupe.window", "must be at least 1, got %d", c.Dedupe.Window)
+		}
+		if c.Dedupe.SnapshotInterval <= 0 {
+			add("dedupe.snapshotInterval", "must be positive")
+		}
+	}
+
+	if c.Reorder.MaxWait < 0 {
+		add("reorder.maxWait", "must not be negative")
+	}
+	if c.Reorder.MaxWait > 0 && c.Reorder.MaxPackets < 1 {
+		add("reorder.maxPackets", "must be at least 1, got %d", c.Reorder.MaxPackets)
+	}
+	if !oneOf(c.Reorder.LatePolicy, "drop", "next", "standalone") {
+		add("reorder.latePolicy", "must be drop, next or standalone; got %q", c.Reorder.LatePolicy)
+	}
+
+	for i, id := range c.Facilities.Allowed {
+		if strings.TrimSpace(id) == "" {
+			add(fmt.Sprintf("facilities.allowed[%d]", i), "must not be empty")
+		}
+	}
+
+	if t := c.DeadLetters.KafkaTopic; t != "" && (c.Kafka.consumesTopic(t) || t == c.Sinks.Kafka.Topic) {
+		add("deadLetters.kafkaTopic", "must differ from the consumed topics and sinks.kafka.topic")
+	}
+	if c.DeadLetters.Retention < 0 {
+		add("deadLetters.retention", "must not be negative")
+	}
+	if c.Deliveries.Retention < 0 {
+		add("deliveries.retention", "must not be negative")
+	}
+
+	if c.usesFormat(FormatFHIR) {
+		if !oneOf(c.FHIR.BundleType, "transaction", "collection") {
+			add("fhir.bundleType", "must be transaction or collection; got %q", c.FHIR.BundleType)
+		}
+		if c.FHIR.PatientSystem == "" {
+			add("fhir.patientSystem", "must not be empty")
+		}
+		if c.FHIR.EncounterSystem == "" {
+			add("fhir.encounterSystem", "must not be empty")
+		}
+		if c.FHIR.DeviceSystem == "" {
+			add("fhir.deviceSystem", "must not be empty")
+		}
+		if c.FHIR.ECGSampleRate < 0 {
+			add("fhir.ecgSampleRate", "must not be negative")
+		}
+	}
+	if c.usesFormat(FormatHL7) {
+		if c.HL7.SendingApplication == "" {
+			add("hl7.sendingApplication", "must not be empty")
+		}
+		if !oneOf(c.HL7.ProcessingID, "P", "T", "D") {
+			add("hl7.processingID", "must be P, T or D; got %q", c.HL7.ProcessingID)
+		}
+	}
+
+	if c.Database.Path == "" {
+		add("database.path", "must not be empty")
+	}
+
+	if !oneOf(strings.ToLower(c.Logging.Level), "debug", "info", "warn", "error") {
+		add("logging.level", "must be one of debug, info, warn, error; got %q", c.Logging.Level)
+	}
+	if !oneOf(strings.ToLower(c.Logging.Format), "text", "json") {
+		add("logging.format", "must be text or json; got %q", c.Logging.Format)
+	}
+	if !oneOf(strings.ToLower(c.Logging.PHIPolicy), "mask", "hash", "off") {
+		add("logging.phiPolicy", "must be mask, hash or off; got %q", c.Logging.PHIPolicy)
+	} else if strings.ToLower(c.Logging.PHIPolicy) == "hash" && c.Logging.PHIHashKey == "" {
+		add("logging.phiHashKey", "must be set for the hash policy (LOG_PHI_HASH_KEY)")
+	}
+
+	if c.Admin.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Addr); err != nil {
+			add("admin.addr", "%v", err)
+		}
+	}
+
+	if !oneOf(strings.ToLower(c.Tracing.Exporter), "none", "stdout", "otlp") {
+		add("tracing.exporter", "must be none, stdout or otlp; got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sampleRatio", "must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkURL(raw string, schemes ...string) error {
+	if raw == "" {
+		return errors.New("must not be empty")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !oneOf(strings.ToLower(u.Scheme), schemes...) {
+		return fmt.Errorf("scheme must be one of %s; got %q", strings.Join(schemes, ", "), raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", raw)
+	}
+	return nil
+}
+
+func oneOf(value string, options ...string) bool {
+	for _, o := range options {
+		if value == o {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig is the defaults plus the one setting they leave unset.
+func validConfig() *Config {
+	c := Defaults()
+	c.Destinations.Presense.APIKey = "key"
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		// want lists the fields that must be reported; nil means valid.
+		want []string
+	}{
+		{"defaults with an API key", func(c *Config) {}, nil},
+		{"no API key", func(c *Config) { c.Destinations.Presense.APIKey = "" }, []string{"destinations.presense.apiKey"}},
+		{"test destination needs its own key", func(c *Config) { c.Destinations.UseTest = true }, []string{"destinations.test.apiKey"}},
+		{"empty brokers", func(c *Config) { c.Kafka.Brokers = "  " }, []string{"kafka.brokers"}},
+		{"no topic at all", func(c *Config) { c.Kafka.VitalsTopic = "" }, []string{"kafka.vitalsTopic"}},
+		{"subscriptions replace the vitals topic", func(c *Config) {
+			c.Kafka.VitalsTopic = ""
+			c.Kafka.Subscriptions = []SubscriptionConfig{{Topic: "ecg", Handler: "ecg"}}
+		}, nil},
+		{"bad subscriptions", func(c *Config) {
+			c.Kafka.Subscriptions = []SubscriptionConfig{{Topic: "a", Handler: "vitals"}, {Topic: "a", Handler: "x"}, {Handler: "ecg"}}
+		}, []string{"kafka.subscriptions[1].topic", "kafka.subscriptions[1].handler", "kafka.subscriptions[2].topic"}},
+		{"device type any case", func(c *Config) { c.Kafka.DeviceTypes = map[string]string{"NEXUS": "ECG"} }, nil},
+		{"unknown device message type", func(c *Config) { c.Kafka.DeviceTypes = map[string]string{"NEXUS": "weight"} }, []string{"kafka.deviceTypes.NEXUS"}},
+		{"mqtt scheme", func(c *Config) { c.MQTT.BrokerURL = "http://broker:1883" }, []string{"mqtt.brokerURL"}},
+		{"mqtt password", func(c *Config) { c.MQTT.Username = "svc" }, []string{"mqtt.password"}},
+		{"endpoint without host", func(c *Config) { c.Destinations.Presense.Endpoint = "https://" }, []string{"destinations.presense.endpoint"}},
+		{"oauth2 incomplete", func(c *Config) { c.Destinations.Presense.Auth.Type = AuthOAuth2 }, []string{
+			"destinations.presense.auth.tokenURL", "destinations.presense.auth.clientID", "destinations.presense.auth.clientSecret"}},
+		{"hmac without secret", func(c *Config) { c.Destinations.Presense.Auth.Type = AuthHMAC }, []string{"destinations.presense.auth.secret"}},
+		{"unknown auth", func(c *Config) { c.Destinations.Presense.Auth.Type = "basic" }, []string{"destinations.presense.auth.type"}},
+		{"half a client certificate", func(c *Config) { c.Destinations.Presense.Transport.CertFile = "cert.pem" }, []string{"destinations.presense.transport.certFile"}},
+		{"route without match", func(c *Config) {
+			c.Routing.Routes = []RouteConfig{{Name: "r", Destination: "presense"}}
+		}, []string{"routing.routes[0]: must list"}},
+		{"duplicate routes", func(c *Config) {
+			c.Routing.Routes = []RouteConfig{
+				{Name: "r", Facilities: []string{"F-1"}, Destination: "presense"},
+				{Name: "r", Facilities: []string{"F-2"}, Destination: "elsewhere"},
+			}
+		}, []string{"routing.routes[1].name", "routing.routes[1].destination", "routing.routes[1].endpoint", "routing.routes[1].apiKey"}},
+		{"batch size", func(c *Config) { c.Batching.Size = 1001 }, []string{"batching.size"}},
+		{"dedupe window", func(c *Config) { c.Dedupe.Window = 0 }, []string{"dedupe.window"}},
+		{"dedupe disabled ignores window", func(c *Config) { c.Dedupe.Enabled, c.Dedupe.Window = false, 0 }, nil},
+		{"late policy", func(c *Config) { c.Reorder.LatePolicy = "keep" }, []string{"reorder.latePolicy"}},
+		{"reorder packets", func(c *Config) { c.Reorder.MaxPackets = 0 }, []string{"reorder.maxPackets"}},
+		{"reorder off ignores packets", func(c *Config) { c.Reorder.MaxWait, c.Reorder.MaxPackets = 0, 0 }, nil},
+		{"blank facility", func(c *Config) { c.Facilities.Allowed = []string{"F-1", " "} }, []string{"facilities.allowed[1]"}},
+		{"dead letters to a consumed topic", func(c *Config) { c.DeadLetters.KafkaTopic = c.Kafka.VitalsTopic }, []string{"deadLetters.kafkaTopic"}},
+		{"sink queue", func(c *Config) { c.Sinks.Presense.QueueSize = 0 }, []string{"sinks.presense.queueSize"}},
+		{"sink backoffs", func(c *Config) { c.Sinks.Presense.Retry.MaxBackoff = 0 }, []string{"sinks.presense.retry"}},
+		{"presense sink format", func(c *Config) { c.Sinks.Presense.Format = FormatFHIR }, []string{"sinks.presense.format"}},
+		{"rate limit burst", func(c *Config) { c.Sinks.Presense.RateLimit.PerSecond = 5 }, []string{"sinks.presense.rateLimit.burst"}},
+		{"kafka sink", func(c *Config) {
+			c.Sinks.Kafka.Enabled = true
+			c.Sinks.Kafka.Topic = c.Kafka.VitalsTopic
+			c.Sinks.Kafka.MessageTimeout = 500 * time.Millisecond
+		}, []string{"sinks.kafka.topic", "sinks.kafka.messageTimeout"}},
+		{"mqtt sink shares the client ID", func(c *Config) {
+			c.Sinks.MQTT.Enabled = true
+			c.Sinks.MQTT.Topic = "out"
+			c.Sinks.MQTT.ClientID = c.MQTT.ClientID
+		}, []string{"sinks.mqtt.clientID"}},
+		{"mllp sink", func(c *Config) {
+			c.Sinks.MLLP.Enabled = true
+			c.Sinks.MLLP.Addr = "ehr"
+			c.Sinks.MLLP.Format = FormatPresense
+		}, []string{"sinks.mllp.addr", "sinks.mllp.format"}},
+		{"fhir settings checked when used", func(c *Config) {
+			c.Sinks.Webhook.Enabled = true
+			c.Sinks.Webhook.URL = "https://example.com/fhir"
+			c.Sinks.Webhook.Format = FormatFHIR
+			c.FHIR.BundleType = "batch"
+			c.FHIR.PatientSystem = ""
+		}, []string{"fhir.bundleType", "fhir.patientSystem"}},
+		{"fhir settings ignored when unused", func(c *Config) { c.FHIR.BundleType = "batch" }, nil},
+		{"hl7 processing ID", func(c *Config) {
+			c.Sinks.MLLP.Enabled = true
+			c.Sinks.MLLP.Addr = "ehr:2575"
+			c.HL7.ProcessingID = "X"
+		}, []string{"hl7.processingID"}},
+		{"log level", func(c *Config) { c.Logging.Level = "trace" }, []string{"logging.level"}},
+		{"hash policy without key", func(c *Config) { c.Logging.PHIPolicy = "hash" }, []string{"logging.phiHashKey"}},
+		{"hash policy with key", func(c *Config) { c.Logging.PHIPolicy, c.Logging.PHIHashKey = "HASH", "k" }, nil},
+		{"admin addr", func(c *Config) { c.Admin.Addr = "8090" }, []string{"admin.addr"}},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, []string{"tracing.sampleRatio"}},
+		{"every problem reported", func(c *Config) {
+			c.Kafka.Brokers = ""
+			c.Batching.Size = 0
+			c.Logging.Format = "xml"
+		}, []string{"kafka.brokers", "batching.size", "logging.format"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want errors for %v", tt.want)
+			}
+			for _, field := range tt.want {
+				if !strings.Contains(err.Error(), field) {
+					t.Errorf("Validate() does not report %s:\n%v", field, err)
+				}
+			}
+			if got, want := strings.Count(err.Error(), "\n")+1, len(tt.want); got != want {
+				t.Errorf("Validate() reported %d problems, want %d:\n%v", got, want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+)
+
+// Dead letter timestamps are unix milliseconds so they sort and purge
+// without parsing.
+const createDeadLettersTable = `
+    CREATE TABLE IF NOT EXISTS dead_letters (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        origin TEXT NOT NULL,
+        reason TEXT NOT NULL,
+        error TEXT NOT NULL,
+        topic TEXT,
+        partition INTEGER,
+        "offset" INTEGER,
+        patient_id TEXT,
+        facility_id TEXT,
+        device_type TEXT,
+        headers TEXT,
+        payload BLOB NOT NULL,
+        created_at INTEGER NOT NULL,
+        replayed_at INTEGER
+    );
+    CREATE INDEX IF NOT EXISTS dead_letters_created_at ON dead_letters (created_at);`
+
+// migrateDeadLetterHeaders adds the headers column to dead_letters tables
+// created before source headers were kept.
+func (r *Repository) migrateDeadLetterHeaders() error {
+	existing, err := r.tableColumns("dead_letters")
+	if err != nil || existing["headers"] {
+		return err
+	}
+	_, err = r.db.Exec(`ALTER TABLE dead_letters ADD COLUMN headers TEXT`)
+	return err
+}
+
+type DeadLetterFilter struct {
+	Reason          string
+	IncludeReplayed bool
+	Limit           int
+}
+
+func (r *Repository) AddDeadLetter(dl *models.DeadLetter) (err error) {
+	defer metrics.ObserveDBQuery("add_dead_letter", time.Now(), &err)
+	var headers sql.NullString
+	if len(dl.Headers) > 0 {
+		b, err := json.Marshal(dl.Headers)
+		if err != nil {
+			return err
+		}
+		headers = sql.NullString{String: string(b), Valid: true}
+	}
+	res, err := r.db.Exec(`INSERT INTO dead_letters (origin, reason, error, topic, partition, "offset", patient_id, facility_id, device_type, headers, payload, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		dl.Origin, dl.Reason, dl.Error, dl.Topic, dl.Partition, dl.Offset, dl.PatientID, dl.FacilityID, dl.DeviceType, headers, dl.Payload, dl.CreatedAt)
+	if err != nil {
+		return err
+	}
+	dl.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetDeadLetters returns dead letters newest first.
+func (r *Repository) GetDeadLetters(filter DeadLetterFilter) (letters []models.DeadLetter, err error) {
+	defer metrics.ObserveDBQuery("get_dead_letters", time.Now(), &err)
+	query := `SELECT id, origin, reason, error, topic, partition, "offset", patient_id, facility_id, device_type, headers, payload, created_at, replayed_at FROM dead_letters WHERE 1=1`
+	var args []interface{}
+	if filter.Reason != "" {
+		query += ` AND reason = ?`
+		args = append(args, filter.Reason)
+	}
+	if !filter.IncludeReplayed {
+		query += ` AND replayed_at IS NULL`
+	}
+	query += ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	return r.queryDeadLetters(query, args...)
+}
+
+func (r *Repository) GetDeadLetter(id int64) (letter *models.DeadLetter, err error) {
+	defer metrics.ObserveDBQuery("get_dead_letter", time.Now(), &err)
+	letters, err := r.queryDeadLetters(`SELECT id, origin, reason, error, topic, partition, "offset", patient_id, facility_id, device_type, headers, payload, created_at, replayed_at FROM dead_letters WHERE id = ?`, id)
+	if err != nil || len(letters) == 0 {
+		return nil, err
+	}
+	return &letters[0], nil
+}
+
+func (r *Repository) MarkDeadLetterReplayed(id int64) (err error) {
+	defer metrics.ObserveDBQuery("mark_dead_letter_replayed", time.Now(), &err)
+	_, err = r.db.Exec(`UPDATE dead_letters SET replayed_at = ? WHERE id = ?`, time.Now().UnixMilli(), id)
+	return err
+}
+
+// PurgeDeadLetters deletes dead letters recorded before cutoff and returns
+// how many were removed.
+func (r *Repository) PurgeDeadLetters(cutoff time.Time) (purged int64, err error) {
+	defer metrics.ObserveDBQuery("purge_dead_letters", time.Now(), &err)
+	res, err := r.db.Exec(`DELETE FROM dead_letters WHERE created_at < ?`, cutoff.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repository) queryDeadLetters(query string, args ...interface{}) ([]models.DeadLetter, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []models.DeadLetter
+	for rows.Next() {
+		var dl models.DeadLetter
+		var topic, patientID, facilityID, deviceType, headers sql.NullString
+		var replayedAt sql.NullInt64
+		if err := rows.Scan(&dl.ID, &dl.Origin, &dl.Reason, &dl.Error, &topic, &dl.Partition, &dl.Offset,
+			&patientID, &facilityID, &deviceType, &headers, &dl.Payload, &dl.CreatedAt, &replayedAt); err != nil {
+			return nil, err
+		}
+		dl.Topic, dl.PatientID, dl.FacilityID, dl.DeviceType = topic.String, patientID.String, facilityID.String, deviceType.String
+		if headers.Valid {
+			if err := json.Unmarshal([]byte(headers.String), &dl.Headers); err != nil {
+				return nil, fmt.Errorf("dead letter %d headers: %w", dl.ID, err)
+			}
+		}
+		if replayedAt.Valid {
+			dl.ReplayedAt = &replayedAt.Int64
+		}
+		letters = append(letters, dl)
+	}
+	return letters, rows.Err()
+}
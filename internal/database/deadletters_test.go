@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"belt-presense/internal/models"
+)
+
+func TestDeadLetterHeaders(t *testing.T) {
+	repo := openTestRepo(t, filepath.Join(t.TempDir(), "test.db"))
+
+	headers := []models.Header{
+		{Key: "message-type", Value: []byte("ecg")},
+		{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+		{Key: "binary", Value: []byte{0xff, 0x00}},
+	}
+	with := &models.DeadLetter{Origin: "kafka", Reason: "decode_error", Error: "bad", Topic: "ecg", Headers: headers, Payload: []byte("{"), CreatedAt: 1}
+	without := &models.DeadLetter{Origin: "sink:presense", Reason: "rejected", Error: "400", Payload: []byte("{}"), CreatedAt: 2}
+	for _, dl := range []*models.DeadLetter{with, without} {
+		if err := repo.AddDeadLetter(dl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := repo.GetDeadLetter(with.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetDeadLetter() = %v, %v", got, err)
+	}
+	if !reflect.DeepEqual(got.Headers, headers) {
+		t.Errorf("headers = %+v, want %+v", got.Headers, headers)
+	}
+	got, err = repo.GetDeadLetter(without.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetDeadLetter() = %v, %v", got, err)
+	}
+	if got.Headers != nil {
+		t.Errorf("headers = %+v, want none", got.Headers)
+	}
+}
+
+func TestMigrateDeadLetterHeaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE dead_letters (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            origin TEXT NOT NULL,
+            reason TEXT NOT NULL,
+            error TEXT NOT NULL,
+            topic TEXT,
+            partition INTEGER,
+            "offset" INTEGER,
+            patient_id TEXT,
+            facility_id TEXT,
+            device_type TEXT,
+            payload BLOB NOT NULL,
+            created_at INTEGER NOT NULL,
+            replayed_at INTEGER
+        )`,
+		`INSERT INTO dead_letters (origin, reason, error, topic, partition, "offset", payload, created_at) VALUES ('kafka', 'decode_error', 'bad', 'ecg', 0, 7, '{', 1)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	repo := openTestRepo(t, path)
+	letters, err := repo.GetDeadLetters(DeadLetterFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Offset != 7 || letters[0].Headers != nil {
+		t.Fatalf("dead letters after migration = %+v", letters)
+	}
+	dl := &models.DeadLetter{Origin: "kafka", Reason: "unknown_type", Error: "x", Headers: []models.Header{{Key: "k", Value: []byte("v")}}, Payload: []byte("{}"), CreatedAt: 2}
+	if err := repo.AddDeadLetter(dl); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+)
+
+// Delivery timestamps are unix milliseconds, like dead letters. The decoded
+// Presense response is kept as JSON, with the fields worth querying copied
+// into their own columns.
+const createDeliveriesTable = `
+    CREATE TABLE IF NOT EXISTS deliveries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        patient_id TEXT NOT NULL,
+        facility_id TEXT,
+        route TEXT,
+        trace_id TEXT,
+        result TEXT NOT NULL,
+        status_code INTEGER,
+        attempts INTEGER NOT NULL,
+        error TEXT,
+        server_id TEXT,
+        accepted INTEGER,
+        rejected INTEGER,
+        response TEXT,
+        created_at INTEGER NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS deliveries_patient ON deliveries (patient_id, id);
+    CREATE INDEX IF NOT EXISTS deliveries_created_at ON deliveries (created_at);`
+
+type DeliveryFilter struct {
+	PatientID string
+	Result    string
+	Limit     int
+}
+
+func (r *Repository) AddDelivery(d *models.Delivery) (err error) {
+	defer metrics.ObserveDBQuery("add_delivery", time.Now(), &err)
+	var serverID sql.NullString
+	var accepted, rejected sql.NullInt64
+	var response []byte
+	if d.Response != nil {
+		serverID = sql.NullString{String: d.Response.ID, Valid: d.Response.ID != ""}
+		accepted = sql.NullInt64{Int64: int64(d.Response.Accepted), Valid: true}
+		rejected = sql.NullInt64{Int64: int64(d.Response.Rejected), Valid: true}
+		if response, err = json.Marshal(d.Response); err != nil {
+			return err
+		}
+	}
+	res, err := r.db.Exec(`INSERT INTO deliveries (patient_id, facility_id, route, trace_id, result, status_code, attempts, error, server_id, accepted, rejected, response, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.PatientID, d.FacilityID, d.Route, d.TraceID, d.Result, d.StatusCode, d.Attempts, d.Error,
+		serverID, accepted, rejected, nullableText(response), d.CreatedAt)
+	if err != nil {
+		return err
+	}
+	d.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetDeliveries returns delivery records newest first.
+func (r *Repository) GetDeliveries(filter DeliveryFilter) (deliveries []models.Delivery, err error) {
+	defer metrics.ObserveDBQuery("get_deliveries", time.Now(), &err)
+	query := `SELECT id, patient_id, facility_id, route, trace_id, result, status_code, attempts, error, response, created_at FROM deliveries WHERE 1=1`
+	var args []interface{}
+	if filter.PatientID != "" {
+		query += ` AND patient_id = ?`
+		args = append(args, filter.PatientID)
+	}
+	if filter.Result != "" {
+		query += ` AND result = ?`
+		args = append(args, filter.Result)
+	}
+	query += ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d models.Delivery
+		var facilityID, route, traceID, errText, response sql.NullString
+		var statusCode sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.PatientID, &facilityID, &route, &traceID, &d.Result, &statusCode, &d.Attempts,
+			&errText, &response, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.FacilityID, d.Route, d.TraceID, d.Error = facilityID.String, route.String, traceID.String, errText.String
+		d.StatusCode = int(statusCode.Int64)
+		if response.Valid {
+			d.Response = &models.PresenseResponse{}
+			if err := json.Unmarshal([]byte(response.String), d.Response); err != nil {
+				return nil, err
+			}
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// PurgeDeliveries deletes delivery records made before cutoff and returns
+// how many were removed.
+func (r *Repository) PurgeDeliveries(cutoff time.Time) (purged int64, err error) {
+	defer metrics.ObserveDBQuery("purge_deliveries", time.Now(), &err)
+	res, err := r.db.Exec(`DELETE FROM deliveries WHERE created_at < ?`, cutoff.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func nullableText(b []byte) sql.NullString {
+	return sql.NullString{String: string(b), Valid: b != nil}
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+)
+
+const createPacketWindowsTable = `
+    CREATE TABLE IF NOT EXISTS packet_windows (
+        patient_id TEXT PRIMARY KEY,
+        patch_id TEXT NOT NULL,
+        packets TEXT NOT NULL,
+        updated_at INTEGER NOT NULL
+    );`
+
+// SavePacketWindows replaces the stored window of each patient given.
+func (r *Repository) SavePacketWindows(windows map[string]models.PacketWindow) (err error) {
+	defer metrics.ObserveDBQuery("save_packet_windows", time.Now(), &err)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO packet_windows (patient_id, patch_id, packets, updated_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UnixMilli()
+	for patientID, w := range windows {
+		packets, err := json.Marshal(w.Packets)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := stmt.Exec(patientID, w.PatchID, string(packets), now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *Repository) GetPacketWindows() (windows map[string]models.PacketWindow, err error) {
+	defer metrics.ObserveDBQuery("get_packet_windows", time.Now(), &err)
+	rows, err := r.db.Query(`SELECT patient_id, patch_id, packets FROM packet_windows`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	windows = make(map[string]models.PacketWindow)
+	for rows.Next() {
+		var patientID, packets string
+		var w models.PacketWindow
+		if err := rows.Scan(&patientID, &w.PatchID, &packets); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(packets), &w.Packets); err != nil {
+			return nil, err
+		}
+		windows[patientID] = w
+	}
+	return windows, rows.Err()
+}
+
+func (r *Repository) DeletePacketWindows(patientIDs []string) (err error) {
+	defer metrics.ObserveDBQuery("delete_packet_windows", time.Now(), &err)
+	for _, id := range patientIDs {
+		if _, err := r.db.Exec(`DELETE FROM packet_windows WHERE patient_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
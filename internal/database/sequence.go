@@ -0,0 +1,79 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+)
+
+// sequenceColumns are added to monitoring_sessions on databases created
+// before sequence tracking. Starting a session resets them to zero.
+var sequenceColumns = []string{"gap_count", "missing_packets", "gap_ms", "duplicate_packets", "sequence_resets"}
+
+func (r *Repository) migrateSequenceColumns() error {
+	existing, err := r.tableColumns("monitoring_sessions")
+	if err != nil {
+		return err
+	}
+	for _, col := range sequenceColumns {
+		if existing[col] {
+			continue
+		}
+		if _, err := r.db.Exec(fmt.Sprintf(`ALTER TABLE monitoring_sessions ADD COLUMN %s INTEGER NOT NULL DEFAULT 0`, col)); err != nil {
+			return fmt.Errorf("adding column %s: %w", col, err)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) tableColumns(table string) (map[string]bool, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             interface{}
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	return existing, rows.Err()
+}
+
+// AddSequenceStats adds counts to each session's totals.
+func (r *Repository) AddSequenceStats(updates map[int64]models.SequenceStats) (err error) {
+	defer metrics.ObserveDBQuery("add_sequence_stats", time.Now(), &err)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE monitoring_sessions SET
+        gap_count = gap_count + ?,
+        missing_packets = missing_packets + ?,
+        gap_ms = gap_ms + ?,
+        duplicate_packets = duplicate_packets + ?,
+        sequence_resets = sequence_resets + ?
+        WHERE session_id = ?`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for sessionID, s := range updates {
+		if _, err := stmt.Exec(s.Gaps, s.MissingPackets, s.GapMs, s.Duplicates, s.Resets, sessionID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
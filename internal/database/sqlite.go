@@ -1,10 +1,15 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"log/slog"
+	"sort"
 	"time"
 
+	"belt-presense/internal/metrics"
 	"belt-presense/internal/models"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -40,51 +45,131 @@ func NewRepository(dbPath string) (*Repository, error) {
 	return repo, nil
 }
 
-func (r *Repository) initSchema() error {
-	createSessionsTable := `
-    CREATE TABLE IF NOT EXISTS monitoring_sessions (
-        patient_id TEXT PRIMARY KEY,
+// createSessionsTable holds one row per monitoring session; a patient
+// started again gets a new row, so earlier sessions stay as history.
+const createSessionsTable = `
+    CREATE TABLE IF NOT EXISTS %s (
+        session_id INTEGER PRIMARY KEY AUTOINCREMENT,
+        patient_id TEXT NOT NULL,
         device_id TEXT,
         status TEXT NOT NULL,
         facility_id TEXT NOT NULL,
         start_time TEXT NOT NULL,
         end_time TEXT,
-        last_streamed_time TEXT
+        last_streamed_time TEXT,
+        gap_count INTEGER NOT NULL DEFAULT 0,
+        missing_packets INTEGER NOT NULL DEFAULT 0,
+        gap_ms INTEGER NOT NULL DEFAULT 0,
+        duplicate_packets INTEGER NOT NULL DEFAULT 0,
+        sequence_resets INTEGER NOT NULL DEFAULT 0
     );`
-	_, err := r.db.Exec(createSessionsTable)
+
+const sessionColumns = `session_id, patient_id, device_id, status, facility_id, start_time, end_time, last_streamed_time, gap_count, missing_packets, gap_ms, duplicate_packets, sequence_resets`
+
+func (r *Repository) initSchema() error {
+	if _, err := r.db.Exec(fmt.Sprintf(createSessionsTable, "monitoring_sessions")); err != nil {
+		return err
+	}
+	if err := r.migrateSequenceColumns(); err != nil {
+		return err
+	}
+	if err := r.migrateSessionIDs(); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(`CREATE INDEX IF NOT EXISTS monitoring_sessions_patient ON monitoring_sessions (patient_id, end_time)`); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(createPacketWindowsTable); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(createDeadLettersTable); err != nil {
+		return err
+	}
+	if err := r.migrateDeadLetterHeaders(); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(createDeliveriesTable)
 	return err
 }
 
-func (r *Repository) StartMonitoring(patientID, facilityID, deviceID string) error {
+// migrateSessionIDs rebuilds a monitoring_sessions table keyed by patient ID,
+// from before sessions had their own ID, keeping its rows.
+func (r *Repository) migrateSessionIDs() error {
+	existing, err := r.tableColumns("monitoring_sessions")
+	if err != nil {
+		return err
+	}
+	if existing["session_id"] {
+		return nil
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	copied := `patient_id, device_id, status, facility_id, start_time, end_time, last_streamed_time, gap_count, missing_packets, gap_ms, duplicate_packets, sequence_resets`
+	for _, stmt := range []string{
+		fmt.Sprintf(createSessionsTable, "monitoring_sessions_new"),
+		`INSERT INTO monitoring_sessions_new (` + copied + `) SELECT ` + copied + ` FROM monitoring_sessions ORDER BY rowid`,
+		`DROP TABLE monitoring_sessions`,
+		`ALTER TABLE monitoring_sessions_new RENAME TO monitoring_sessions`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("adding session IDs: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// StartMonitoring opens a new session for the patient and returns its ID.
+// A session still open for the patient is closed first.
+func (r *Repository) StartMonitoring(patientID, facilityID, deviceID string) (sessionID int64, err error) {
+	defer metrics.ObserveDBQuery("start_monitoring", time.Now(), &err)
 	nowStr := time.Now().In(istLocation).Format(timeFormat)
-	query := `INSERT OR REPLACE INTO monitoring_sessions (patient_id, device_id, status, facility_id, start_time, end_time, last_streamed_time) VALUES (?, ?, ?, ?, ?, NULL, NULL)`
-	_, err := r.db.Exec(query, patientID, deviceID, "running", facilityID, nowStr)
-	return err
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`UPDATE monitoring_sessions SET status = ?, end_time = ? WHERE patient_id = ? AND end_time IS NULL`, "stopped", nowStr, patientID); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`INSERT INTO monitoring_sessions (patient_id, device_id, status, facility_id, start_time) VALUES (?, ?, ?, ?, ?)`, patientID, deviceID, "running", facilityID, nowStr)
+	if err != nil {
+		return 0, err
+	}
+	if sessionID, err = res.LastInsertId(); err != nil {
+		return 0, err
+	}
+	return sessionID, tx.Commit()
 }
 
-func (r *Repository) StopMonitoring(patientID string) error {
+func (r *Repository) StopMonitoring(sessionID int64) (err error) {
+	defer metrics.ObserveDBQuery("stop_monitoring", time.Now(), &err)
 	nowStr := time.Now().In(istLocation).Format(timeFormat)
-	query := `UPDATE monitoring_sessions SET status = ?, end_time = ? WHERE patient_id = ?`
-	_, err := r.db.Exec(query, "stopped", nowStr, patientID)
+	query := `UPDATE monitoring_sessions SET status = ?, end_time = ? WHERE session_id = ?`
+	_, err = r.db.Exec(query, "stopped", nowStr, sessionID)
 	return err
 }
 
-func (r *Repository) BatchUpdateLastStreamedTime(updates map[string]int64) error {
+// BatchUpdateLastStreamedTime sets the last streamed time of each session.
+func (r *Repository) BatchUpdateLastStreamedTime(updates map[int64]int64) (err error) {
+	defer metrics.ObserveDBQuery("update_last_streamed", time.Now(), &err)
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
-	stmt, err := tx.Prepare("UPDATE monitoring_sessions SET last_streamed_time = ? WHERE patient_id = ?")
+	stmt, err := tx.Prepare("UPDATE monitoring_sessions SET last_streamed_time = ? WHERE session_id = ?")
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
 
-	for patientID, timestamp := range updates {
+	for sessionID, timestamp := range updates {
 		timeStr := time.Unix(timestamp, 0).In(istLocation).Format(timeFormat)
-		if _, err := stmt.Exec(timeStr, patientID); err != nil {
-			log.Printf("Failed to update time for patient %s, rolling back transaction. Error: %v", patientID, err)
+		if _, err := stmt.Exec(timeStr, sessionID); err != nil {
+			slog.Error("Failed to update last streamed time, rolling back transaction", "sessionId", sessionID, "error", err)
 			tx.Rollback()
 			return err
 		}
@@ -92,21 +177,49 @@ func (r *Repository) BatchUpdateLastStreamedTime(updates map[string]int64) error
 	return tx.Commit()
 }
 
-func (r *Repository) GetActivePatients() ([]models.PatientStream, error) {
-	query := `SELECT patient_id, device_id, status, facility_id, start_time, end_time, last_streamed_time FROM monitoring_sessions WHERE status = 'running'`
-	rows, err := r.db.Query(query)
+func (r *Repository) GetActivePatients() (sessions []models.PatientStream, err error) {
+	defer metrics.ObserveDBQuery("get_active_patients", time.Now(), &err)
+	return r.querySessions(`SELECT ` + sessionColumns + ` FROM monitoring_sessions WHERE status = 'running'`)
+}
+
+// GetSessions returns every recorded session, optionally filtered by status,
+// most recently started first.
+func (r *Repository) GetSessions(status string) (sessions []models.PatientStream, err error) {
+	defer metrics.ObserveDBQuery("get_sessions", time.Now(), &err)
+	query := `SELECT ` + sessionColumns + ` FROM monitoring_sessions`
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	sessions, err = r.querySessions(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].StartTime != sessions[j].StartTime {
+			return sessions[i].StartTime > sessions[j].StartTime
+		}
+		return sessions[i].SessionID > sessions[j].SessionID
+	})
+	return sessions, nil
+}
+
+func (r *Repository) querySessions(query string, args ...interface{}) ([]models.PatientStream, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var activePatients []models.PatientStream
+	var sessions []models.PatientStream
 	for rows.Next() {
 		var patient models.PatientStream
 		var startTimeStr string
 		var endTimeStr, lastStreamedTimeStr sql.NullString
 
 		if err := rows.Scan(
+			&patient.SessionID,
 			&patient.PatientID,
 			&patient.DeviceID,
 			&patient.Status,
@@ -114,13 +227,18 @@ func (r *Repository) GetActivePatients() ([]models.PatientStream, error) {
 			&startTimeStr,
 			&endTimeStr,
 			&lastStreamedTimeStr,
+			&patient.Sequence.Gaps,
+			&patient.Sequence.MissingPackets,
+			&patient.Sequence.GapMs,
+			&patient.Sequence.Duplicates,
+			&patient.Sequence.Resets,
 		); err != nil {
 			return nil, err
 		}
 
 		startTime, err := time.ParseInLocation(timeFormat, startTimeStr, istLocation)
 		if err != nil {
-			log.Printf("Warning: could not parse start_time '%s' from DB: %v", startTimeStr, err)
+			slog.Warn("Could not parse start_time from DB", "startTime", startTimeStr, "error", err)
 			continue
 		}
 		patient.StartTime = startTime.Unix()
@@ -139,9 +257,13 @@ func (r *Repository) GetActivePatients() ([]models.PatientStream, error) {
 				patient.LastStreamedTime = &lastStreamedTimeUnix
 			}
 		}
-		activePatients = append(activePatients, patient)
+		sessions = append(sessions, patient)
 	}
-	return activePatients, nil
+	return sessions, rows.Err()
+}
+
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
 }
 
 func (r *Repository) Close() {
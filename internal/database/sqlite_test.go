@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"belt-presense/internal/models"
+)
+
+func openTestRepo(t *testing.T, path string) *Repository {
+	t.Helper()
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+func TestSessionsKeepHistory(t *testing.T) {
+	repo := openTestRepo(t, filepath.Join(t.TempDir(), "test.db"))
+
+	first, err := repo.StartMonitoring("P-1", "F-1", "patch-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddSequenceStats(map[int64]models.SequenceStats{first: {Gaps: 2, MissingPackets: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.BatchUpdateLastStreamedTime(map[int64]int64{first: 1700000000}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Starting again, as after a restart, closes the open session.
+	second, err := repo.StartMonitoring("P-1", "F-1", "patch-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatalf("second session reused ID %d", first)
+	}
+	if err := repo.AddSequenceStats(map[int64]models.SequenceStats{second: {Duplicates: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := repo.GetSessions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2: %+v", len(sessions), sessions)
+	}
+	latest, earlier := sessions[0], sessions[1]
+	if latest.SessionID != second || latest.Status != "running" || latest.EndTime != nil || latest.DeviceID != "patch-2" {
+		t.Errorf("latest session = %+v", latest)
+	}
+	if latest.Sequence != (models.SequenceStats{Duplicates: 1}) {
+		t.Errorf("latest session counts = %+v", latest.Sequence)
+	}
+	if earlier.SessionID != first || earlier.Status != "stopped" || earlier.EndTime == nil || earlier.LastStreamedTime == nil {
+		t.Errorf("earlier session = %+v", earlier)
+	}
+	if earlier.Sequence != (models.SequenceStats{Gaps: 2, MissingPackets: 5}) {
+		t.Errorf("earlier session counts = %+v", earlier.Sequence)
+	}
+
+	active, err := repo.GetActivePatients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].SessionID != second {
+		t.Errorf("active sessions = %+v", active)
+	}
+
+	if err := repo.StopMonitoring(second); err != nil {
+		t.Fatal(err)
+	}
+	stopped, err := repo.GetSessions("stopped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stopped) != 2 {
+		t.Errorf("got %d stopped sessions, want 2", len(stopped))
+	}
+}
+
+func TestMigrateSessionIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE monitoring_sessions (
+            patient_id TEXT PRIMARY KEY,
+            device_id TEXT,
+            status TEXT NOT NULL,
+            facility_id TEXT NOT NULL,
+            start_time TEXT NOT NULL,
+            end_time TEXT,
+            last_streamed_time TEXT
+        )`,
+		`INSERT INTO monitoring_sessions VALUES ('P-1', 'patch-1', 'running', 'F-1', '01/02/2024 10:00:00.000', NULL, NULL)`,
+		`INSERT INTO monitoring_sessions VALUES ('P-2', 'patch-2', 'stopped', 'F-1', '01/02/2024 09:00:00.000', '01/02/2024 11:00:00.000', NULL)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	repo := openTestRepo(t, path)
+	active, err := repo.GetActivePatients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].PatientID != "P-1" || active[0].SessionID == 0 {
+		t.Fatalf("active sessions after migration = %+v", active)
+	}
+	if err := repo.AddSequenceStats(map[int64]models.SequenceStats{active[0].SessionID: {Resets: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.StartMonitoring("P-2", "F-1", "patch-2"); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := repo.GetSessions("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("got %d sessions, want 3: %+v", len(sessions), sessions)
+	}
+
+	// Opening the migrated database again leaves it alone.
+	repo.Close()
+	repo = openTestRepo(t, path)
+	active, err = repo.GetActivePatients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 2 {
+		t.Errorf("got %d active sessions after reopening, want 2", len(active))
+	}
+	for _, s := range active {
+		if s.PatientID == "P-1" && s.Sequence.Resets != 1 {
+			t.Errorf("P-1 counts = %+v", s.Sequence)
+		}
+	}
+}
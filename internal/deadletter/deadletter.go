@@ -0,0 +1,188 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"belt-presense/internal/database"
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Reasons a message is dead-lettered.
+const (
+	ReasonDecodeError = "decode_error"
+	ReasonUnknownType = "unknown_type"
+	ReasonRejected    = "rejected"
+	ReasonInvalid     = "invalid"
+	ReasonEvicted     = "evicted"
+)
+
+// Source identifies where a consumed message came from.
+type Source struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Headers   []models.Header
+}
+
+type sourceKey struct{}
+
+// WithSource attaches the consumed message's position to ctx so handlers
+// can dead-letter it without knowing about Kafka.
+func WithSource(ctx context.Context, src Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, src)
+}
+
+func SourceFrom(ctx context.Context) (Source, bool) {
+	src, ok := ctx.Value(sourceKey{}).(Source)
+	return src, ok
+}
+
+type Options struct {
+	// Repo stores dead letters in SQLite when non-nil.
+	Repo *database.Repository
+	// Brokers and Topic publish dead letters to Kafka when Topic is set.
+	Brokers string
+	Topic   string
+	// Retention is how long SQLite keeps dead letters; zero keeps them
+	// forever.
+	Retention time.Duration
+}
+
+// Recorder writes dead letters to every configured store. A nil Recorder
+// only counts them.
+type Recorder struct {
+	repo      *database.Repository
+	producer  *kafka.Producer
+	topic     string
+	retention time.Duration
+}
+
+func NewRecorder(opts Options) (*Recorder, error) {
+	r := &Recorder{repo: opts.Repo, topic: opts.Topic, retention: opts.Retention}
+	if opts.Topic != "" {
+		producer, err := kafka.NewProducer(&kafka.ConfigMap{
+			"bootstrap.servers": opts.Brokers,
+			"acks":              "all",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating dead-letter producer: %w", err)
+		}
+		r.producer = producer
+		go r.logDeliveryFailures()
+	}
+	return r, nil
+}
+
+// Record stores dl, filling in the source position from ctx when dl has
+// none. Failures are logged rather than returned: dead-lettering must never
+// stop the pipeline.
+func (r *Recorder) Record(ctx context.Context, dl *models.DeadLetter) {
+	if dl.Topic == "" {
+		if src, ok := SourceFrom(ctx); ok {
+			dl.Topic, dl.Partition, dl.Offset, dl.Headers = src.Topic, src.Partition, src.Offset, src.Headers
+		}
+	}
+	if dl.CreatedAt == 0 {
+		dl.CreatedAt = time.Now().UnixMilli()
+	}
+	metrics.DeadLetters.WithLabelValues(dl.Origin, dl.Reason).Inc()
+	if r == nil {
+		return
+	}
+
+	logger := logging.FromContext(ctx)
+	if r.repo != nil {
+		if err := r.repo.AddDeadLetter(dl); err != nil {
+			logger.Error("Failed to store dead letter", "reason", dl.Reason, "error", err)
+		}
+	}
+	if r.producer != nil {
+		if err := r.publish(dl); err != nil {
+			logger.Error("Failed to publish dead letter", logging.KeyTopic, r.topic, "reason", dl.Reason, "error", err)
+		}
+	}
+	logger.Warn("Message dead-lettered", "origin", dl.Origin, "reason", dl.Reason, "id", dl.ID)
+}
+
+func (r *Recorder) publish(dl *models.DeadLetter) error {
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &r.topic, Partition: kafka.PartitionAny},
+		Value:          dl.Payload,
+		Headers: []kafka.Header{
+			{Key: "dl-origin", Value: []byte(dl.Origin)},
+			{Key: "dl-reason", Value: []byte(dl.Reason)},
+			{Key: "dl-error", Value: []byte(dl.Error)},
+			{Key: "dl-source-topic", Value: []byte(dl.Topic)},
+			{Key: "dl-source-partition", Value: []byte(strconv.Itoa(int(dl.Partition)))},
+			{Key: "dl-source-offset", Value: []byte(strconv.FormatInt(dl.Offset, 10))},
+			{Key: "dl-timestamp", Value: []byte(strconv.FormatInt(dl.CreatedAt, 10))},
+		},
+	}
+	for _, h := range dl.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+	if dl.PatientID != "" {
+		msg.Key = []byte(dl.PatientID)
+	}
+	return r.producer.Produce(msg, nil)
+}
+
+func (r *Recorder) logDeliveryFailures() {
+	for ev := range r.producer.Events() {
+		switch e := ev.(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				slog.Error("Dead letter not delivered", logging.KeyTopic, r.topic, "error", e.TopicPartition.Error)
+			}
+		case kafka.Error:
+			metrics.KafkaErrors.Inc()
+			slog.Error("Dead-letter producer error", logging.KeyTopic, r.topic, "error", e)
+		}
+	}
+}
+
+// Run purges expired dead letters hourly until ctx is cancelled, then
+// flushes the Kafka producer.
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.close()
+			return
+		case <-ticker.C:
+			if r.repo == nil || r.retention <= 0 {
+				continue
+			}
+			purged, err := r.repo.PurgeDeadLetters(time.Now().Add(-r.retention))
+			if err != nil {
+				slog.Error("Failed to purge dead letters", "error", err)
+			} else if purged > 0 {
+				slog.Info("Purged expired dead letters", "count", purged)
+			}
+		}
+	}
+}
+
+func (r *Recorder) close() {
+	if r.producer == nil {
+		return
+	}
+	if remaining := r.producer.Flush(5000); remaining > 0 {
+		slog.Warn("Dead-letter producer closed with undelivered messages", "messages", remaining)
+	}
+	r.producer.Close()
+}
+
+// ErrNotReplayable is returned for dead letters replay does not support.
+var ErrNotReplayable = errors.New("dead letter cannot be replayed")
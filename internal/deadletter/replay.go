@@ -0,0 +1,125 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"belt-presense/internal/database"
+	"belt-presense/internal/models"
+	"belt-presense/internal/sink"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// replayTimeout bounds how long a republished message waits for delivery.
+const replayTimeout = 30 * time.Second
+
+// Replayer re-submits dead letters once the cause has been fixed. Consumed
+// messages are republished to their source topic, so the running service
+// processes them again; batches a sink rejected are sent to that sink again.
+type Replayer struct {
+	repo     *database.Repository
+	producer *kafka.Producer
+	sinks    map[string]sink.Sink
+	route    func(facilityID, deviceType string) sink.Destination
+}
+
+// NewReplayer replays through sinks; route picks the destination for a
+// rejected batch the same way the service would today.
+func NewReplayer(repo *database.Repository, brokers string, sinks []sink.Sink, route func(facilityID, deviceType string) sink.Destination) (*Replayer, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  brokers,
+		"enable.idempotence": true,
+		"message.timeout.ms": int(replayTimeout / time.Millisecond),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating replay producer: %w", err)
+	}
+	r := &Replayer{repo: repo, producer: producer, sinks: make(map[string]sink.Sink), route: route}
+	for _, s := range sinks {
+		r.sinks[s.Name()] = s
+	}
+	return r, nil
+}
+
+// Replay re-submits dl and marks it replayed on success.
+func (r *Replayer) Replay(ctx context.Context, dl *models.DeadLetter) error {
+	var err error
+	switch {
+	case dl.Origin == "kafka":
+		err = r.republish(ctx, dl)
+	case strings.HasPrefix(dl.Origin, "sink:"):
+		err = r.resend(ctx, dl, strings.TrimPrefix(dl.Origin, "sink:"))
+	default:
+		err = fmt.Errorf("%w: unknown origin %q", ErrNotReplayable, dl.Origin)
+	}
+	if err != nil {
+		return err
+	}
+	return r.repo.MarkDeadLetterReplayed(dl.ID)
+}
+
+// replayHeader marks a republished message with the dead letter it came
+// from.
+const replayHeader = "dl-replay-of"
+
+// republish sends the payload back to its source topic with its original
+// headers, so it is routed by its recorded message type and continues its
+// trace.
+func (r *Replayer) republish(ctx context.Context, dl *models.DeadLetter) error {
+	if dl.Topic == "" {
+		return fmt.Errorf("%w: no source topic recorded", ErrNotReplayable)
+	}
+	headers := make([]kafka.Header, 0, len(dl.Headers)+1)
+	for _, h := range dl.Headers {
+		if h.Key != replayHeader {
+			headers = append(headers, kafka.Header{Key: h.Key, Value: h.Value})
+		}
+	}
+	headers = append(headers, kafka.Header{Key: replayHeader, Value: []byte(strconv.FormatInt(dl.ID, 10))})
+
+	delivery := make(chan kafka.Event, 1)
+	err := r.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &dl.Topic, Partition: kafka.PartitionAny},
+		Value:          dl.Payload,
+		Headers:        headers,
+	}, delivery)
+	if err != nil {
+		return err
+	}
+	select {
+	case ev := <-delivery:
+		report, ok := ev.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery event %v", ev)
+		}
+		return report.TopicPartition.Error
+	case <-ctx.Done():
+		// The message may still be delivered; leaving the dead letter
+		// unmarked means a later replay could send it twice.
+		return ctx.Err()
+	}
+}
+
+func (r *Replayer) resend(ctx context.Context, dl *models.DeadLetter, name string) error {
+	s, ok := r.sinks[name]
+	if !ok {
+		return fmt.Errorf("%w: sink %q is not available for replay", ErrNotReplayable, name)
+	}
+	return s.Send(ctx, &sink.Envelope{
+		PatientID:   dl.PatientID,
+		FacilityID:  dl.FacilityID,
+		DeviceType:  dl.DeviceType,
+		TraceID:     "replay-" + strconv.FormatInt(dl.ID, 10),
+		Destination: r.route(dl.FacilityID, dl.DeviceType),
+		Body:        dl.Payload,
+	})
+}
+
+func (r *Replayer) Close() {
+	r.producer.Flush(5000)
+	r.producer.Close()
+}
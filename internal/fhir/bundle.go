@@ -0,0 +1,248 @@
+// Package fhir encodes processed batches as FHIR R4 Bundles of vital-sign
+// Observations.
+package fhir
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"belt-presense/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	systemLOINC       = "http://loinc.org"
+	systemMDC         = "urn:oid:2.16.840.1.113883.6.24"
+	systemUCUM        = "http://unitsofmeasure.org"
+	systemObsCategory = "http://terminology.hl7.org/CodeSystem/observation-category"
+)
+
+// Bundle types Encode can produce.
+const (
+	BundleTransaction = "transaction"
+	BundleCollection  = "collection"
+)
+
+// ContentType is the media type of an encoded bundle.
+const ContentType = "application/fhir+json"
+
+type Options struct {
+	// BundleType is transaction, whose entries PUT each Observation by ID,
+	// or collection.
+	BundleType string
+	// PatientSystem, EncounterSystem and DeviceSystem are the identifier
+	// systems for patient IDs, admission IDs and device IDs.
+	PatientSystem   string
+	EncounterSystem string
+	DeviceSystem    string
+	// ECGSampleRate is the belt's ECG sampling rate in Hz.
+	ECGSampleRate float64
+}
+
+// Batch is what a bundle is built from: the Presense payload, after the
+// route's payload options, and the ECG packets behind it. Messages may be
+// empty, as for a replayed dead letter, leaving only the cached vitals.
+type Batch struct {
+	PatientID string
+	Payload   *models.PresensePayload
+	Messages  []*models.ECGMessage
+	// VitalsDeviceID is the BP/SpO2 device the cached vitals came from.
+	VitalsDeviceID string
+}
+
+var (
+	vitalSigns = []CodeableConcept{{Coding: []Coding{{System: systemObsCategory, Code: "vital-signs", Display: "Vital Signs"}}}}
+	procedure  = []CodeableConcept{{Coding: []Coding{{System: systemObsCategory, Code: "procedure", Display: "Procedure"}}}}
+
+	codeHeartRate   = loinc("8867-4", "Heart rate")
+	codeRespRate    = loinc("9279-1", "Respiratory rate")
+	codePulseRate   = loinc("8889-8", "Heart rate by Pulse oximetry")
+	codeBPPanel     = loinc("85354-9", "Blood pressure panel with all children optional")
+	codeSystolic    = loinc("8480-6", "Systolic blood pressure")
+	codeDiastolic   = loinc("8462-4", "Diastolic blood pressure")
+	codeOxygenSat   = CodeableConcept{Coding: []Coding{{System: systemLOINC, Code: "59408-5", Display: "Oxygen saturation in Arterial blood by Pulse oximetry"}, {System: systemLOINC, Code: "2708-6", Display: "Oxygen saturation in Arterial blood"}}}
+	codeECGWaveform = CodeableConcept{Coding: []Coding{{System: systemLOINC, Code: "11524-6", Display: "EKG study"}, {System: systemMDC, Code: "131328", Display: "MDC_ECG_ELEC_POTL"}}, Text: "ECG channel A"}
+
+	// idSpace namespaces the name-based UUIDs used as Observation IDs.
+	idSpace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("urn:belt-presense:fhir"))
+)
+
+func loinc(code, display string) CodeableConcept {
+	return CodeableConcept{Coding: []Coding{{System: systemLOINC, Code: code, Display: display}}}
+}
+
+func perMinute(v int) *Quantity {
+	return &Quantity{Value: float64(v), Unit: "/min", System: systemUCUM, Code: "/min"}
+}
+
+// Encode builds the bundle for a batch. Observation IDs are derived from the
+// patient, the code and the time observed, so the same reading always gets
+// the same ID: a cached BP or SpO2 reading sent with several batches, or a
+// batch sent again, updates its Observation rather than adding another.
+func Encode(b Batch, opts Options) ([]byte, error) {
+	enc := &encoder{
+		opts:      opts,
+		patientID: b.PatientID,
+		subject:   Reference{Identifier: &Identifier{System: opts.PatientSystem, Value: b.PatientID}},
+	}
+	if len(b.Messages) > 0 && b.Messages[0].AdmissionID != "" {
+		enc.encounter = &Reference{Identifier: &Identifier{System: opts.EncounterSystem, Value: b.Messages[0].AdmissionID}}
+	}
+	if b.Payload.PatchID != "" {
+		enc.patch = &Reference{Identifier: &Identifier{System: opts.DeviceSystem, Value: b.Payload.PatchID}}
+	}
+	if b.VitalsDeviceID != "" {
+		enc.vitalsDevice = &Reference{Identifier: &Identifier{System: opts.DeviceSystem, Value: b.VitalsDeviceID}}
+	}
+
+	enc.ecgVitals(b.Messages)
+	enc.cachedVitals(b.Payload)
+	enc.ecgWaveforms(b.Messages)
+
+	bundle := Bundle{
+		ResourceType: "Bundle",
+		Type:         opts.BundleType,
+		Timestamp:    time.Now().UTC().Format(time.RFC3339Nano),
+		Entry:        make([]Entry, len(enc.observations)),
+	}
+	for i, obs := range enc.observations {
+		bundle.Entry[i] = Entry{FullURL: "urn:uuid:" + obs.ID, Resource: obs}
+		if opts.BundleType == BundleTransaction {
+			bundle.Entry[i].Request = &EntryRequest{Method: "PUT", URL: "Observation/" + obs.ID}
+		}
+	}
+	return json.Marshal(bundle)
+}
+
+type encoder struct {
+	opts         Options
+	patientID    string
+	subject      Reference
+	encounter    *Reference
+	patch        *Reference
+	vitalsDevice *Reference
+	observations []*Observation
+}
+
+func (e *encoder) add(category []CodeableConcept, code CodeableConcept, at int64, device *Reference) *Observation {
+	obs := &Observation{
+		ResourceType: "Observation",
+		ID:           uuid.NewSHA1(idSpace, []byte(e.patientID+"|"+code.Coding[0].Code+"|"+strconv.FormatInt(at, 10))).String(),
+		Status:       "final",
+		Category:     category,
+		Code:         code,
+		Subject:      e.subject,
+		Encounter:    e.encounter,
+		Device:       device,
+	}
+	e.observations = append(e.observations, obs)
+	return obs
+}
+
+// ecgVitals adds the most recent heart and respiratory rates the belt
+// reported in the batch.
+func (e *encoder) ecgVitals(messages []*models.ECGMessage) {
+	var hr, rr *models.ECGMessage
+	for _, msg := range messages {
+		if msg.HR > 0 {
+			hr = msg
+		}
+		if msg.RR > 0 {
+			rr = msg
+		}
+	}
+	if hr != nil {
+		obs := e.add(vitalSigns, codeHeartRate, hr.CurrentTimestamp, e.patch)
+		obs.EffectiveDateTime = instant(hr.CurrentTimestamp)
+		obs.ValueQuantity = perMinute(hr.HR)
+	}
+	if rr != nil {
+		obs := e.add(vitalSigns, codeRespRate, rr.CurrentTimestamp, e.patch)
+		obs.EffectiveDateTime = instant(rr.CurrentTimestamp)
+		obs.ValueQuantity = perMinute(rr.RR)
+	}
+}
+
+// cachedVitals adds the BP/SpO2 device's latest readings carried by the
+// payload.
+func (e *encoder) cachedVitals(p *models.PresensePayload) {
+	if p.SPO2.IsValid {
+		obs := e.add(vitalSigns, codeOxygenSat, p.SPO2.Timestamp, e.vitalsDevice)
+		obs.EffectiveDateTime = instant(p.SPO2.Timestamp)
+		obs.ValueQuantity = &Quantity{Value: float64(p.SPO2.Value), Unit: "%", System: systemUCUM, Code: "%"}
+	}
+	if p.PR.IsValid {
+		obs := e.add(vitalSigns, codePulseRate, p.PR.Timestamp, e.vitalsDevice)
+		obs.EffectiveDateTime = instant(p.PR.Timestamp)
+		obs.ValueQuantity = perMinute(p.PR.Value)
+	}
+	if p.BP.IsValid {
+		obs := e.add(vitalSigns, codeBPPanel, p.BP.Timestamp, e.vitalsDevice)
+		obs.EffectiveDateTime = instant(p.BP.Timestamp)
+		obs.Component = []Component{
+			{Code: codeSystolic, ValueQuantity: mmHg(p.BP.Sys)},
+			{Code: codeDiastolic, ValueQuantity: mmHg(p.BP.Dia)},
+		}
+	}
+}
+
+func mmHg(v int) *Quantity {
+	return &Quantity{Value: float64(v), Unit: "mmHg", System: systemUCUM, Code: "mm[Hg]"}
+}
+
+// ecgWaveforms adds channel A as SampledData, one Observation per run of
+// consecutive packets so that missing packets never join two stretches of
+// signal.
+func (e *encoder) ecgWaveforms(messages []*models.ECGMessage) {
+	if e.opts.ECGSampleRate <= 0 {
+		return
+	}
+	period := 1000 / e.opts.ECGSampleRate
+	var run []*models.ECGMessage
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		var data []string
+		for _, msg := range run {
+			for _, v := range msg.ECG_CH_A {
+				data = append(data, strconv.FormatFloat(v, 'f', -1, 64))
+			}
+		}
+		if len(data) > 0 {
+			start := run[0].CurrentTimestamp
+			obs := e.add(procedure, codeECGWaveform, start, e.patch)
+			obs.EffectivePeriod = &Period{
+				Start: instant(start),
+				End:   instant(start + int64(float64(len(data))*period)),
+			}
+			obs.ValueSampledData = &SampledData{
+				Origin:     Quantity{Value: 0, Unit: "mV", System: systemUCUM, Code: "mV"},
+				Period:     period,
+				Dimensions: 1,
+				Data:       strings.Join(data, " "),
+			}
+		}
+		run = run[:0]
+	}
+	for _, msg := range messages {
+		if len(run) > 0 && msg.PacketNo != run[len(run)-1].PacketNo+1 {
+			flush()
+		}
+		run = append(run, msg)
+	}
+	flush()
+}
+
+// instant formats a timestamp as a FHIR instant. Belt packets carry unix
+// milliseconds and BP/SpO2 readings unix seconds; values too small to be
+// milliseconds are taken as seconds.
+func instant(ts int64) string {
+	if ts < 1e12 {
+		ts *= 1000
+	}
+	return time.UnixMilli(ts).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
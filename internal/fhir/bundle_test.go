@@ -0,0 +1,211 @@
+package fhir
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"belt-presense/internal/models"
+)
+
+var testOptions = Options{
+	BundleType:      BundleTransaction,
+	PatientSystem:   "urn:example:patient",
+	EncounterSystem: "urn:example:encounter",
+	DeviceSystem:    "urn:example:device",
+	ECGSampleRate:   250,
+}
+
+func ecg(packetNo, ts int64, hr, rr int, samples ...float64) *models.ECGMessage {
+	return &models.ECGMessage{PatientID: "P-1", AdmissionID: "A-1", PacketNo: packetNo, CurrentTimestamp: ts, HR: hr, RR: rr, ECG_CH_A: samples}
+}
+
+func testBatch() Batch {
+	return Batch{
+		PatientID: "P-1",
+		Payload: &models.PresensePayload{
+			PatchID: "B-1",
+			SPO2:    models.VitalSign{IsValid: true, Value: 97, Timestamp: 1700000000},
+			PR:      models.VitalSign{IsValid: true, Value: 72, Timestamp: 1700000000},
+			BP:      models.BloodPressure{IsValid: true, Sys: 120, Dia: 80, Timestamp: 1700000000},
+		},
+		Messages: []*models.ECGMessage{
+			ecg(1, 1700000001000, 70, 14, 0.1, 0.2),
+			ecg(2, 1700000001008, 71, 0, 0.3),
+		},
+		VitalsDeviceID: "BP-9",
+	}
+}
+
+func decode(t *testing.T, b Batch, opts Options) Bundle {
+	t.Helper()
+	data, err := Encode(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func byCode(bundle Bundle) map[string]*Observation {
+	obs := make(map[string]*Observation)
+	for _, e := range bundle.Entry {
+		obs[e.Resource.Code.Coding[0].Code] = e.Resource
+	}
+	return obs
+}
+
+func TestEncodeObservations(t *testing.T) {
+	obs := byCode(decode(t, testBatch(), testOptions))
+
+	tests := []struct {
+		code   string
+		value  float64
+		unit   string
+		device string
+		at     string
+	}{
+		{"8867-4", 71, "/min", "B-1", "2023-11-14T22:13:21.008Z"}, // the latest HR
+		{"9279-1", 14, "/min", "B-1", "2023-11-14T22:13:21.000Z"}, // the latest non-zero RR
+		{"59408-5", 97, "%", "BP-9", "2023-11-14T22:13:20.000Z"},  // seconds, not ms
+		{"8889-8", 72, "/min", "BP-9", "2023-11-14T22:13:20.000Z"},
+	}
+	for _, tt := range tests {
+		o, ok := obs[tt.code]
+		if !ok {
+			t.Errorf("no %s Observation", tt.code)
+			continue
+		}
+		if o.ValueQuantity == nil || o.ValueQuantity.Value != tt.value || o.ValueQuantity.Unit != tt.unit {
+			t.Errorf("%s value = %+v, want %v %s", tt.code, o.ValueQuantity, tt.value, tt.unit)
+		}
+		if o.Device == nil || o.Device.Identifier.Value != tt.device || o.Device.Identifier.System != testOptions.DeviceSystem {
+			t.Errorf("%s device = %+v, want %s", tt.code, o.Device, tt.device)
+		}
+		if o.EffectiveDateTime != tt.at {
+			t.Errorf("%s effective = %s, want %s", tt.code, o.EffectiveDateTime, tt.at)
+		}
+		if o.Subject.Identifier.Value != "P-1" || o.Encounter == nil || o.Encounter.Identifier.Value != "A-1" {
+			t.Errorf("%s subject %+v, encounter %+v", tt.code, o.Subject.Identifier, o.Encounter)
+		}
+	}
+
+	bp := obs["85354-9"]
+	if bp == nil || len(bp.Component) != 2 || bp.Component[0].ValueQuantity.Value != 120 || bp.Component[1].ValueQuantity.Value != 80 {
+		t.Errorf("BP panel = %+v, want 120/80", bp)
+	}
+	if len(obs) != 6 {
+		t.Errorf("%d Observations, want 6", len(obs))
+	}
+}
+
+func TestEncodeBundleType(t *testing.T) {
+	tests := []struct {
+		bundleType  string
+		wantRequest bool
+	}{
+		{BundleTransaction, true},
+		{BundleCollection, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bundleType, func(t *testing.T) {
+			opts := testOptions
+			opts.BundleType = tt.bundleType
+			bundle := decode(t, testBatch(), opts)
+			if bundle.ResourceType != "Bundle" || bundle.Type != tt.bundleType {
+				t.Errorf("bundle is %s %s", bundle.ResourceType, bundle.Type)
+			}
+			for _, e := range bundle.Entry {
+				if e.FullURL != "urn:uuid:"+e.Resource.ID {
+					t.Errorf("fullUrl %s for Observation %s", e.FullURL, e.Resource.ID)
+				}
+				if got := e.Request != nil; got != tt.wantRequest {
+					t.Errorf("entry has a request: %t, want %t", got, tt.wantRequest)
+				} else if got && (e.Request.Method != "PUT" || e.Request.URL != "Observation/"+e.Resource.ID) {
+					t.Errorf("request = %+v, want PUT of Observation/%s", e.Request, e.Resource.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeStableIDs(t *testing.T) {
+	first := byCode(decode(t, testBatch(), testOptions))
+
+	// The next batch carries the same cached readings with new ECG packets.
+	next := testBatch()
+	next.Messages = []*models.ECGMessage{ecg(3, 1700000002000, 75, 15, 0.4)}
+	second := byCode(decode(t, next, testOptions))
+
+	for _, code := range []string{"59408-5", "8889-8", "85354-9"} {
+		if first[code].ID != second[code].ID {
+			t.Errorf("cached %s reading got a new ID: %s, then %s", code, first[code].ID, second[code].ID)
+		}
+	}
+	if first["8867-4"].ID == second["8867-4"].ID {
+		t.Error("new heart rate reading reused the previous ID")
+	}
+
+	other := testBatch()
+	other.PatientID = "P-2"
+	if byCode(decode(t, other, testOptions))["59408-5"].ID == first["59408-5"].ID {
+		t.Error("two patients share an Observation ID")
+	}
+}
+
+func TestEncodeWaveformRuns(t *testing.T) {
+	tests := []struct {
+		name     string
+		packets  []int64
+		rate     float64
+		wantRuns []string
+	}{
+		{"contiguous", []int64{1, 2, 3}, 250, []string{"1 2 3"}},
+		{"split at a gap", []int64{1, 2, 5, 6}, 250, []string{"1 2", "5 6"}},
+		{"split at a reset", []int64{40, 41, 1}, 250, []string{"40 41", "1"}},
+		{"no sample rate", []int64{1, 2}, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Batch{PatientID: "P-1", Payload: &models.PresensePayload{}}
+			for i, n := range tt.packets {
+				b.Messages = append(b.Messages, ecg(n, 1700000001000+int64(i)*4, 0, 0, float64(n)))
+			}
+			opts := testOptions
+			opts.ECGSampleRate = tt.rate
+
+			var runs []string
+			for _, e := range decode(t, b, opts).Entry {
+				sd := e.Resource.ValueSampledData
+				if sd == nil {
+					t.Fatalf("entry %s has no sampled data", e.Resource.Code.Coding[0].Code)
+				}
+				if sd.Period != 4 || sd.Dimensions != 1 {
+					t.Errorf("period %v, dimensions %d; want 4ms, 1", sd.Period, sd.Dimensions)
+				}
+				runs = append(runs, sd.Data)
+			}
+			if !reflect.DeepEqual(runs, tt.wantRuns) {
+				t.Errorf("runs = %q, want %q", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestInstant(t *testing.T) {
+	tests := []struct {
+		ts   int64
+		want string
+	}{
+		{1700000000, "2023-11-14T22:13:20.000Z"},
+		{1700000000123, "2023-11-14T22:13:20.123Z"},
+	}
+	for _, tt := range tests {
+		if got := instant(tt.ts); got != tt.want {
+			t.Errorf("instant(%d) = %s, want %s", tt.ts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package fhir
+
+// The subset of FHIR R4 used for vital-sign observations.
+
+type Bundle struct {
+	ResourceType string  `json:"resourceType"`
+	Type         string  `json:"type"`
+	Timestamp    string  `json:"timestamp"`
+	Entry        []Entry `json:"entry"`
+}
+
+type Entry struct {
+	FullURL  string        `json:"fullUrl"`
+	Resource *Observation  `json:"resource"`
+	Request  *EntryRequest `json:"request,omitempty"`
+}
+
+type EntryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type Observation struct {
+	ResourceType      string            `json:"resourceType"`
+	ID                string            `json:"id"`
+	Status            string            `json:"status"`
+	Category          []CodeableConcept `json:"category"`
+	Code              CodeableConcept   `json:"code"`
+	Subject           Reference         `json:"subject"`
+	Encounter         *Reference        `json:"encounter,omitempty"`
+	EffectiveDateTime string            `json:"effectiveDateTime,omitempty"`
+	EffectivePeriod   *Period           `json:"effectivePeriod,omitempty"`
+	Device            *Reference        `json:"device,omitempty"`
+	ValueQuantity     *Quantity         `json:"valueQuantity,omitempty"`
+	ValueSampledData  *SampledData      `json:"valueSampledData,omitempty"`
+	Component         []Component       `json:"component,omitempty"`
+}
+
+type Component struct {
+	Code          CodeableConcept `json:"code"`
+	ValueQuantity *Quantity       `json:"valueQuantity,omitempty"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Coding struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+// Reference points at a resource by business identifier, since the
+// patients, encounters and devices live in the receiving system.
+type Reference struct {
+	Identifier *Identifier `json:"identifier,omitempty"`
+	Display    string      `json:"display,omitempty"`
+}
+
+type Identifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+type Period struct {
+	Start string `json:"start"`
+	End   string `json:"end,omitempty"`
+}
+
+type Quantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`
+	System string  `json:"system"`
+	Code   string  `json:"code"`
+}
+
+type SampledData struct {
+	Origin     Quantity `json:"origin"`
+	Period     float64  `json:"period"` // milliseconds between samples
+	Dimensions int      `json:"dimensions"`
+	Data       string   `json:"data"`
+}
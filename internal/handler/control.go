@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"belt-presense/internal/logging"
+	"belt-presense/internal/models"
+)
+
+var ErrPatientNotActive = errors.New("patient is not being monitored")
+
+// PatientStatus is a point-in-time view of a patient's in-memory state.
+type PatientStatus struct {
+	models.PatientStream
+	PendingBatchSize int           `json:"pendingBatchSize"`
+	Vitals           *CachedVitals `json:"vitals,omitempty"`
+	// DeliveryQueue counts, by sink, batches cut but not yet delivered.
+	DeliveryQueue map[string]int `json:"deliveryQueue,omitempty"`
+}
+
+func (p *BeltProcessor) ActivePatients() []models.PatientStream {
+	p.activePatientsMu.RLock()
+	defer p.activePatientsMu.RUnlock()
+	patients := make([]models.PatientStream, 0, len(p.activePatients))
+	for _, patient := range p.activePatients {
+		patients = append(patients, patient)
+	}
+	return patients
+}
+
+// Reconfigure atomically replaces the processor's runtime settings. Batches
+// already being sent finish with the settings they started with.
+func (p *BeltProcessor) Reconfigure(opts Options) {
+	p.settings.Store(newSettings(opts))
+}
+
+// DeliveryQueues returns, for each sink, the batches not yet delivered by
+// patient.
+func (p *BeltProcessor) DeliveryQueues() map[string]map[string]int {
+	return p.sinks.PatientQueues()
+}
+
+// CacheSizes reports the number of entries in each in-memory cache.
+func (p *BeltProcessor) CacheSizes() map[string]int {
+	sizes := make(map[string]int, 5)
+	p.activePatientsMu.RLock()
+	sizes["active_patients"] = len(p.activePatients)
+	p.activePatientsMu.RUnlock()
+	p.patientBatchesMu.Lock()
+	sizes["pending_batches"] = len(p.patientBatches)
+	p.patientBatchesMu.Unlock()
+	p.vitalsCacheMu.RLock()
+	sizes["vitals"] = len(p.vitalsCache)
+	p.vitalsCacheMu.RUnlock()
+	sizes["reorder_held"] = p.heldPackets()
+	p.lastStreamedTimesMu.Lock()
+	sizes["last_streamed_times"] = len(p.lastStreamedTimes)
+	p.lastStreamedTimesMu.Unlock()
+	return sizes
+}
+
+func (p *BeltProcessor) PatientStatus(patientID string) (PatientStatus, bool) {
+	p.activePatientsMu.RLock()
+	patient, ok := p.activePatients[patientID]
+	p.activePatientsMu.RUnlock()
+	if !ok {
+		return PatientStatus{}, false
+	}
+
+	status := PatientStatus{PatientStream: patient}
+	p.patientBatchesMu.Lock()
+	if batch, exists := p.patientBatches[patientID]; exists {
+		status.PendingBatchSize = len(batch.Messages)
+	}
+	p.patientBatchesMu.Unlock()
+
+	p.vitalsCacheMu.RLock()
+	if vitals, exists := p.vitalsCache[patientID]; exists {
+		vitalsCopy := *vitals
+		status.Vitals = &vitalsCopy
+	}
+	p.vitalsCacheMu.RUnlock()
+
+	if sequence, ok := p.sequenceStats(patientID); ok {
+		status.Sequence = sequence
+	}
+	status.DeliveryQueue = p.sinks.PatientQueueDepth(patientID)
+	return status, true
+}
+
+func (p *BeltProcessor) StartPatient(patientID, facilityID, patchID string) error {
+	if patientID == "" || facilityID == "" {
+		return fmt.Errorf("patientId and facilityId are required")
+	}
+	if allowed := p.settings.Load().allowedFacilities; len(allowed) > 0 && !allowed[facilityID] {
+		return fmt.Errorf("facility %s is not in the allowed facilities list", facilityID)
+	}
+	p.activePatientsMu.Lock()
+	defer p.activePatientsMu.Unlock()
+	sessionID, err := p.db.StartMonitoring(patientID, facilityID, patchID)
+	if err != nil {
+		return err
+	}
+	p.activePatients[patientID] = models.PatientStream{
+		SessionID:  sessionID,
+		PatientID:  patientID,
+		DeviceID:   patchID,
+		FacilityID: facilityID,
+		StartTime:  time.Now().Unix(),
+		Status:     "running",
+	}
+	p.resetSequence(patientID, sessionID, models.SequenceStats{})
+	p.resetPacketWindow(patientID, patchID)
+	p.dropReorder(patientID)
+	slog.Info("Started monitoring patient", logging.KeyPatient, patientID, logging.KeyPatch, patchID, logging.KeyFacility, facilityID)
+	return nil
+}
+
+func (p *BeltProcessor) StopPatient(patientID string) error {
+	p.activePatientsMu.Lock()
+	defer p.activePatientsMu.Unlock()
+	patient, ok := p.activePatients[patientID]
+	if !ok || patient.Status == "stopped" {
+		return ErrPatientNotActive
+	}
+	if err := p.db.StopMonitoring(patient.SessionID); err != nil {
+		return err
+	}
+	patient.Status = "stopped"
+	now := time.Now().Unix()
+	patient.EndTime = &now
+	p.activePatients[patientID] = patient
+
+	mu := p.patientLock(patientID)
+	mu.Lock()
+	p.dropReorder(patientID)
+	p.patientBatchesMu.Lock()
+	delete(p.patientBatches, patientID)
+	p.patientBatchesMu.Unlock()
+	mu.Unlock()
+	slog.Info("Stopped monitoring patient", logging.KeyPatient, patientID, logging.KeyPatch, patient.DeviceID)
+	return nil
+}
+
+// FlushPatient sends the patient's partial batch immediately and returns the
+// number of packets it contained.
+func (p *BeltProcessor) FlushPatient(ctx context.Context, patientID string) (int, error) {
+	p.activePatientsMu.RLock()
+	patient, ok := p.activePatients[patientID]
+	p.activePatientsMu.RUnlock()
+	if !ok || patient.Status == "stopped" {
+		return 0, ErrPatientNotActive
+	}
+
+	mu := p.patientLock(patientID)
+	mu.Lock()
+	defer mu.Unlock()
+	p.drainReorder(patientID)
+	p.patientBatchesMu.Lock()
+	batch, exists := p.patientBatches[patientID]
+	delete(p.patientBatches, patientID)
+	p.patientBatchesMu.Unlock()
+	if !exists || len(batch.Messages) == 0 {
+		return 0, nil
+	}
+
+	lastMessage := batch.Messages[len(batch.Messages)-1]
+	traceID := fmt.Sprintf("%s-%d", patientID, lastMessage.PacketNo)
+	p.processAndSendBatch(context.WithoutCancel(ctx), patientID, batch, traceID)
+	return len(batch.Messages), nil
+}
+
+func (p *BeltProcessor) patientForPatch(patchID string) string {
+	p.activePatientsMu.RLock()
+	defer p.activePatientsMu.RUnlock()
+	for id, stream := range p.activePatients {
+		if stream.DeviceID == patchID && stream.Status != "stopped" {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+)
+
+// packetWindow remembers the most recent packet numbers of one patient's
+// stream, with their timestamps.
+type packetWindow struct {
+	patchID string
+	size    int
+	seen    map[int64]int64
+	order   []int64 // packet numbers, oldest first
+	dirty   bool
+}
+
+func newPacketWindow(patchID string, size int) *packetWindow {
+	return &packetWindow{patchID: patchID, size: size, seen: make(map[int64]int64, size)}
+}
+
+func restorePacketWindow(w models.PacketWindow, size int) *packetWindow {
+	pw := newPacketWindow(w.PatchID, size)
+	for _, ref := range w.Packets {
+		pw.add(ref.PacketNo, ref.Timestamp)
+	}
+	pw.dirty = false
+	return pw
+}
+
+// duplicate reports whether the packet was already seen, recording it if
+// not. A seen packet number with a newer timestamp is not a duplicate: the
+// belt has restarted its counter.
+func (w *packetWindow) duplicate(patchID string, packetNo, timestamp int64) bool {
+	if patchID != w.patchID {
+		*w = *newPacketWindow(patchID, w.size)
+	}
+	if ts, ok := w.seen[packetNo]; ok {
+		if timestamp <= ts {
+			return true
+		}
+		w.remove(packetNo)
+	}
+	w.add(packetNo, timestamp)
+	return false
+}
+
+func (w *packetWindow) add(packetNo, timestamp int64) {
+	w.seen[packetNo] = timestamp
+	w.order = append(w.order, packetNo)
+	if len(w.order) > w.size {
+		delete(w.seen, w.order[0])
+		w.order = w.order[1:]
+	}
+	w.dirty = true
+}
+
+func (w *packetWindow) remove(packetNo int64) {
+	delete(w.seen, packetNo)
+	for i, n := range w.order {
+		if n == packetNo {
+			w.order = append(w.order[:i], w.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (w *packetWindow) snapshot() models.PacketWindow {
+	packets := make([]models.PacketRef, len(w.order))
+	for i, n := range w.order {
+		packets[i] = models.PacketRef{PacketNo: n, Timestamp: w.seen[n]}
+	}
+	return models.PacketWindow{PatchID: w.patchID, Packets: packets}
+}
+
+// suppressDuplicate reports whether msg repeats a packet already accepted
+// for its patient, counting it if so.
+func (p *BeltProcessor) suppressDuplicate(ctx context.Context, msg *models.ECGMessage) bool {
+	if p.dedupeWindow == 0 {
+		return false
+	}
+	p.packetWindowsMu.Lock()
+	w, ok := p.packetWindows[msg.PatientID]
+	if !ok {
+		w = newPacketWindow(msg.DeviceID, p.dedupeWindow)
+		p.packetWindows[msg.PatientID] = w
+	}
+	dup := w.duplicate(msg.DeviceID, msg.PacketNo, msg.CurrentTimestamp)
+	p.packetWindowsMu.Unlock()
+	if !dup {
+		return false
+	}
+
+	metrics.DuplicatesSuppressed.WithLabelValues(metrics.FacilityLabel(msg.FacilityID)).Inc()
+	p.sequencesMu.Lock()
+	if tracker, ok := p.sequences[msg.PatientID]; ok {
+		tracker.session.Duplicates++
+		tracker.pending.Duplicates++
+	}
+	p.sequencesMu.Unlock()
+	logging.FromContext(ctx).Debug("Suppressed duplicate packet", logging.KeyPatient, msg.PatientID, logging.KeyPatch, msg.DeviceID, "packetNo", msg.PacketNo)
+	return true
+}
+
+// resetPacketWindow starts an empty window for a new session. It is marked
+// changed so the next snapshot replaces the previous session's window.
+func (p *BeltProcessor) resetPacketWindow(patientID, patchID string) {
+	if p.dedupeWindow == 0 {
+		return
+	}
+	w := newPacketWindow(patchID, p.dedupeWindow)
+	w.dirty = true
+	p.packetWindowsMu.Lock()
+	p.packetWindows[patientID] = w
+	p.packetWindowsMu.Unlock()
+}
+
+func (p *BeltProcessor) loadPacketWindows() error {
+	if p.dedupeWindow == 0 {
+		return nil
+	}
+	windows, err := p.db.GetPacketWindows()
+	if err != nil {
+		return err
+	}
+	p.activePatientsMu.RLock()
+	defer p.activePatientsMu.RUnlock()
+	p.packetWindowsMu.Lock()
+	defer p.packetWindowsMu.Unlock()
+	for patientID, w := range windows {
+		if _, active := p.activePatients[patientID]; active {
+			p.packetWindows[patientID] = restorePacketWindow(w, p.dedupeWindow)
+		}
+	}
+	return nil
+}
+
+// dropPacketWindows forgets the windows of patients no longer monitored.
+func (p *BeltProcessor) dropPacketWindows(patientIDs []string) {
+	if p.dedupeWindow == 0 || len(patientIDs) == 0 {
+		return
+	}
+	p.packetWindowsMu.Lock()
+	for _, id := range patientIDs {
+		delete(p.packetWindows, id)
+	}
+	p.packetWindowsMu.Unlock()
+	if err := p.db.DeletePacketWindows(patientIDs); err != nil {
+		slog.Error("Failed to delete packet windows", "error", err)
+	}
+}
+
+func (p *BeltProcessor) savePacketWindows() {
+	p.packetWindowsMu.Lock()
+	changed := make(map[string]models.PacketWindow)
+	for patientID, w := range p.packetWindows {
+		if w.dirty {
+			changed[patientID] = w.snapshot()
+			w.dirty = false
+		}
+	}
+	p.packetWindowsMu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	if err := p.db.SavePacketWindows(changed); err != nil {
+		slog.Error("Failed to save packet windows", "error", err)
+		p.packetWindowsMu.Lock()
+		for patientID := range changed {
+			if w, ok := p.packetWindows[patientID]; ok {
+				w.dirty = true
+			}
+		}
+		p.packetWindowsMu.Unlock()
+	}
+}
+
+// RunDedupeSnapshots saves changed packet windows every interval and once
+// more on shutdown, so redelivered packets are still recognised after a
+// restart.
+func (p *BeltProcessor) RunDedupeSnapshots(ctx context.Context, interval time.Duration) {
+	if p.dedupeWindow == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.savePacketWindows()
+			return
+		case <-ticker.C:
+			p.savePacketWindows()
+		}
+	}
+}
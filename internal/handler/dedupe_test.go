@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"belt-presense/internal/models"
+)
+
+func TestPacketWindowDuplicate(t *testing.T) {
+	type seen struct {
+		patch   string
+		no, ts  int64
+		wantDup bool
+	}
+	tests := []struct {
+		name    string
+		packets []seen
+	}{
+		{"repeat", []seen{{"B-1", 1, 1000, false}, {"B-1", 2, 2000, false}, {"B-1", 1, 1000, true}}},
+		{"repeat with an older timestamp", []seen{{"B-1", 1, 1000, false}, {"B-1", 1, 900, true}}},
+		{"counter restart", []seen{{"B-1", 1, 1000, false}, {"B-1", 1, 5000, false}, {"B-1", 1, 5000, true}}},
+		{"out of order is not a repeat", []seen{{"B-1", 2, 2000, false}, {"B-1", 1, 1000, false}}},
+		{"forgotten once out of the window", []seen{
+			{"B-1", 1, 1000, false}, {"B-1", 2, 2000, false}, {"B-1", 3, 3000, false}, {"B-1", 4, 4000, false},
+			{"B-1", 1, 1000, false}, {"B-1", 4, 4000, true},
+		}},
+		{"new patch starts afresh", []seen{{"B-1", 1, 1000, false}, {"B-2", 1, 1000, false}, {"B-2", 1, 1000, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newPacketWindow("B-1", 3)
+			for i, p := range tt.packets {
+				if got := w.duplicate(p.patch, p.no, p.ts); got != p.wantDup {
+					t.Errorf("packet %d (%s #%d at %d): duplicate() = %t, want %t", i, p.patch, p.no, p.ts, got, p.wantDup)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketWindowRestore(t *testing.T) {
+	w := newPacketWindow("B-1", 3)
+	for n := int64(1); n <= 4; n++ {
+		w.duplicate("B-1", n, n*1000)
+	}
+	snap := w.snapshot()
+	want := models.PacketWindow{PatchID: "B-1", Packets: []models.PacketRef{{PacketNo: 2, Timestamp: 2000}, {PacketNo: 3, Timestamp: 3000}, {PacketNo: 4, Timestamp: 4000}}}
+	if !reflect.DeepEqual(snap, want) {
+		t.Fatalf("snapshot() = %+v, want %+v", snap, want)
+	}
+
+	restored := restorePacketWindow(snap, 3)
+	if restored.dirty {
+		t.Error("restored window is marked changed")
+	}
+	if !restored.duplicate("B-1", 3, 3000) {
+		t.Error("restored window missed a repeat")
+	}
+	if restored.duplicate("B-1", 1, 1000) {
+		t.Error("restored window remembers a packet that had left it")
+	}
+}
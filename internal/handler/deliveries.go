@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"belt-presense/internal/deadletter"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+	"belt-presense/internal/sink"
+)
+
+// Delivery results, as reported in metrics and delivery records.
+const (
+	deliverySuccess  = "success"
+	deliveryRejected = "rejected"
+	deliveryInvalid  = "invalid"
+	deliveryError    = "error"
+	deliveryEvicted  = "evicted"
+)
+
+func deliveryResult(err error) string {
+	var statusErr *sink.StatusError
+	switch {
+	case err == nil:
+		return deliverySuccess
+	case errors.Is(err, sink.ErrInvalid):
+		return deliveryInvalid
+	case errors.As(err, &statusErr):
+		return deliveryRejected
+	default:
+		return deliveryError
+	}
+}
+
+// recordDelivery tracks the outcome of Presense deliveries for metrics,
+// health, the last-streamed time and delivery records, and dead-letters
+// batches Presense rejects outright or that were evicted before they could
+// be sent.
+func (p *BeltProcessor) recordDelivery(r sink.Result) {
+	if r.Sink != "presense" {
+		return
+	}
+	facility := metrics.FacilityLabel(r.Envelope.FacilityID)
+	if errors.Is(r.Err, sink.ErrEvicted) {
+		metrics.BatchesSent.WithLabelValues(facility, deliveryEvicted).Inc()
+		p.deadLetterBatch(r, deadletter.ReasonEvicted)
+		p.saveDelivery(r, deliveryEvicted)
+		return
+	}
+	result := deliveryResult(r.Err)
+	// A batch Presense answered but refused says nothing about whether
+	// Presense is reachable, so only successes, transport errors and
+	// retryable statuses count towards delivery health.
+	if result == deliverySuccess || result == deliveryError || (result == deliveryRejected && !sink.IsPermanent(r.Err)) {
+		p.delivery.record(r.Err)
+	}
+	metrics.BatchesSent.WithLabelValues(facility, result).Inc()
+	switch result {
+	case deliveryInvalid:
+		p.deadLetterBatch(r, deadletter.ReasonInvalid)
+	case deliveryRejected:
+		if sink.IsPermanent(r.Err) {
+			p.deadLetterBatch(r, deadletter.ReasonRejected)
+		}
+	case deliverySuccess:
+		p.lastStreamedTimesMu.Lock()
+		p.lastStreamedTimes[r.Envelope.PatientID] = time.Now().Unix()
+		p.lastStreamedTimesMu.Unlock()
+	}
+	p.saveDelivery(r, result)
+}
+
+func (p *BeltProcessor) deadLetterBatch(r sink.Result, reason string) {
+	p.deadLetters.Record(context.Background(), &models.DeadLetter{
+		Origin:     "sink:" + r.Sink,
+		Reason:     reason,
+		Error:      r.Err.Error(),
+		PatientID:  r.Envelope.PatientID,
+		FacilityID: r.Envelope.FacilityID,
+		DeviceType: r.Envelope.DeviceType,
+		Payload:    r.Envelope.Body,
+	})
+}
+
+func (p *BeltProcessor) saveDelivery(r sink.Result, result string) {
+	if !p.recordDeliveries {
+		return
+	}
+	d := &models.Delivery{
+		PatientID:  r.Envelope.PatientID,
+		FacilityID: r.Envelope.FacilityID,
+		Route:      r.Envelope.Route,
+		TraceID:    r.Envelope.TraceID,
+		Result:     result,
+		Attempts:   r.Attempts,
+		CreatedAt:  time.Now().UnixMilli(),
+	}
+	if r.Err != nil {
+		d.Error = r.Err.Error()
+	}
+	if r.Response != nil {
+		d.StatusCode = r.Response.StatusCode
+		d.Response = r.Response.Body
+	}
+	if err := p.db.AddDelivery(d); err != nil {
+		slog.Error("Failed to store delivery record", "error", err)
+	}
+}
+
+func (p *BeltProcessor) purgeDeliveries() {
+	if !p.recordDeliveries || p.deliveryRetention <= 0 {
+		return
+	}
+	purged, err := p.db.PurgeDeliveries(time.Now().Add(-p.deliveryRetention))
+	if err != nil {
+		slog.Error("Failed to purge delivery records", "error", err)
+	} else if purged > 0 {
+		slog.Info("Purged expired delivery records", "count", purged)
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"belt-presense/internal/database"
+	"belt-presense/internal/deadletter"
+	"belt-presense/internal/sink"
+)
+
+func TestRecordDelivery(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int // 0 sends to a closed server
+		body   string
+		evict  bool
+
+		wantResult     string
+		wantDeadLetter string
+		// wantFailures is the consecutive failure count after the result,
+		// starting from 2.
+		wantFailures int
+	}{
+		{"accepted", 200, `{"id":"b-1","accepted":3}`, false, deliverySuccess, "", 0},
+		{"all items rejected", 200, `{"accepted":0,"rejected":3,"errors":[{"message":"bad"}]}`, false, deliveryInvalid, deadletter.ReasonInvalid, 2},
+		{"validation errors", 400, `{"errors":[{"field":"SEQ","message":"missing"}]}`, false, deliveryInvalid, deadletter.ReasonInvalid, 2},
+		{"forbidden", 403, `{"message":"facility not enabled"}`, false, deliveryRejected, deadletter.ReasonRejected, 2},
+		{"server error", 503, ``, false, deliveryRejected, "", 3},
+		{"unreachable", 0, ``, false, deliveryError, "", 3},
+		{"evicted", 0, ``, true, deliveryEvicted, deadletter.ReasonEvicted, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := database.NewRepository(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer repo.Close()
+			recorder, err := deadletter.NewRecorder(deadletter.Options{Repo: repo})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &BeltProcessor{db: repo, deadLetters: recorder, recordDeliveries: true, lastStreamedTimes: make(map[string]int64)}
+			p.delivery.consecutiveFailures = 2
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			if tt.status == 0 {
+				srv.Close()
+			} else {
+				defer srv.Close()
+			}
+			env := &sink.Envelope{PatientID: "P-1", FacilityID: "F-1", Route: "default", TraceID: "P-1-10", Body: []byte(`{}`),
+				Destination: sink.Destination{URL: srv.URL, Auth: sink.BearerAuth{Token: "tok"}}}
+
+			r := sink.Result{Sink: "presense", Envelope: env, Attempts: 1}
+			if tt.evict {
+				r.Attempts = 0
+				r.Err = fmt.Errorf("%w: queue full (1 batches)", sink.ErrEvicted)
+			} else {
+				r.Response, r.Err = sink.NewPresense(sink.PresenseOptions{}).SendWithResponse(context.Background(), env)
+			}
+			p.recordDelivery(r)
+
+			deliveries, err := repo.GetDeliveries(database.DeliveryFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) != 1 {
+				t.Fatalf("%d delivery records, want 1", len(deliveries))
+			}
+			d := deliveries[0]
+			if d.Result != tt.wantResult || d.StatusCode != tt.status || d.TraceID != "P-1-10" || d.Route != "default" {
+				t.Errorf("delivery record = %+v, want result %s and status %d", d, tt.wantResult, tt.status)
+			}
+			if (d.Error == "") != (tt.wantResult == deliverySuccess) {
+				t.Errorf("delivery record error = %q", d.Error)
+			}
+
+			letters, err := repo.GetDeadLetters(database.DeadLetterFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.wantDeadLetter == "" && len(letters) != 0:
+				t.Errorf("dead-lettered as %s, want no dead letter", letters[0].Reason)
+			case tt.wantDeadLetter != "" && (len(letters) != 1 || letters[0].Reason != tt.wantDeadLetter || letters[0].Origin != "sink:presense" || string(letters[0].Payload) != "{}"):
+				t.Errorf("dead letters = %+v, want one with reason %s", letters, tt.wantDeadLetter)
+			}
+
+			if got := p.delivery.consecutiveFailures; got != tt.wantFailures {
+				t.Errorf("consecutive failures = %d, want %d", got, tt.wantFailures)
+			}
+			if _, streamed := p.lastStreamedTimes["P-1"]; streamed != (tt.wantResult == deliverySuccess) {
+				t.Errorf("last streamed time recorded: %t", streamed)
+			}
+		})
+	}
+}
+
+func TestRefusedBatchesKeepReadiness(t *testing.T) {
+	p := &BeltProcessor{}
+	invalid := fmt.Errorf("presense API: %w", sink.Permanent(fmt.Errorf("%w: bad", sink.ErrInvalid)))
+	for i := 0; i < 2*maxConsecutiveFailures; i++ {
+		p.recordDelivery(sink.Result{Sink: "presense", Envelope: &sink.Envelope{PatientID: "P-1"}, Err: invalid})
+	}
+	if err := p.CheckDelivery(context.Background()); err != nil {
+		t.Errorf("CheckDelivery() = %v after invalid batches only", err)
+	}
+}
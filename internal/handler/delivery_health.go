@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxConsecutiveFailures is how many deliveries in a row may fail before
+// the processor reports itself unhealthy.
+const maxConsecutiveFailures = 5
+
+type deliveryHealth struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	lastError           error
+	lastSuccess         time.Time
+}
+
+func (d *deliveryHealth) record(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.consecutiveFailures++
+		d.lastError = err
+		return
+	}
+	d.consecutiveFailures = 0
+	d.lastError = nil
+	d.lastSuccess = time.Now()
+}
+
+// CheckDelivery fails when recent deliveries to the destination have all
+// failed.
+func (p *BeltProcessor) CheckDelivery(ctx context.Context) error {
+	p.delivery.mu.Lock()
+	defer p.delivery.mu.Unlock()
+	if p.delivery.consecutiveFailures < maxConsecutiveFailures {
+		return nil
+	}
+	lastSuccess := "never"
+	if !p.delivery.lastSuccess.IsZero() {
+		lastSuccess = time.Since(p.delivery.lastSuccess).Round(time.Second).String() + " ago"
+	}
+	return fmt.Errorf("%d consecutive delivery failures (last success %s): %v",
+		p.delivery.consecutiveFailures, lastSuccess, p.delivery.lastError)
+}
@@ -1,90 +1,197 @@
 package handler
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"belt-presense/internal/database"
+	"belt-presense/internal/deadletter"
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
 	"belt-presense/internal/models"
-)
+	"belt-presense/internal/sink"
+	"belt-presense/internal/tracing"
 
-const chunkSize = 30
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type PatientBatch struct {
 	Messages []*models.ECGMessage
+	// Links ties the batch's processing span to the spans of the packets it
+	// was assembled from.
+	Links []trace.Link
+	// Gaps marks, by index into Messages, packets preceded by missing ones.
+	Gaps map[int]*models.GapMarker
+}
+
+func (b *PatientBatch) add(msg *models.ECGMessage, link trace.Link, gap *models.GapMarker) {
+	if gap != nil {
+		if b.Gaps == nil {
+			b.Gaps = make(map[int]*models.GapMarker)
+		}
+		b.Gaps[len(b.Messages)] = gap
+	}
+	b.Messages = append(b.Messages, msg)
+	b.Links = append(b.Links, link)
 }
 
 type CachedVitals struct {
-	BP          models.BloodPressure
-	SPO2        models.VitalSign
-	PR          models.VitalSign
-	DeviceID    string
-	LastUpdated int64
+	BP          models.BloodPressure `json:"bp"`
+	SPO2        models.VitalSign     `json:"spo2"`
+	PR          models.VitalSign     `json:"pr"`
+	Temperature *TemperatureReading  `json:"temperature,omitempty"`
+	DeviceID    string               `json:"deviceId"`
+	LastUpdated int64                `json:"lastUpdated"`
+}
+
+type TemperatureReading struct {
+	Body      int   `json:"body"`
+	Skin      int   `json:"skin"`
+	Ambient   int   `json:"ambient"`
+	Timestamp int64 `json:"timestamp"`
 }
 
 type BeltProcessor struct {
 	db                  *database.Repository
-	httpClient          *http.Client
-	endpointURL         string
-	apiKey              string
-	dataSource          string
-	writeToFile         bool
+	sinks               *sink.Dispatcher
+	deadLetters         *deadletter.Recorder
+	handlers            map[string]vitalsHandler
+	settings            atomic.Pointer[settings]
 	activePatients      map[string]models.PatientStream
 	patientBatches      map[string]*PatientBatch
 	vitalsCache         map[string]*CachedVitals
 	lastStreamedTimes   map[string]int64
+	sequences           map[string]*sequenceTracker
+	packetWindows       map[string]*packetWindow
+	reorderBuffers      map[string]*reorderBuffer
+	patientLocks        map[string]*sync.Mutex
+	dedupeWindow        int
+	recordDeliveries    bool
+	deliveryRetention   time.Duration
 	activePatientsMu    sync.RWMutex
 	patientBatchesMu    sync.Mutex
 	vitalsCacheMu       sync.RWMutex
 	lastStreamedTimesMu sync.Mutex
+	sequencesMu         sync.Mutex
+	packetWindowsMu     sync.Mutex
+	reorderMu           sync.Mutex
+	patientLocksMu      sync.Mutex
+	delivery            deliveryHealth
+}
+
+// Options configures a BeltProcessor.
+type Options struct {
+	// DefaultRoute receives batches that match none of Routes.
+	DefaultRoute Route
+	Routes       []Route
+	BatchSize    int
+	// AllowedFacilities restricts which facilities may start sessions;
+	// empty allows all.
+	AllowedFacilities []string
+	// DeviceTypes maps a message's deviceType field to the message type
+	// that handles it, for producers that set no explicit type. Keys are
+	// matched without regard to case.
+	DeviceTypes map[string]string
+	Reorder     ReorderOptions
+	// DedupeWindow is how many recent packets per patient are checked for
+	// duplicates; 0 disables suppression. It cannot be reconfigured.
+	DedupeWindow int
+	// RecordDeliveries stores the outcome of every Presense delivery, kept
+	// for DeliveryRetention (zero keeps them). Neither can be reconfigured.
+	RecordDeliveries  bool
+	DeliveryRetention time.Duration
 }
 
-func NewBeltProcessor(repo *database.Repository, endpointURL, apiKey, dataSource string, writeToFile bool) (*BeltProcessor, error) {
+// settings is the part of Options that can be swapped while running.
+type settings struct {
+	defaultRoute      Route
+	routes            []Route
+	batchSize         int
+	allowedFacilities map[string]bool
+	deviceTypes       map[string]string
+	reorder           ReorderOptions
+}
+
+func newSettings(opts Options) *settings {
+	allowed := make(map[string]bool, len(opts.AllowedFacilities))
+	for _, id := range opts.AllowedFacilities {
+		allowed[id] = true
+	}
+	deviceTypes := make(map[string]string, len(opts.DeviceTypes))
+	for deviceType, messageType := range opts.DeviceTypes {
+		deviceTypes[normalizeType(deviceType)] = normalizeType(messageType)
+	}
+	return &settings{
+		defaultRoute:      opts.DefaultRoute,
+		routes:            opts.Routes,
+		batchSize:         opts.BatchSize,
+		allowedFacilities: allowed,
+		deviceTypes:       deviceTypes,
+		reorder:           opts.Reorder,
+	}
+}
+
+// NewBeltProcessor hands every processed batch to sinks, which must not be
+// running yet, and records messages it cannot use in deadLetters, which may
+// be nil.
+func NewBeltProcessor(repo *database.Repository, sinks *sink.Dispatcher, deadLetters *deadletter.Recorder, opts Options) (*BeltProcessor, error) {
 	p := &BeltProcessor{
 		db:                repo,
-		httpClient:        &http.Client{Timeout: 15 * time.Second},
-		endpointURL:       endpointURL,
-		apiKey:            apiKey,
-		dataSource:        dataSource,
-		writeToFile:       writeToFile,
+		sinks:             sinks,
+		deadLetters:       deadLetters,
 		patientBatches:    make(map[string]*PatientBatch),
 		activePatients:    make(map[string]models.PatientStream),
 		vitalsCache:       make(map[string]*CachedVitals),
 		lastStreamedTimes: make(map[string]int64),
+		sequences:         make(map[string]*sequenceTracker),
+		packetWindows:     make(map[string]*packetWindow),
+		reorderBuffers:    make(map[string]*reorderBuffer),
+		patientLocks:      make(map[string]*sync.Mutex),
+		dedupeWindow:      opts.DedupeWindow,
+		recordDeliveries:  opts.RecordDeliveries,
+		deliveryRetention: opts.DeliveryRetention,
 	}
 
+	p.settings.Store(newSettings(opts))
+	p.handlers = p.vitalsHandlers()
+	sinks.OnResult(p.recordDelivery)
+
 	if err := p.loadActivePatients(); err != nil {
 		return nil, err
 	}
-	log.Printf("Service restored. Monitoring %d patients.", len(p.activePatients))
+	if err := p.loadPacketWindows(); err != nil {
+		return nil, err
+	}
+	slog.Info("Service restored", "activePatients", len(p.activePatients))
 	return p, nil
 }
 
 func (p *BeltProcessor) RunHousekeepingCycle(ctx context.Context) {
-	log.Println("Housekeeping cycle started. Will update DB every 1 minute.")
+	slog.Info("Housekeeping cycle started", "interval", time.Minute)
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Housekeeping cycle stopping.")
+			slog.Info("Housekeeping cycle stopping")
 			return
 		case <-ticker.C:
 			now := time.Now().Unix()
 
+			// Session IDs are read before pruning, so a session stopped
+			// since the last cycle still gets its final updates.
 			var patientsToPrune []string
+			sessionIDs := make(map[string]int64)
 			p.activePatientsMu.RLock()
 			for patientID, patient := range p.activePatients {
+				sessionIDs[patientID] = patient.SessionID
 				if patient.Status == "stopped" {
 					patientsToPrune = append(patientsToPrune, patientID)
 				}
@@ -101,19 +208,36 @@ func (p *BeltProcessor) RunHousekeepingCycle(ctx context.Context) {
 
 			p.lastStreamedTimesMu.Lock()
 			updatesToProcess := make(map[string]int64, len(p.lastStreamedTimes))
+			streamedSessions := make(map[int64]int64, len(p.lastStreamedTimes))
 			for patientID, ts := range p.lastStreamedTimes {
 				updatesToProcess[patientID] = ts
+				if sessionID := sessionIDs[patientID]; sessionID != 0 {
+					streamedSessions[sessionID] = ts
+				}
 			}
 			p.lastStreamedTimes = make(map[string]int64)
 			p.lastStreamedTimesMu.Unlock()
 
-			if len(updatesToProcess) > 0 {
-				if err := p.db.BatchUpdateLastStreamedTime(updatesToProcess); err != nil {
-					log.Printf("ERROR during housekeeping DB update: %v", err)
+			if len(streamedSessions) > 0 {
+				if err := p.db.BatchUpdateLastStreamedTime(streamedSessions); err != nil {
+					slog.Error("Housekeeping DB update failed", "error", err)
 				} else {
-					log.Printf("Housekeeping: Updated last streamed time for %d patients in DB.", len(updatesToProcess))
+					slog.Debug("Housekeeping updated last streamed times", "patients", len(updatesToProcess))
+				}
+			}
+
+			p.dropPacketWindows(patientsToPrune)
+			sequenceUpdates := p.takeSequenceStats(patientsToPrune)
+			var cycleSequence models.SequenceStats
+			for _, stats := range sequenceUpdates {
+				cycleSequence.Add(stats)
+			}
+			if len(sequenceUpdates) > 0 {
+				if err := p.db.AddSequenceStats(sequenceUpdates); err != nil {
+					slog.Error("Housekeeping sequence stats update failed", "error", err)
 				}
 			}
+			p.purgeDeliveries()
 
 			recentVitals := make(map[string]string)
 			var clearedDeviceIDs []string
@@ -130,68 +254,45 @@ func (p *BeltProcessor) RunHousekeepingCycle(ctx context.Context) {
 			}
 			p.vitalsCacheMu.Unlock()
 
-			var report strings.Builder
-			report.WriteString("\n--- Housekeeping Report ---\n")
-			report.WriteString(fmt.Sprintf("%-15s | %-15s | %-10s | %-18s\n", "Patient", "Belt ID", "Streaming?", "Recent Vital Device?"))
-			report.WriteString(strings.Repeat("-", 66) + "\n")
-
 			p.activePatientsMu.RLock()
-			if len(p.activePatients) == 0 {
-				report.WriteString("No active patients being monitored.\n")
-			} else {
-				for patientID, patient := range p.activePatients {
-					streamingStatus := "false"
-					if _, ok := updatesToProcess[patientID]; ok {
-						streamingStatus = "true"
-					}
-					vitalDevice := "none"
-					if deviceID, ok := recentVitals[patientID]; ok {
-						vitalDevice = deviceID
-					}
-					report.WriteString(fmt.Sprintf(
-						"%-15s | %-15s | %-10s | %-18s\n",
-						patientID,
-						patient.DeviceID,
-						streamingStatus,
-						vitalDevice,
-					))
+			for patientID, patient := range p.activePatients {
+				_, streaming := updatesToProcess[patientID]
+				vitalDevice := "none"
+				if deviceID, ok := recentVitals[patientID]; ok {
+					vitalDevice = deviceID
 				}
+				sequence, _ := p.sequenceStats(patientID)
+				slog.Info("Housekeeping patient status",
+					logging.KeyPatient, patientID,
+					logging.KeyPatch, patient.DeviceID,
+					logging.KeyFacility, patient.FacilityID,
+					"streaming", streaming,
+					"vitalsDevice", vitalDevice,
+					"sequenceGaps", sequence.Gaps,
+					"missingPackets", sequence.MissingPackets,
+					"gapMs", sequence.GapMs,
+					"duplicates", sequence.Duplicates,
+					"counterResets", sequence.Resets,
+					"deliveryQueue", p.sinks.PatientQueueDepth(patientID),
+				)
 			}
+			activeCount := len(p.activePatients)
 			p.activePatientsMu.RUnlock()
 
-			if len(patientsToPrune) > 0 {
-				report.WriteString(fmt.Sprintf("Pruned %d stopped patient(s) from cache.\n", len(patientsToPrune)))
-			}
-
-			clearedDevicesStr := "none"
-			if len(clearedDeviceIDs) > 0 {
-				clearedDevicesStr = fmt.Sprintf("[%s]", strings.Join(clearedDeviceIDs, ", "))
-			}
-			report.WriteString(fmt.Sprintf("Stale Vitals Caches Cleared (%d): %s\n", len(clearedDeviceIDs), clearedDevicesStr))
-			report.WriteString("------------------------------------------------------------------")
-			log.Println(report.String())
+			slog.Info("Housekeeping report",
+				"activePatients", activeCount,
+				"prunedPatients", len(patientsToPrune),
+				"staleVitalsCleared", len(clearedDeviceIDs),
+				"clearedDevices", clearedDeviceIDs,
+				"sequenceGaps", cycleSequence.Gaps,
+				"missingPackets", cycleSequence.MissingPackets,
+				"duplicates", cycleSequence.Duplicates,
+				"counterResets", cycleSequence.Resets,
+			)
 		}
 	}
 }
 
-func (p *BeltProcessor) RouteVitalsMessage(msgValue []byte) {
-	var genericMsg map[string]interface{}
-	if err := json.Unmarshal(msgValue, &genericMsg); err != nil {
-		log.Printf("Error unmarshalling message for routing: %v", err)
-		return
-	}
-
-	if _, ok := genericMsg["bp"]; ok {
-		p.HandleBPSPO2Message(msgValue)
-	} else if _, ok := genericMsg["spo2"]; ok {
-		p.HandleBPSPO2Message(msgValue)
-	} else if _, ok := genericMsg["ECG_CH_A"]; ok {
-		p.HandleECGMessage(msgValue)
-	} else {
-		log.Printf("Unknown message type received on vitals topic, ignoring. Message: %s", string(msgValue))
-	}
-}
-
 func (p *BeltProcessor) loadActivePatients() error {
 	patients, err := p.db.GetActivePatients()
 	if err != nil {
@@ -201,26 +302,36 @@ func (p *BeltProcessor) loadActivePatients() error {
 	defer p.activePatientsMu.Unlock()
 	for _, patient := range patients {
 		p.activePatients[patient.PatientID] = patient
+		p.resetSequence(patient.PatientID, patient.SessionID, patient.Sequence)
 	}
 	return nil
 }
 
-func (p *BeltProcessor) HandleBPSPO2Message(msgValue []byte) {
+func (p *BeltProcessor) HandleBPSPO2Message(ctx context.Context, msgValue []byte) {
 	var msg models.BPSPO2Message
 	if err := json.Unmarshal(msgValue, &msg); err != nil {
-		log.Printf("Error unmarshalling BP/SPO2 message: %v. Raw message: %s", err, string(msgValue))
+		logging.FromContext(ctx).Warn("Error unmarshalling BP/SPO2 message", "error", err, "message", string(msgValue))
+		metrics.DecodeErrors.WithLabelValues("bpspo2").Inc()
+		p.deadLetter(ctx, deadletter.ReasonDecodeError, err, msgValue)
 		return
 	}
+	p.handleBPSPO2(ctx, &msg)
+}
+
+func (p *BeltProcessor) handleBPSPO2(ctx context.Context, msg *models.BPSPO2Message) {
+	logger := logging.FromContext(ctx)
 	if msg.PatientID == "" {
 		return
 	}
+	metrics.BPSPO2Packets.WithLabelValues(metrics.FacilityLabel(msg.FacilityID)).Inc()
+	logger = logger.With(logging.KeyPatient, msg.PatientID, logging.KeyFacility, msg.FacilityID)
 
 	p.activePatientsMu.RLock()
 	_, isActive := p.activePatients[msg.PatientID]
 	p.activePatientsMu.RUnlock()
 
 	if !isActive {
-		log.Printf("[%s] Received vitals for an inactive patient. Caching data.", msg.PatientID)
+		logger.Debug("Received vitals for an inactive patient, caching data")
 	}
 
 	p.vitalsCacheMu.Lock()
@@ -232,13 +343,12 @@ func (p *BeltProcessor) HandleBPSPO2Message(msgValue []byte) {
 	}
 
 	vitals.DeviceID = msg.DeviceID
-	var updateDetails []string
-	updateDetails = append(updateDetails, fmt.Sprintf("SPO2=%d", msg.SPO2.Spo2), fmt.Sprintf("PR=%d", msg.SPO2.PulseRate))
+	updateDetails := []any{"device", msg.DeviceID, "spo2", msg.SPO2.Spo2, "pr", msg.SPO2.PulseRate}
 	vitals.SPO2 = models.VitalSign{IsValid: true, Value: msg.SPO2.Spo2, Timestamp: msg.EpochTime}
 	vitals.PR = models.VitalSign{IsValid: true, Value: msg.SPO2.PulseRate, Timestamp: msg.EpochTime}
 
 	if msg.BP.BPSystolic != 0 {
-		updateDetails = append(updateDetails, fmt.Sprintf("BP=%d/%d", msg.BP.BPSystolic, msg.BP.BPDiastolic))
+		updateDetails = append(updateDetails, "bpSys", msg.BP.BPSystolic, "bpDia", msg.BP.BPDiastolic)
 		vitals.BP = models.BloodPressure{
 			IsValid:   true,
 			Sys:       msg.BP.BPSystolic,
@@ -250,16 +360,23 @@ func (p *BeltProcessor) HandleBPSPO2Message(msgValue []byte) {
 	vitals.LastUpdated = time.Now().Unix()
 	p.vitalsCache[msg.PatientID] = vitals
 
-	log.Printf("[%s] Updated vitals cache: %s", msg.PatientID, strings.Join(updateDetails, ", "))
+	logger.Info("Updated vitals cache", updateDetails...)
 }
 
 // MODIFIED: Removed the noisy log message for inactive ECG packets.
-func (p *BeltProcessor) HandleECGMessage(msgValue []byte) {
+func (p *BeltProcessor) HandleECGMessage(ctx context.Context, msgValue []byte) {
 	var msg models.ECGMessage
 	if err := json.Unmarshal(msgValue, &msg); err != nil {
-		log.Printf("Error unmarshalling ECG message: %v. Raw message: %s", err, string(msgValue))
+		logging.FromContext(ctx).Warn("Error unmarshalling ECG message", "error", err, "message", string(msgValue))
+		metrics.DecodeErrors.WithLabelValues("ecg").Inc()
+		p.deadLetter(ctx, deadletter.ReasonDecodeError, err, msgValue)
 		return
 	}
+	p.handleECG(ctx, &msg)
+}
+
+func (p *BeltProcessor) handleECG(ctx context.Context, msg *models.ECGMessage) {
+	metrics.ECGPackets.WithLabelValues(metrics.FacilityLabel(msg.FacilityID)).Inc()
 	p.activePatientsMu.RLock()
 	patientStream, isActive := p.activePatients[msg.PatientID]
 	p.activePatientsMu.RUnlock()
@@ -269,32 +386,88 @@ func (p *BeltProcessor) HandleECGMessage(msgValue []byte) {
 		return
 	}
 
+	ctx, span := tracing.Tracer().Start(ctx, "batch.accumulate", trace.WithAttributes(
+		attribute.String("patient.id", msg.PatientID),
+		attribute.Int64("packet.no", msg.PacketNo),
+	))
+	defer span.End()
+	link := trace.Link{SpanContext: span.SpanContext()}
+	if p.suppressDuplicate(ctx, msg) {
+		span.SetAttributes(attribute.Bool("packet.duplicate", true))
+		return
+	}
+
+	mu := p.patientLock(msg.PatientID)
+	mu.Lock()
+	defer mu.Unlock()
 	if msg.Discharge {
+		p.drainReorder(msg.PatientID)
+		gap := p.trackSequence(ctx, msg)
 		traceID := fmt.Sprintf("%s-%d", msg.PatientID, msg.PacketNo)
-		p.processAndSendBatch(msg.PatientID, &PatientBatch{Messages: []*models.ECGMessage{&msg}}, traceID)
+		batch := &PatientBatch{}
+		batch.add(msg, link, gap)
+		p.processAndSendBatch(ctx, msg.PatientID, batch, traceID)
 		return
 	}
+	p.reorder(ctx, msg, link)
+}
 
+// patientLock returns the lock that orders a patient's packets from the
+// reorder buffer through to Dispatch. Each patient has their own, so
+// batches for different patients are built and dispatched in parallel.
+func (p *BeltProcessor) patientLock(patientID string) *sync.Mutex {
+	p.patientLocksMu.Lock()
+	defer p.patientLocksMu.Unlock()
+	mu, ok := p.patientLocks[patientID]
+	if !ok {
+		mu = &sync.Mutex{}
+		p.patientLocks[patientID] = mu
+	}
+	return mu
+}
+
+// appendToBatch adds a packet to the patient's pending batch and sends the
+// batch once it is full. The caller holds the patient's lock.
+func (p *BeltProcessor) appendToBatch(ctx context.Context, msg *models.ECGMessage, link trace.Link, gap *models.GapMarker) {
+	batchSize := p.settings.Load().batchSize
 	p.patientBatchesMu.Lock()
-	defer p.patientBatchesMu.Unlock()
 	batch, exists := p.patientBatches[msg.PatientID]
 	if !exists {
-		batch = &PatientBatch{Messages: make([]*models.ECGMessage, 0, chunkSize)}
+		batch = &PatientBatch{Messages: make([]*models.ECGMessage, 0, batchSize)}
 		p.patientBatches[msg.PatientID] = batch
 	}
-	batch.Messages = append(batch.Messages, &msg)
-	if len(batch.Messages) >= chunkSize {
-		lastMessage := batch.Messages[len(batch.Messages)-1]
-		traceID := fmt.Sprintf("%s-%d", msg.PatientID, lastMessage.PacketNo)
-		go p.processAndSendBatch(msg.PatientID, batch, traceID)
+	batch.add(msg, link, gap)
+	size := len(batch.Messages)
+	if size >= batchSize {
 		delete(p.patientBatches, msg.PatientID)
 	}
+	p.patientBatchesMu.Unlock()
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("batch.size", size))
+	if size >= batchSize {
+		lastMessage := batch.Messages[size-1]
+		traceID := fmt.Sprintf("%s-%d", msg.PatientID, lastMessage.PacketNo)
+		p.processAndSendBatch(ctx, msg.PatientID, batch, traceID)
+	}
 }
 
-func (p *BeltProcessor) processAndSendBatch(patientID string, batch *PatientBatch, traceID string) {
+// processAndSendBatch builds the payload and dispatches it to the sinks.
+// Callers hold the patient's lock, so each patient's batches reach the
+// dispatcher, and so the sinks, in the order they were cut.
+func (p *BeltProcessor) processAndSendBatch(ctx context.Context, patientID string, batch *PatientBatch, traceID string) {
 	if len(batch.Messages) == 0 {
 		return
 	}
+	logger := logging.FromContext(ctx).With(logging.KeyPatient, patientID, logging.KeyTraceID, traceID)
+	ctx, span := tracing.Tracer().Start(ctx, "batch.process",
+		trace.WithLinks(batch.Links...),
+		trace.WithAttributes(
+			attribute.String("patient.id", patientID),
+			attribute.String("batch.trace_id", traceID),
+			attribute.Int("batch.size", len(batch.Messages)),
+		))
+	defer span.End()
+	settings := p.settings.Load()
 	var output models.PresensePayload
 	var metadataSet bool
 	for _, payload := range batch.Messages {
@@ -308,7 +481,6 @@ func (p *BeltProcessor) processAndSendBatch(patientID string, batch *PatientBatc
 			output.Gender = payload.Gender
 			output.Age = payload.Age
 			output.BiosensorStatus = "Connected"
-			output.Source = p.dataSource
 			metadataSet = true
 		}
 		sensorItem := models.SensorDataItem{
@@ -324,71 +496,69 @@ func (p *BeltProcessor) processAndSendBatch(patientID string, batch *PatientBatc
 	if !metadataSet {
 		return
 	}
+	route := settings.route(output.FacilityID, output.DeviceType)
+	output.Source = route.DataSource
+	logger = logger.With(logging.KeyPatch, output.PatchID, logging.KeyFacility, output.FacilityID, "route", route.Name)
+	span.SetAttributes(attribute.String("route", route.Name))
+	var vitalsDeviceID string
 	p.vitalsCacheMu.RLock()
 	cachedData, found := p.vitalsCache[patientID]
 	if found {
+		vitalsDeviceID = cachedData.DeviceID
 		output.BP = cachedData.BP
 		output.SPO2 = cachedData.SPO2
 		output.PR = cachedData.PR
+		if t := cachedData.Temperature; t != nil {
+			for i := range output.SensorData {
+				output.SensorData[i].BODYTEMP = t.Body
+				output.SensorData[i].SKINTEMP = t.Skin
+				output.SensorData[i].AMBTEMP_AVG = t.Ambient
+			}
+		}
 	}
 	p.vitalsCacheMu.RUnlock()
+	if route.Payload.MarkGaps {
+		for i, gap := range batch.Gaps {
+			output.SensorData[i].Gap = gap
+		}
+	}
 	lastMessage := batch.Messages[len(batch.Messages)-1]
 	output.ArrythmiaData = []models.ArrythmiaItem{{RhythmType: lastMessage.RhythmType}}
 	output.EWS = map[string]interface{}{"ewsInfo": map[string]interface{}{}}
+	route.Payload.apply(&output)
+	_, marshalSpan := tracing.Tracer().Start(ctx, "payload.marshal")
 	jsonData, err := json.Marshal(output)
 	if err != nil {
-		log.Printf("[%s] Error marshalling processed data for patient %s: %v", traceID, patientID, err)
+		logger.Error("Error marshalling processed data", "error", err)
+		tracing.RecordError(marshalSpan, err)
+		marshalSpan.End()
 		return
 	}
-	if p.writeToFile {
-		p.saveToFile(patientID, output.PatchID, output.Timestamp, jsonData)
-	}
-	if p.endpointURL != "" && p.apiKey != "" {
-		p.sendToApi(patientID, jsonData, traceID)
-	}
+	marshalSpan.SetAttributes(attribute.Int("payload.bytes", len(jsonData)))
+	marshalSpan.End()
+
+	p.sinks.Dispatch(logging.WithContext(ctx, logger), &sink.Envelope{
+		PatientID:      patientID,
+		FacilityID:     output.FacilityID,
+		DeviceType:     output.DeviceType,
+		TraceID:        traceID,
+		Route:          route.Name,
+		Destination:    route.Destination(),
+		Payload:        &output,
+		Body:           jsonData,
+		Messages:       batch.Messages,
+		VitalsDeviceID: vitalsDeviceID,
+	})
 }
 
-func (p *BeltProcessor) saveToFile(patientID, patchID string, timestamp int64, jsonData []byte) {
-	dirPath := filepath.Join("../processed_data", patientID)
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		log.Printf("Error creating directory %s: %v", dirPath, err)
-		return
-	}
-	filename := fmt.Sprintf("%s_%d.json", patchID, timestamp)
-	fullPath := filepath.Join(dirPath, filename)
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, jsonData, "", "  "); err != nil {
-		log.Printf("Could not prettify JSON for file: %v", err)
-		return
-	}
-	if err := os.WriteFile(fullPath, prettyJSON.Bytes(), 0644); err != nil {
-		log.Printf("Error writing to file %s: %v", fullPath, err)
-	}
-}
-
-func (p *BeltProcessor) sendToApi(patientID string, jsonData []byte, traceID string) {
-	req, err := http.NewRequest("POST", p.endpointURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("[%s] Error creating API request: %v", traceID, err)
-		return
-	}
-	authHeader := "Bearer " + p.apiKey
-	req.Header.Set("Authorization", authHeader)
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		log.Printf("[%s] Error sending data to Presense API: %v", traceID, err)
-		return
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		log.Printf("[%s] Presense API returned non-success status: %s", traceID, resp.Status)
-	} else {
-		log.Printf("[%s] Successfully sent batch to Presense API. Status: %s", traceID, resp.Status)
-		p.lastStreamedTimesMu.Lock()
-		p.lastStreamedTimes[patientID] = time.Now().Unix()
-		p.lastStreamedTimesMu.Unlock()
-	}
+// deadLetter records a consumed message the processor could not use.
+func (p *BeltProcessor) deadLetter(ctx context.Context, reason string, err error, msgValue []byte) {
+	p.deadLetters.Record(ctx, &models.DeadLetter{
+		Origin:  "kafka",
+		Reason:  reason,
+		Error:   err.Error(),
+		Payload: msgValue,
+	})
 }
 
 func (p *BeltProcessor) HandleSvcStartMessage(payload []byte) {
@@ -399,19 +569,8 @@ func (p *BeltProcessor) HandleSvcStartMessage(payload []byte) {
 	if msg.DeviceType != "BIOSENSOR_NEXUS" {
 		return
 	}
-	p.activePatientsMu.Lock()
-	defer p.activePatientsMu.Unlock()
-	if err := p.db.StartMonitoring(msg.PatientID, msg.FacilityID, msg.PatchID); err != nil {
-		log.Printf("DB Error starting monitoring for patient %s: %v", msg.PatientID, err)
-	} else {
-		p.activePatients[msg.PatientID] = models.PatientStream{
-			PatientID:  msg.PatientID,
-			DeviceID:   msg.PatchID,
-			FacilityID: msg.FacilityID,
-			StartTime:  time.Now().Unix(),
-			Status:     "running",
-		}
-		log.Printf("✅ Started monitoring patient: %s", msg.PatientID)
+	if err := p.StartPatient(msg.PatientID, msg.FacilityID, msg.PatchID); err != nil {
+		slog.Error("Failed to start monitoring", logging.KeyPatient, msg.PatientID, "error", err)
 	}
 }
 
@@ -421,31 +580,12 @@ func (p *BeltProcessor) HandleSvcActionMessage(payload []byte) {
 		return
 	}
 	if msg.Action == "stop" {
-		p.activePatientsMu.Lock()
-		defer p.activePatientsMu.Unlock()
-		var patientIDToStop string
-		for id, stream := range p.activePatients {
-			if stream.DeviceID == msg.PatchID {
-				patientIDToStop = id
-				break
-			}
-		}
+		patientIDToStop := p.patientForPatch(msg.PatchID)
 		if patientIDToStop == "" {
 			return
 		}
-		if err := p.db.StopMonitoring(patientIDToStop); err != nil {
-			log.Printf("DB Error stopping monitoring for patient %s: %v", patientIDToStop, err)
-		} else {
-			if patient, ok := p.activePatients[patientIDToStop]; ok {
-				patient.Status = "stopped"
-				now := time.Now().Unix()
-				patient.EndTime = &now
-				p.activePatients[patientIDToStop] = patient
-			}
-			p.patientBatchesMu.Lock()
-			delete(p.patientBatches, patientIDToStop)
-			p.patientBatchesMu.Unlock()
-			log.Printf("🛑 Stopped monitoring patient: %s", patientIDToStop)
+		if err := p.StopPatient(patientIDToStop); err != nil {
+			slog.Error("DB error stopping monitoring", logging.KeyPatient, patientIDToStop, "error", err)
 		}
 	}
 }
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"belt-presense/internal/deadletter"
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+	"belt-presense/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// TypeHeader is the Kafka header producers set to name a message's type.
+const TypeHeader = "message-type"
+
+// Message types understood on the vitals topic. Declared types are matched
+// without regard to case.
+const (
+	TypeECG         = "ecg"
+	TypeBPSPO2      = "bpspo2"
+	TypeTemperature = "temperature"
+)
+
+type messageTypeKey struct{}
+
+// WithMessageType records the type a producer declared out of band (for
+// example in TypeHeader) for RouteVitalsMessage to use.
+func WithMessageType(ctx context.Context, messageType string) context.Context {
+	messageType = normalizeType(messageType)
+	if messageType == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, messageTypeKey{}, messageType)
+}
+
+func normalizeType(t string) string {
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// vitalsMessage decodes any vitals packet in one pass. The ECG and BP/SPO2
+// formats share their identifying fields, so the ECG struct carries those
+// and the BP/SPO2-only fields sit alongside.
+type vitalsMessage struct {
+	Type string `json:"type"`
+	models.ECGMessage
+	EpochTime int64               `json:"epochTime"`
+	BP        *models.BPReading   `json:"bp"`
+	SPO2      *models.SPO2Reading `json:"spo2"`
+	// Temperature readings; pointers so their presence can be detected.
+	BodyTemp    *int `json:"BODYTEMP"`
+	SkinTemp    *int `json:"SKINTEMP"`
+	AmbientTemp *int `json:"AMBTEMP_AVG"`
+}
+
+func (m *vitalsMessage) bpspo2() *models.BPSPO2Message {
+	msg := &models.BPSPO2Message{
+		PatientID:   m.PatientID,
+		FacilityID:  m.FacilityID,
+		AdmissionID: m.AdmissionID,
+		DeviceID:    m.DeviceID,
+		EpochTime:   m.EpochTime,
+	}
+	if m.BP != nil {
+		msg.BP = *m.BP
+	}
+	if m.SPO2 != nil {
+		msg.SPO2 = *m.SPO2
+	}
+	return msg
+}
+
+func (m *vitalsMessage) temperature() *models.TemperatureMessage {
+	msg := &models.TemperatureMessage{
+		PatientID:  m.PatientID,
+		FacilityID: m.FacilityID,
+		DeviceID:   m.DeviceID,
+		EpochTime:  m.EpochTime,
+	}
+	if m.BodyTemp != nil {
+		msg.BodyTemp = *m.BodyTemp
+	}
+	if m.SkinTemp != nil {
+		msg.SkinTemp = *m.SkinTemp
+	}
+	if m.AmbientTemp != nil {
+		msg.AmbientTemp = *m.AmbientTemp
+	}
+	return msg
+}
+
+type vitalsHandler func(ctx context.Context, msg *vitalsMessage)
+
+func (p *BeltProcessor) vitalsHandlers() map[string]vitalsHandler {
+	return map[string]vitalsHandler{
+		TypeECG:    func(ctx context.Context, m *vitalsMessage) { p.handleECG(ctx, &m.ECGMessage) },
+		TypeBPSPO2: func(ctx context.Context, m *vitalsMessage) { p.handleBPSPO2(ctx, m.bpspo2()) },
+		TypeTemperature: func(ctx context.Context, m *vitalsMessage) {
+			p.handleTemperature(ctx, m.temperature())
+		},
+	}
+}
+
+// RouteVitalsMessage decodes a message once and hands it to the handler for
+// its type. The type comes from, in order: the producer's declaration (see
+// WithMessageType), the message's "type" field, its "deviceType" mapped
+// through the configured device types, and finally the legacy guess from
+// which fields are present.
+func (p *BeltProcessor) RouteVitalsMessage(ctx context.Context, msgValue []byte) {
+	ctx, span := tracing.Tracer().Start(ctx, "vitals.route")
+	defer span.End()
+
+	var msg vitalsMessage
+	if err := json.Unmarshal(msgValue, &msg); err != nil {
+		logging.FromContext(ctx).Warn("Error unmarshalling message for routing", "error", err)
+		tracing.RecordError(span, err)
+		metrics.DecodeErrors.WithLabelValues("route").Inc()
+		p.deadLetter(ctx, deadletter.ReasonDecodeError, err, msgValue)
+		return
+	}
+
+	messageType, via := p.messageType(ctx, &msg)
+	span.SetAttributes(attribute.String("vitals.type", messageType), attribute.String("vitals.type_source", via))
+	if handle, ok := p.handlers[messageType]; ok {
+		metrics.RoutedMessages.WithLabelValues(messageType, via).Inc()
+		handle(ctx, &msg)
+		return
+	}
+
+	logging.FromContext(ctx).Warn("Unknown message type received on vitals topic, ignoring", "type", messageType, "typeSource", via, "message", string(msgValue))
+	metrics.UnknownMessages.Inc()
+	err := fmt.Errorf("unknown message type %q", messageType)
+	if messageType == "" {
+		err = errors.New("no type declared and no bp, spo2, ECG_CH_A or temperature field")
+	}
+	p.deadLetter(ctx, deadletter.ReasonUnknownType, err, msgValue)
+}
+
+func (p *BeltProcessor) messageType(ctx context.Context, msg *vitalsMessage) (messageType, via string) {
+	if t, ok := ctx.Value(messageTypeKey{}).(string); ok {
+		return t, "header"
+	}
+	if t := normalizeType(msg.Type); t != "" {
+		return t, "field"
+	}
+	if t, ok := p.settings.Load().deviceTypes[normalizeType(msg.DeviceType)]; ok {
+		return t, "device_type"
+	}
+	switch {
+	case msg.BP != nil, msg.SPO2 != nil:
+		return TypeBPSPO2, "sniffed"
+	case msg.ECG_CH_A != nil:
+		return TypeECG, "sniffed"
+	case msg.BodyTemp != nil, msg.SkinTemp != nil, msg.AmbientTemp != nil:
+		return TypeTemperature, "sniffed"
+	}
+	return "", "none"
+}
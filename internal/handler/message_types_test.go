@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestMessageType(t *testing.T) {
+	p := &BeltProcessor{}
+	p.settings.Store(newSettings(Options{DeviceTypes: map[string]string{"BIOSENSOR_NEXUS": "ECG", "bp-cuff": "bpspo2"}}))
+
+	tests := []struct {
+		name     string
+		header   string
+		body     string
+		wantType string
+		wantVia  string
+	}{
+		{"header", "ecg", `{}`, TypeECG, "header"},
+		{"header upper case", "ECG", `{}`, TypeECG, "header"},
+		{"header mixed case", " BpSpo2 ", `{}`, TypeBPSPO2, "header"},
+		{"header wins over field", "temperature", `{"type":"ecg"}`, TypeTemperature, "header"},
+		{"field", "", `{"type":"bpspo2"}`, TypeBPSPO2, "field"},
+		{"field mixed case", "", `{"type":"Temperature"}`, TypeTemperature, "field"},
+		{"device type", "", `{"deviceType":"BIOSENSOR_NEXUS"}`, TypeECG, "device_type"},
+		{"device type other case", "", `{"deviceType":"biosensor_nexus"}`, TypeECG, "device_type"},
+		{"device type lower key", "", `{"deviceType":"BP-CUFF"}`, TypeBPSPO2, "device_type"},
+		{"sniffed bp", "", `{"bp":{"bpSystolic":120}}`, TypeBPSPO2, "sniffed"},
+		{"sniffed ecg", "", `{"ECG_CH_A":[1,2]}`, TypeECG, "sniffed"},
+		{"sniffed temperature", "", `{"BODYTEMP":370}`, TypeTemperature, "sniffed"},
+		{"unknown field kept", "", `{"type":"Weight"}`, "weight", "field"},
+		{"nothing", "", `{"deviceType":"other"}`, "", "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg vitalsMessage
+			if err := json.Unmarshal([]byte(tt.body), &msg); err != nil {
+				t.Fatal(err)
+			}
+			ctx := WithMessageType(context.Background(), tt.header)
+			gotType, gotVia := p.messageType(ctx, &msg)
+			if gotType != tt.wantType || gotVia != tt.wantVia {
+				t.Errorf("messageType() = %q via %s, want %q via %s", gotType, gotVia, tt.wantType, tt.wantVia)
+			}
+			if _, ok := p.vitalsHandlers()[gotType]; ok != (tt.wantType == TypeECG || tt.wantType == TypeBPSPO2 || tt.wantType == TypeTemperature) {
+				t.Errorf("no handler registered for %q", gotType)
+			}
+		})
+	}
+}
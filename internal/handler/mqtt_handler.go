@@ -1,15 +1,17 @@
 package handler
 
 import (
-	"log"
+	"log/slog"
 
-	"github.com/eclipse/paho.mqtt.golang"
 	"belt-presense/internal/config"
+	"belt-presense/internal/logging"
+
+	"github.com/eclipse/paho.mqtt.golang"
 )
 
 func NewMessageHandler(processor *BeltProcessor) mqtt.MessageHandler {
 	return func(client mqtt.Client, msg mqtt.Message) {
-		log.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
+		slog.Debug("Received MQTT message", logging.KeyTopic, msg.Topic(), "payload", string(msg.Payload()))
 
 		switch msg.Topic() {
 		case "arrhythmia/svc_start":
@@ -17,26 +19,26 @@ func NewMessageHandler(processor *BeltProcessor) mqtt.MessageHandler {
 		case "arrhythmia/svc_action":
 			processor.HandleSvcActionMessage(msg.Payload())
 		default:
-			log.Printf("Unknown topic: %s", msg.Topic())
+			slog.Warn("Unknown MQTT topic", logging.KeyTopic, msg.Topic())
 		}
 	}
 }
 
 var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
-	log.Println("Connected to MQTT broker")
+	slog.Info("Connected to MQTT broker")
 	subscribeToTopics(client)
 }
 
 var connectLostHandler mqtt.ConnectionLostHandler = func(client mqtt.Client, err error) {
-	log.Printf("Connection lost: %v", err)
+	slog.Warn("MQTT connection lost", "error", err)
 }
 
 func InitializeMQTT(cfg *config.Config, processor *BeltProcessor) (mqtt.Client, error) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(cfg.MQTTBroker)
-	opts.SetClientID(cfg.MQTTClientID)
-	opts.SetUsername(cfg.MQTTUsername)
-	opts.SetPassword(cfg.MQTTPassword)
+	opts.AddBroker(cfg.MQTT.BrokerURL)
+	opts.SetClientID(cfg.MQTT.ClientID)
+	opts.SetUsername(cfg.MQTT.Username)
+	opts.SetPassword(cfg.MQTT.Password)
 	opts.SetDefaultPublishHandler(NewMessageHandler(processor))
 	opts.OnConnect = connectHandler
 	opts.OnConnectionLost = connectLostHandler
@@ -54,6 +56,6 @@ func subscribeToTopics(client mqtt.Client) {
 	for _, topic := range topics {
 		token := client.Subscribe(topic, 1, nil)
 		token.Wait()
-		log.Printf("Subscribed to topic: %s", topic)
+		slog.Info("Subscribed to MQTT topic", logging.KeyTopic, topic)
 	}
 }
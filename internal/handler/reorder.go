@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Late-packet policies, for packets that arrive after the buffer has
+// already released later ones.
+const (
+	LateDrop       = "drop"
+	LateNext       = "next"
+	LateStandalone = "standalone"
+)
+
+// ReorderOptions configures the per-patient reorder buffer. A zero MaxWait
+// passes packets straight through.
+type ReorderOptions struct {
+	MaxWait    time.Duration
+	MaxPackets int
+	LatePolicy string
+}
+
+type heldPacket struct {
+	ctx     context.Context
+	msg     *models.ECGMessage
+	link    trace.Link
+	arrived time.Time
+}
+
+// reorderBuffer holds a patient's packets that arrived ahead of an earlier,
+// missing one, and releases them in packet order.
+type reorderBuffer struct {
+	started  bool
+	next     int64
+	lastTime int64 // CurrentTimestamp of the last released packet
+	held     map[int64]heldPacket
+	timer    *time.Timer
+}
+
+func (b *reorderBuffer) oldest() time.Time {
+	var oldest time.Time
+	for _, h := range b.held {
+		if oldest.IsZero() || h.arrived.Before(oldest) {
+			oldest = h.arrived
+		}
+	}
+	return oldest
+}
+
+func (b *reorderBuffer) lowest() int64 {
+	first := true
+	var lowest int64
+	for n := range b.held {
+		if first || n < lowest {
+			lowest, first = n, false
+		}
+	}
+	return lowest
+}
+
+// reorder passes msg through the patient's reorder buffer and appends
+// every packet it releases to the patient's batch. The caller holds the
+// patient's lock.
+func (p *BeltProcessor) reorder(ctx context.Context, msg *models.ECGMessage, link trace.Link) {
+	opts := p.settings.Load().reorder
+	if opts.MaxWait <= 0 {
+		p.release(ctx, msg, link)
+		return
+	}
+
+	p.reorderMu.Lock()
+	released, late := p.hold(ctx, msg, link, opts)
+	p.reorderMu.Unlock()
+	if late {
+		p.late(ctx, opts.LatePolicy, msg, link)
+		return
+	}
+	p.releaseAll(released)
+}
+
+// hold adds msg to the patient's reorder buffer and returns the packets
+// that can now be released, or reports msg late. The caller holds
+// reorderMu.
+func (p *BeltProcessor) hold(ctx context.Context, msg *models.ECGMessage, link trace.Link, opts ReorderOptions) (released []heldPacket, late bool) {
+	b, ok := p.reorderBuffers[msg.PatientID]
+	if !ok {
+		b = &reorderBuffer{held: make(map[int64]heldPacket)}
+		p.reorderBuffers[msg.PatientID] = b
+	}
+	if !b.started {
+		b.started = true
+		b.next = msg.PacketNo
+	}
+
+	if msg.PacketNo < b.next {
+		if msg.CurrentTimestamp <= b.lastTime {
+			return nil, true
+		}
+		// The belt restarted its counter; what is held can no longer be
+		// completed.
+		released = b.releaseHeld(released)
+		b.next = msg.PacketNo
+	}
+
+	if msg.PacketNo > b.next {
+		metrics.ReorderHeld.WithLabelValues(metrics.FacilityLabel(msg.FacilityID)).Inc()
+	}
+	b.held[msg.PacketNo] = heldPacket{ctx: ctx, msg: msg, link: link, arrived: time.Now()}
+	released = b.releaseReady(released)
+	for len(b.held) > opts.MaxPackets {
+		b.next = b.lowest()
+		released = b.releaseReady(released)
+	}
+	p.armReorderTimer(msg.PatientID, b, opts.MaxWait)
+	return released, false
+}
+
+// releaseReady appends to released the held packets from the next expected
+// one onwards, for as long as they are contiguous, and removes them.
+func (b *reorderBuffer) releaseReady(released []heldPacket) []heldPacket {
+	for {
+		h, ok := b.held[b.next]
+		if !ok {
+			return released
+		}
+		delete(b.held, b.next)
+		b.next++
+		b.lastTime = max(b.lastTime, h.msg.CurrentTimestamp)
+		released = append(released, h)
+	}
+}
+
+// releaseHeld appends everything held to released, in packet order,
+// skipping gaps.
+func (b *reorderBuffer) releaseHeld(released []heldPacket) []heldPacket {
+	for len(b.held) > 0 {
+		b.next = b.lowest()
+		released = b.releaseReady(released)
+	}
+	return released
+}
+
+// releaseAll hands released packets on in order. The caller holds the
+// patient's lock but not reorderMu.
+func (p *BeltProcessor) releaseAll(released []heldPacket) {
+	for _, h := range released {
+		p.release(h.ctx, h.msg, h.link)
+	}
+}
+
+func (p *BeltProcessor) armReorderTimer(patientID string, b *reorderBuffer, maxWait time.Duration) {
+	if len(b.held) == 0 {
+		if b.timer != nil {
+			b.timer.Stop()
+			b.timer = nil
+		}
+		return
+	}
+	if b.timer != nil {
+		return
+	}
+	wait := max(time.Until(b.oldest().Add(maxWait)), 0)
+	b.timer = time.AfterFunc(wait, func() { p.expireReorder(patientID, b) })
+}
+
+// expireReorder gives up on missing packets that held ones have waited
+// MaxWait for.
+func (p *BeltProcessor) expireReorder(patientID string, b *reorderBuffer) {
+	maxWait := p.settings.Load().reorder.MaxWait
+	mu := p.patientLock(patientID)
+	mu.Lock()
+	defer mu.Unlock()
+	p.reorderMu.Lock()
+	if p.reorderBuffers[patientID] != b {
+		p.reorderMu.Unlock()
+		return
+	}
+	b.timer = nil
+	var released []heldPacket
+	for len(b.held) > 0 && time.Since(b.oldest()) >= maxWait {
+		b.next = b.lowest()
+		released = b.releaseReady(released)
+	}
+	p.armReorderTimer(patientID, b, maxWait)
+	p.reorderMu.Unlock()
+	p.releaseAll(released)
+}
+
+// drainReorder releases everything the patient's buffer holds. The caller
+// holds the patient's lock.
+func (p *BeltProcessor) drainReorder(patientID string) {
+	p.reorderMu.Lock()
+	var released []heldPacket
+	if b, ok := p.reorderBuffers[patientID]; ok {
+		released = b.releaseHeld(nil)
+		p.armReorderTimer(patientID, b, 0)
+	}
+	p.reorderMu.Unlock()
+	p.releaseAll(released)
+}
+
+// dropReorder discards the patient's buffer, and any packets it holds.
+func (p *BeltProcessor) dropReorder(patientID string) {
+	p.reorderMu.Lock()
+	defer p.reorderMu.Unlock()
+	if b, ok := p.reorderBuffers[patientID]; ok {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		delete(p.reorderBuffers, patientID)
+	}
+}
+
+// release hands a packet, in sequence order, to gap accounting and the
+// patient's batch.
+func (p *BeltProcessor) release(ctx context.Context, msg *models.ECGMessage, link trace.Link) {
+	gap := p.trackSequence(ctx, msg)
+	p.appendToBatch(ctx, msg, link, gap)
+}
+
+func (p *BeltProcessor) late(ctx context.Context, policy string, msg *models.ECGMessage, link trace.Link) {
+	metrics.LatePackets.WithLabelValues(metrics.FacilityLabel(msg.FacilityID), policy).Inc()
+	logging.FromContext(ctx).Debug("Late packet", logging.KeyPatient, msg.PatientID, "packetNo", msg.PacketNo, "policy", policy)
+	switch policy {
+	case LateDrop:
+	case LateStandalone:
+		traceID := fmt.Sprintf("%s-%d", msg.PatientID, msg.PacketNo)
+		batch := &PatientBatch{}
+		batch.add(msg, link, nil)
+		p.processAndSendBatch(ctx, msg.PatientID, batch, traceID)
+	default:
+		p.appendToBatch(ctx, msg, link, nil)
+	}
+}
+
+func (p *BeltProcessor) heldPackets() int {
+	p.reorderMu.Lock()
+	defer p.reorderMu.Unlock()
+	n := 0
+	for _, b := range p.reorderBuffers {
+		n += len(b.held)
+	}
+	return n
+}
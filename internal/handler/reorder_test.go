@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"belt-presense/internal/models"
+	"belt-presense/internal/sink"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type recordingSink struct{ sent chan *sink.Envelope }
+
+func (s recordingSink) Name() string { return "recording" }
+
+func (s recordingSink) Send(_ context.Context, env *sink.Envelope) error {
+	s.sent <- env
+	return nil
+}
+
+// newReorderProcessor returns a processor that reorders with opts and
+// batches large enough that only standalone packets are dispatched.
+func newReorderProcessor(t *testing.T, opts ReorderOptions) (*BeltProcessor, <-chan *sink.Envelope) {
+	rec := recordingSink{sent: make(chan *sink.Envelope, 10)}
+	d := sink.NewDispatcher()
+	d.Add(rec, sink.Options{QueueSize: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	p := &BeltProcessor{
+		sinks:          d,
+		patientBatches: make(map[string]*PatientBatch),
+		vitalsCache:    make(map[string]*CachedVitals),
+		sequences:      make(map[string]*sequenceTracker),
+		reorderBuffers: make(map[string]*reorderBuffer),
+		patientLocks:   make(map[string]*sync.Mutex),
+	}
+	p.settings.Store(newSettings(Options{BatchSize: 100, Reorder: opts}))
+	t.Cleanup(func() { p.dropReorder("P-1") })
+	return p, rec.sent
+}
+
+func packets(msgs []*models.ECGMessage) []int64 {
+	var out []int64
+	for _, m := range msgs {
+		out = append(out, m.PacketNo)
+	}
+	return out
+}
+
+func TestReorderLatePackets(t *testing.T) {
+	// Packet 2 is missing when 3, 4 and 5 arrive; with room for only two
+	// held packets the buffer gives up on it and releases 3 to 5.
+	before := []int64{1, 3, 4, 5}
+	tests := []struct {
+		name   string
+		policy string
+		// then is the packet that arrives after 5, and its timestamp.
+		then, thenTime int64
+		wantBatch      []int64
+		wantStandalone []int64
+	}{
+		{"drop", LateDrop, 2, 2000, []int64{1, 3, 4, 5}, nil},
+		{"next", LateNext, 2, 2000, []int64{1, 3, 4, 5, 2}, nil},
+		{"standalone", LateStandalone, 2, 2000, []int64{1, 3, 4, 5}, []int64{2}},
+		{"same timestamp is late", LateDrop, 2, 5000, []int64{1, 3, 4, 5}, nil},
+		{"counter reset is not late", LateDrop, 1, 6000, []int64{1, 3, 4, 5, 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, sent := newReorderProcessor(t, ReorderOptions{MaxWait: time.Minute, MaxPackets: 2, LatePolicy: tt.policy})
+			send := func(packetNo, ts int64) {
+				msg := &models.ECGMessage{PatientID: "P-1", FacilityID: "F-1", PacketNo: packetNo, CurrentTimestamp: ts}
+				p.reorder(context.Background(), msg, trace.Link{})
+			}
+			for _, n := range before {
+				send(n, n*1000)
+			}
+			send(tt.then, tt.thenTime)
+
+			if got := packets(p.patientBatches["P-1"].Messages); !reflect.DeepEqual(got, tt.wantBatch) {
+				t.Errorf("batch = %v, want %v", got, tt.wantBatch)
+			}
+			if n := p.heldPackets(); n != 0 {
+				t.Errorf("%d packets still held", n)
+			}
+			select {
+			case env := <-sent:
+				if got := packets(env.Messages); !reflect.DeepEqual(got, tt.wantStandalone) {
+					t.Errorf("sent %v on its own, want %v", got, tt.wantStandalone)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantStandalone != nil {
+					t.Errorf("nothing sent, want %v on its own", tt.wantStandalone)
+				}
+			}
+		})
+	}
+}
+
+func TestReorderHoldsUntilGapFills(t *testing.T) {
+	p, _ := newReorderProcessor(t, ReorderOptions{MaxWait: time.Minute, MaxPackets: 10, LatePolicy: LateDrop})
+	for _, n := range []int64{1, 3, 4, 2, 5} {
+		msg := &models.ECGMessage{PatientID: "P-1", FacilityID: "F-1", PacketNo: n, CurrentTimestamp: n * 1000}
+		p.reorder(context.Background(), msg, trace.Link{})
+	}
+	batch := p.patientBatches["P-1"]
+	if got, want := packets(batch.Messages), []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batch = %v, want %v", got, want)
+	}
+	if len(batch.Gaps) != 0 {
+		t.Errorf("gaps marked at %v in a complete sequence", batch.Gaps)
+	}
+}
+
+func TestReorderExpiresMissingPacket(t *testing.T) {
+	p, _ := newReorderProcessor(t, ReorderOptions{MaxWait: 20 * time.Millisecond, MaxPackets: 10, LatePolicy: LateDrop})
+	for _, n := range []int64{1, 3} {
+		msg := &models.ECGMessage{PatientID: "P-1", FacilityID: "F-1", PacketNo: n, CurrentTimestamp: n * 1000}
+		p.reorder(context.Background(), msg, trace.Link{})
+	}
+	if n := p.heldPackets(); n != 1 {
+		t.Fatalf("%d packets held, want 1", n)
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.heldPackets() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.patientBatchesMu.Lock()
+	defer p.patientBatchesMu.Unlock()
+	batch := p.patientBatches["P-1"]
+	if got, want := packets(batch.Messages), []int64{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("batch = %v after MaxWait, want %v", got, want)
+	}
+	if gap := batch.Gaps[1]; gap == nil || gap.Missing != 1 {
+		t.Errorf("packet 3 gap = %+v, want one missing packet", gap)
+	}
+}
+
+func TestPatientsBatchIndependently(t *testing.T) {
+	p, sent := newReorderProcessor(t, ReorderOptions{MaxWait: time.Minute, MaxPackets: 10, LatePolicy: LateDrop})
+	p.activePatients = map[string]models.PatientStream{
+		"P-1": {PatientID: "P-1", Status: "running"},
+		"P-2": {PatientID: "P-2", Status: "running"},
+	}
+
+	// P-1 is in the middle of cutting a batch.
+	mu := p.patientLock("P-1")
+	mu.Lock()
+	defer mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.handleECG(context.Background(), &models.ECGMessage{PatientID: "P-2", FacilityID: "F-1", PacketNo: 1, CurrentTimestamp: 1000, Discharge: true})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("P-2's packet waited for P-1")
+	}
+	if env := <-sent; env.PatientID != "P-2" {
+		t.Errorf("sent a batch for %s, want P-2", env.PatientID)
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"belt-presense/internal/models"
+	"belt-presense/internal/sink"
+)
+
+// Route sends batches from matching facilities and device types to one
+// destination.
+type Route struct {
+	Name string
+	// Facilities and DeviceTypes restrict the route, ignoring case; an empty
+	// list matches anything.
+	Facilities  []string
+	DeviceTypes []string
+	EndpointURL string
+	// Auth adds the destination's credentials to each request, and Client
+	// sends it; a nil Client uses the sink's default.
+	Auth       sink.Authenticator
+	Client     *http.Client
+	DataSource string
+	Payload    PayloadOptions
+}
+
+type PayloadOptions struct {
+	OmitPatientDetails bool
+	OmitVitals         bool
+	OmitArrhythmia     bool
+	MarkGaps           bool
+}
+
+// Destination is where the Presense sink sends the route's batches.
+func (r *Route) Destination() sink.Destination {
+	return sink.Destination{URL: r.EndpointURL, Auth: r.Auth, Client: r.Client}
+}
+
+func (r *Route) matches(facilityID, deviceType string) bool {
+	return matchesAny(r.Facilities, facilityID) && matchesAny(r.DeviceTypes, deviceType)
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the first configured route matching the batch, or the
+// default route.
+func (s *settings) route(facilityID, deviceType string) *Route {
+	for i := range s.routes {
+		if s.routes[i].matches(facilityID, deviceType) {
+			return &s.routes[i]
+		}
+	}
+	return &s.defaultRoute
+}
+
+// apply drops what the destination is not meant to receive.
+func (o PayloadOptions) apply(payload *models.PresensePayload) {
+	if o.OmitPatientDetails {
+		payload.PatientName = ""
+		payload.Gender = ""
+		payload.Age = 0
+	}
+	if o.OmitVitals {
+		payload.BP = models.BloodPressure{}
+		payload.SPO2 = models.VitalSign{}
+		payload.PR = models.VitalSign{}
+		for i := range payload.SensorData {
+			payload.SensorData[i].BODYTEMP = 0
+			payload.SensorData[i].SKINTEMP = 0
+			payload.SensorData[i].AMBTEMP_AVG = 0
+		}
+	}
+	if o.OmitArrhythmia {
+		payload.ArrythmiaData = nil
+	}
+}
+
+// ResolveRoute returns the route a processor configured with opts would use
+// for a batch.
+func ResolveRoute(opts Options, facilityID, deviceType string) Route {
+	return *newSettings(opts).route(facilityID, deviceType)
+}
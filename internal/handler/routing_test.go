@@ -0,0 +1,31 @@
+package handler
+
+import "testing"
+
+func TestSettingsRoute(t *testing.T) {
+	s := newSettings(Options{
+		DefaultRoute: Route{Name: "default"},
+		Routes: []Route{
+			{Name: "pilot nexus", Facilities: []string{"FAC-NEW-01"}, DeviceTypes: []string{"BIOSENSOR_NEXUS"}},
+			{Name: "pilot", Facilities: []string{"FAC-NEW-01", "FAC-NEW-02"}},
+			{Name: "nexus", DeviceTypes: []string{"BIOSENSOR_NEXUS"}},
+		},
+	})
+	tests := []struct {
+		facility, deviceType string
+		want                 string
+	}{
+		{"FAC-NEW-01", "BIOSENSOR_NEXUS", "pilot nexus"},
+		{"fac-new-01", "biosensor_nexus", "pilot nexus"},
+		{"FAC-NEW-01", "BELT", "pilot"},
+		{"FAC-NEW-02", "BIOSENSOR_NEXUS", "pilot"}, // the first match wins
+		{"FAC-OLD", "Biosensor_Nexus", "nexus"},
+		{"FAC-OLD", "BELT", "default"},
+		{"", "", "default"},
+	}
+	for _, tt := range tests {
+		if got := s.route(tt.facility, tt.deviceType).Name; got != tt.want {
+			t.Errorf("route(%q, %q) = %s, want %s", tt.facility, tt.deviceType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+)
+
+// Sequence anomaly kinds, as reported in metrics.
+const (
+	seqInOrder   = "in_order"
+	seqGap       = "gap"
+	seqDuplicate = "duplicate"
+	seqReset     = "reset"
+)
+
+// sequenceTracker follows the packet numbers of one patient's ECG stream.
+type sequenceTracker struct {
+	// sessionID is the database session the counts belong to, or 0 for a
+	// patient without one.
+	sessionID int64
+	started   bool
+	expected  int64
+	// lastTime is the CurrentTimestamp (ms) of the newest packet seen.
+	lastTime int64
+	// session counts anomalies since the session started; pending holds
+	// those not yet written to the database.
+	session models.SequenceStats
+	pending models.SequenceStats
+}
+
+// observe classifies a packet and advances the expected packet number. A
+// lower packet number with a newer timestamp means the belt restarted its
+// counter; with an older or equal timestamp it is a packet already seen.
+func (t *sequenceTracker) observe(packetNo, timestamp int64) (string, *models.GapMarker) {
+	if !t.started {
+		t.started = true
+		t.expected = packetNo + 1
+		t.lastTime = timestamp
+		return seqInOrder, nil
+	}
+
+	var delta models.SequenceStats
+	var gap *models.GapMarker
+	kind := seqInOrder
+	switch {
+	case packetNo == t.expected:
+	case packetNo > t.expected:
+		kind = seqGap
+		gap = &models.GapMarker{Missing: packetNo - t.expected, DurationMs: max(timestamp-t.lastTime, 0)}
+		delta = models.SequenceStats{Gaps: 1, MissingPackets: gap.Missing, GapMs: gap.DurationMs}
+	case timestamp > t.lastTime:
+		kind = seqReset
+		gap = &models.GapMarker{DurationMs: timestamp - t.lastTime, Reset: true}
+		delta = models.SequenceStats{Resets: 1}
+	default:
+		t.session.Duplicates++
+		t.pending.Duplicates++
+		return seqDuplicate, nil
+	}
+	t.session.Add(delta)
+	t.pending.Add(delta)
+	t.expected = packetNo + 1
+	t.lastTime = max(t.lastTime, timestamp)
+	return kind, gap
+}
+
+// trackSequence records msg in its patient's packet sequence and returns the
+// marker for any packets missing before it.
+func (p *BeltProcessor) trackSequence(ctx context.Context, msg *models.ECGMessage) *models.GapMarker {
+	p.sequencesMu.Lock()
+	tracker, ok := p.sequences[msg.PatientID]
+	if !ok {
+		tracker = &sequenceTracker{}
+		p.sequences[msg.PatientID] = tracker
+	}
+	expected := tracker.expected
+	kind, gap := tracker.observe(msg.PacketNo, msg.CurrentTimestamp)
+	p.sequencesMu.Unlock()
+
+	if kind == seqInOrder {
+		return nil
+	}
+	facility := metrics.FacilityLabel(msg.FacilityID)
+	metrics.SequenceEvents.WithLabelValues(facility, kind).Inc()
+	logger := logging.FromContext(ctx).With(logging.KeyPatient, msg.PatientID, "packetNo", msg.PacketNo, "expectedPacketNo", expected)
+	switch kind {
+	case seqGap:
+		metrics.MissingPackets.WithLabelValues(facility).Add(float64(gap.Missing))
+		logger.Warn("Packet sequence gap", "missing", gap.Missing, "gapMs", gap.DurationMs)
+	case seqReset:
+		logger.Info("Packet counter reset", "gapMs", gap.DurationMs)
+	case seqDuplicate:
+		logger.Debug("Duplicate or late packet")
+	}
+	return gap
+}
+
+// resetSequence starts a fresh sequence for a new session, seeded with any
+// counts already recorded for it.
+func (p *BeltProcessor) resetSequence(patientID string, sessionID int64, recorded models.SequenceStats) {
+	p.sequencesMu.Lock()
+	p.sequences[patientID] = &sequenceTracker{sessionID: sessionID, session: recorded}
+	p.sequencesMu.Unlock()
+}
+
+// takeSequenceStats returns the counts not yet written to the database, by
+// session, and forgets the trackers of patients in drop.
+func (p *BeltProcessor) takeSequenceStats(drop []string) map[int64]models.SequenceStats {
+	p.sequencesMu.Lock()
+	defer p.sequencesMu.Unlock()
+	updates := make(map[int64]models.SequenceStats)
+	for _, tracker := range p.sequences {
+		if tracker.sessionID != 0 && tracker.pending != (models.SequenceStats{}) {
+			updates[tracker.sessionID] = tracker.pending
+			tracker.pending = models.SequenceStats{}
+		}
+	}
+	for _, patientID := range drop {
+		delete(p.sequences, patientID)
+	}
+	return updates
+}
+
+func (p *BeltProcessor) sequenceStats(patientID string) (models.SequenceStats, bool) {
+	p.sequencesMu.Lock()
+	defer p.sequencesMu.Unlock()
+	tracker, ok := p.sequences[patientID]
+	if !ok {
+		return models.SequenceStats{}, false
+	}
+	return tracker.session, true
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"belt-presense/internal/models"
+)
+
+type packet struct{ no, ts int64 }
+
+func TestSequenceObserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   []packet
+		next     packet
+		wantKind string
+		wantGap  *models.GapMarker
+		// wantStats counts every packet, including next.
+		wantStats models.SequenceStats
+	}{
+		{"first packet", nil, packet{7, 1000}, seqInOrder, nil, models.SequenceStats{}},
+		{"in order", []packet{{1, 1000}}, packet{2, 2000}, seqInOrder, nil, models.SequenceStats{}},
+		{"gap", []packet{{1, 1000}, {2, 2000}}, packet{5, 5000}, seqGap,
+			&models.GapMarker{Missing: 2, DurationMs: 3000}, models.SequenceStats{Gaps: 1, MissingPackets: 2, GapMs: 3000}},
+		{"gap with an older timestamp", []packet{{1, 5000}}, packet{3, 4000}, seqGap,
+			&models.GapMarker{Missing: 1}, models.SequenceStats{Gaps: 1, MissingPackets: 1}},
+		{"duplicate", []packet{{1, 1000}, {2, 2000}}, packet{2, 2000}, seqDuplicate, nil, models.SequenceStats{Duplicates: 1}},
+		{"late", []packet{{1, 1000}, {3, 3000}}, packet{2, 2000}, seqDuplicate,
+			nil, models.SequenceStats{Gaps: 1, MissingPackets: 1, GapMs: 2000, Duplicates: 1}},
+		{"counter reset", []packet{{40, 1000}, {41, 2000}}, packet{1, 9000}, seqReset,
+			&models.GapMarker{DurationMs: 7000, Reset: true}, models.SequenceStats{Resets: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &sequenceTracker{}
+			for _, p := range tt.before {
+				tracker.observe(p.no, p.ts)
+			}
+			kind, gap := tracker.observe(tt.next.no, tt.next.ts)
+			if kind != tt.wantKind {
+				t.Errorf("observe() kind = %s, want %s", kind, tt.wantKind)
+			}
+			if !reflect.DeepEqual(gap, tt.wantGap) {
+				t.Errorf("observe() gap = %+v, want %+v", gap, tt.wantGap)
+			}
+			if tracker.session != tt.wantStats || tracker.pending != tt.wantStats {
+				t.Errorf("stats = %+v session, %+v pending; want %+v", tracker.session, tracker.pending, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestSequenceContinuesAfterReset(t *testing.T) {
+	tracker := &sequenceTracker{}
+	for _, p := range []packet{{40, 1000}, {1, 9000}} {
+		tracker.observe(p.no, p.ts)
+	}
+	if kind, _ := tracker.observe(2, 10000); kind != seqInOrder {
+		t.Errorf("packet after a reset is %s, want %s", kind, seqInOrder)
+	}
+}
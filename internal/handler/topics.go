@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"belt-presense/internal/deadletter"
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+)
+
+// Control message types accepted on a Kafka control topic, mirroring the
+// MQTT control topics.
+const (
+	TypeSvcStart  = "svc_start"
+	TypeSvcAction = "svc_action"
+)
+
+// TopicHandler returns the handler for a topic subscription, by the names
+// used in the kafka.subscriptions config.
+func (p *BeltProcessor) TopicHandler(name string) (func(context.Context, []byte), bool) {
+	handlers := map[string]func(context.Context, []byte){
+		"vitals":      p.RouteVitalsMessage,
+		"ecg":         p.HandleECGMessage,
+		"bpspo2":      p.HandleBPSPO2Message,
+		"temperature": p.HandleTemperatureMessage,
+		"control":     p.HandleControlMessage,
+	}
+	h, ok := handlers[name]
+	return h, ok
+}
+
+func (p *BeltProcessor) HandleTemperatureMessage(ctx context.Context, msgValue []byte) {
+	var msg models.TemperatureMessage
+	if err := json.Unmarshal(msgValue, &msg); err != nil {
+		logging.FromContext(ctx).Warn("Error unmarshalling temperature message", "error", err, "message", string(msgValue))
+		metrics.DecodeErrors.WithLabelValues("temperature").Inc()
+		p.deadLetter(ctx, deadletter.ReasonDecodeError, err, msgValue)
+		return
+	}
+	p.handleTemperature(ctx, &msg)
+}
+
+// handleTemperature caches the latest reading; it is stamped onto the
+// sensor data of the patient's next batches.
+func (p *BeltProcessor) handleTemperature(ctx context.Context, msg *models.TemperatureMessage) {
+	if msg.PatientID == "" {
+		return
+	}
+	p.vitalsCacheMu.Lock()
+	defer p.vitalsCacheMu.Unlock()
+	vitals, exists := p.vitalsCache[msg.PatientID]
+	if !exists {
+		vitals = &CachedVitals{}
+		p.vitalsCache[msg.PatientID] = vitals
+	}
+	vitals.Temperature = &TemperatureReading{
+		Body:      msg.BodyTemp,
+		Skin:      msg.SkinTemp,
+		Ambient:   msg.AmbientTemp,
+		Timestamp: msg.EpochTime,
+	}
+	if vitals.DeviceID == "" {
+		vitals.DeviceID = msg.DeviceID
+	}
+	vitals.LastUpdated = time.Now().Unix()
+	logging.FromContext(ctx).Debug("Updated temperature cache", logging.KeyPatient, msg.PatientID,
+		"body", msg.BodyTemp, "skin", msg.SkinTemp, "ambient", msg.AmbientTemp)
+}
+
+// HandleControlMessage accepts svc_start and svc_action messages from Kafka.
+// The type comes from the message-type header or a "type" field; failing
+// both, a message with an "action" field is an svc_action.
+func (p *BeltProcessor) HandleControlMessage(ctx context.Context, msgValue []byte) {
+	var probe struct {
+		Type   string `json:"type"`
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(msgValue, &probe); err != nil {
+		logging.FromContext(ctx).Warn("Error unmarshalling control message", "error", err)
+		metrics.DecodeErrors.WithLabelValues("control").Inc()
+		p.deadLetter(ctx, deadletter.ReasonDecodeError, err, msgValue)
+		return
+	}
+	messageType, _ := ctx.Value(messageTypeKey{}).(string)
+	if messageType == "" {
+		messageType = normalizeType(probe.Type)
+	}
+	if messageType == "" {
+		messageType = TypeSvcStart
+		if probe.Action != "" {
+			messageType = TypeSvcAction
+		}
+	}
+	switch messageType {
+	case TypeSvcStart:
+		p.HandleSvcStartMessage(msgValue)
+	case TypeSvcAction:
+		p.HandleSvcActionMessage(msgValue)
+	default:
+		logging.FromContext(ctx).Warn("Unknown control message type, ignoring", "type", messageType)
+		metrics.UnknownMessages.Inc()
+		p.deadLetter(ctx, deadletter.ReasonUnknownType, fmt.Errorf("unknown control message type %q", messageType), msgValue)
+	}
+}
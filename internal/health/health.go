@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// Check returns nil when the component it covers is healthy.
+type Check func(ctx context.Context) error
+
+type Checker struct {
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+type Report struct {
+	Healthy bool              `json:"healthy"`
+	Checks  map[string]string `json:"checks"`
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		liveness:  make(map[string]Check),
+		readiness: make(map[string]Check),
+	}
+}
+
+// AddLiveness registers a check whose failure means the process is wedged
+// and should be restarted. Liveness checks are also part of readiness.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness[name] = check
+	c.readiness[name] = check
+}
+
+// AddReadiness registers a check whose failure means the service cannot
+// currently do useful work.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness[name] = check
+}
+
+func (c *Checker) Live(ctx context.Context) Report {
+	return c.run(ctx, c.liveness)
+}
+
+func (c *Checker) Ready(ctx context.Context) Report {
+	return c.run(ctx, c.readiness)
+}
+
+func (c *Checker) run(ctx context.Context, checks map[string]Check) Report {
+	c.mu.RLock()
+	names := make([]string, 0, len(checks))
+	snapshot := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		names = append(names, name)
+		snapshot[name] = check
+	}
+	c.mu.RUnlock()
+	sort.Strings(names)
+
+	report := Report{Healthy: true, Checks: make(map[string]string, len(names))}
+	for _, name := range names {
+		if err := snapshot[name](ctx); err != nil {
+			report.Healthy = false
+			report.Checks[name] = err.Error()
+		} else {
+			report.Checks[name] = "ok"
+		}
+	}
+	return report
+}
+
+// Heartbeat records when a loop last made progress.
+type Heartbeat struct {
+	last atomic.Int64
+}
+
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Within fails when the heartbeat has not beaten for longer than maxAge.
+func (h *Heartbeat) Within(name string, maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		last := h.last.Load()
+		if last == 0 {
+			return fmt.Errorf("%s has not started", name)
+		}
+		if age := time.Since(time.Unix(0, last)); age > maxAge {
+			return fmt.Errorf("%s last made progress %s ago", name, age.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// RunWatchdog tells systemd the service is ready and, when WatchdogSec is
+// configured on the unit, pings the watchdog for as long as liveness checks
+// pass. It is a no-op outside systemd.
+func (c *Checker) RunWatchdog(ctx context.Context) {
+	if sent, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
+		slog.Warn("systemd notify failed", "error", err)
+	} else if !sent {
+		return
+	}
+	defer daemon.SdNotify(false, daemon.SdNotifyStopping)
+
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil || interval == 0 {
+		<-ctx.Done()
+		return
+	}
+	slog.Info("systemd watchdog enabled", "pingInterval", interval/2)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report := c.Live(ctx)
+			if !report.Healthy {
+				slog.Warn("Liveness check failing, withholding watchdog ping", "checks", report.Checks)
+				continue
+			}
+			daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+		}
+	}
+}
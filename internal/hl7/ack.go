@@ -0,0 +1,74 @@
+package hl7
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Segments splits a message into its segments' fields. For MSH, element n
+// is field MSH-(n+1), since MSH-1 is the separator between the name and
+// MSH-2.
+func Segments(msg []byte) [][]string {
+	var segments [][]string
+	for _, line := range strings.FieldsFunc(string(msg), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		segments = append(segments, strings.Split(line, "|"))
+	}
+	return segments
+}
+
+// Field returns field n of the first segment called name, or "" if there is
+// none.
+func Field(msg []byte, name string, n int) string {
+	for _, seg := range Segments(msg) {
+		if seg[0] != name {
+			continue
+		}
+		if name == "MSH" {
+			n--
+		}
+		if n < len(seg) {
+			return seg[n]
+		}
+		return ""
+	}
+	return ""
+}
+
+// Ack is the MSA segment of an acknowledgement.
+type Ack struct {
+	// Code is AA/CA (accepted), AE/CE (error) or AR/CR (rejected).
+	Code      string
+	ControlID string
+	Text      string
+}
+
+func (a Ack) Accepted() bool { return a.Code == "AA" || a.Code == "CA" }
+
+// Rejected reports a message the receiver will never accept, as opposed to
+// an error that may clear if it is sent again.
+func (a Ack) Rejected() bool { return a.Code == "AR" || a.Code == "CR" }
+
+func ParseAck(msg []byte) (Ack, error) {
+	if Field(msg, "MSH", 9) == "" {
+		return Ack{}, errors.New("acknowledgement has no MSH segment")
+	}
+	a := Ack{Code: Field(msg, "MSA", 1), ControlID: Field(msg, "MSA", 2), Text: unescaper.Replace(Field(msg, "MSA", 3))}
+	if a.Code == "" {
+		return Ack{}, errors.New("acknowledgement has no MSA segment")
+	}
+	if a.Text == "" {
+		a.Text = unescaper.Replace(Field(msg, "ERR", 8))
+	}
+	return a, nil
+}
+
+// NewAck builds the acknowledgement a receiver sends for msg.
+func NewAck(msg []byte, code, text string) []byte {
+	var sb strings.Builder
+	segment(&sb, "MSH", "^~\\&", Field(msg, "MSH", 5), Field(msg, "MSH", 6), Field(msg, "MSH", 3),
+		Field(msg, "MSH", 4), ts(time.Now()), "", "ACK^R01^ACK", "ACK"+Field(msg, "MSH", 10),
+		Field(msg, "MSH", 11), Field(msg, "MSH", 12))
+	segment(&sb, "MSA", code, Field(msg, "MSH", 10), esc(text))
+	return []byte(sb.String())
+}
@@ -0,0 +1,171 @@
+// Package hl7 encodes processed batches as HL7 v2.5.1 ORU^R01 messages and
+// reads the acknowledgements sent back.
+package hl7
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"belt-presense/internal/models"
+)
+
+// ContentType is the media type of an encoded message.
+const ContentType = "application/hl7-v2"
+
+const version = "2.5.1"
+
+type Options struct {
+	SendingApplication string
+	// SendingFacility defaults to the batch's facility ID.
+	SendingFacility      string
+	ReceivingApplication string
+	ReceivingFacility    string
+	// ProcessingID is P (production), T (training) or D (debugging).
+	ProcessingID string
+	// PatientIDAuthority is the assigning authority of patient IDs in PID-3.
+	PatientIDAuthority string
+}
+
+// Batch is what a message is built from: the Presense payload, after the
+// route's payload options, and the ECG packets behind it.
+type Batch struct {
+	// ControlID is MSH-10, which the receiver echoes in its ACK.
+	ControlID  string
+	PatientID  string
+	FacilityID string
+	Payload    *models.PresensePayload
+	Messages   []*models.ECGMessage
+	// VitalsDeviceID is the BP/SpO2 device the cached vitals came from.
+	VitalsDeviceID string
+}
+
+// ControlID derives a message control ID from a batch's trace ID, so a
+// batch sent again carries the same ID and receivers can discard the
+// duplicate.
+func ControlID(traceID string) string {
+	sum := sha256.Sum256([]byte(traceID))
+	return hex.EncodeToString(sum[:10])
+}
+
+type observation struct {
+	code, name string
+	value      int
+	unit       string
+	at         int64
+	device     string
+}
+
+// Encode builds an ORU^R01 with one OBR for the batch and an OBX for each
+// vital sign: the most recent heart and respiratory rates from the belt and
+// the cached SpO2, pulse rate and blood pressure. Segments end in CR, as
+// MLLP expects.
+func Encode(b Batch, opts Options) []byte {
+	p := b.Payload
+	now := time.Now()
+	sendingFacility := opts.SendingFacility
+	if sendingFacility == "" {
+		sendingFacility = b.FacilityID
+	}
+
+	var obs []observation
+	var hr, rr *models.ECGMessage
+	for _, msg := range b.Messages {
+		if msg.HR > 0 {
+			hr = msg
+		}
+		if msg.RR > 0 {
+			rr = msg
+		}
+	}
+	if hr != nil {
+		obs = append(obs, observation{"8867-4", "Heart rate", hr.HR, "/min", hr.CurrentTimestamp, p.PatchID})
+	}
+	if rr != nil {
+		obs = append(obs, observation{"9279-1", "Respiratory rate", rr.RR, "/min", rr.CurrentTimestamp, p.PatchID})
+	}
+	if p.SPO2.IsValid {
+		obs = append(obs, observation{"59408-5", "Oxygen saturation in Arterial blood by Pulse oximetry", p.SPO2.Value, "%", p.SPO2.Timestamp, b.VitalsDeviceID})
+	}
+	if p.PR.IsValid {
+		obs = append(obs, observation{"8889-8", "Heart rate by Pulse oximetry", p.PR.Value, "/min", p.PR.Timestamp, b.VitalsDeviceID})
+	}
+	if p.BP.IsValid {
+		obs = append(obs,
+			observation{"8480-6", "Systolic blood pressure", p.BP.Sys, "mm[Hg]", p.BP.Timestamp, b.VitalsDeviceID},
+			observation{"8462-4", "Diastolic blood pressure", p.BP.Dia, "mm[Hg]", p.BP.Timestamp, b.VitalsDeviceID})
+	}
+
+	var admissionID string
+	observed := now
+	if len(b.Messages) > 0 {
+		admissionID = b.Messages[0].AdmissionID
+		observed = when(b.Messages[len(b.Messages)-1].CurrentTimestamp)
+	}
+
+	var sb strings.Builder
+	segment(&sb, "MSH", "^~\\&", esc(opts.SendingApplication), esc(sendingFacility),
+		esc(opts.ReceivingApplication), esc(opts.ReceivingFacility), ts(now), "",
+		"ORU^R01^ORU_R01", esc(b.ControlID), opts.ProcessingID, version)
+	segment(&sb, "PID", "1", "", esc(b.PatientID)+"^^^"+esc(opts.PatientIDAuthority)+"^MR", "",
+		esc(p.PatientName), "", "", sex(p.Gender))
+	segment(&sb, "PV1", "1", "I", "^^"+esc(p.BedID)+"^"+esc(b.FacilityID), "", "", "", "", "", "", "", "",
+		"", "", "", "", "", "", "", esc(admissionID))
+	segment(&sb, "OBR", "1", "", esc(b.ControlID), "8716-3^Vital signs^LN", "", "", ts(observed),
+		"", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "F")
+	for i, o := range obs {
+		segment(&sb, "OBX", strconv.Itoa(i+1), "NM", o.code+"^"+o.name+"^LN", "", strconv.Itoa(o.value),
+			o.unit+"^"+o.unit+"^UCUM", "", "", "", "", "F", "", "", ts(when(o.at)), "", "", "", esc(o.device))
+	}
+	return []byte(sb.String())
+}
+
+// segment writes a segment, dropping empty trailing fields. For MSH the
+// first field is MSH-2: MSH-1 is the separator after the name.
+func segment(sb *strings.Builder, name string, fields ...string) {
+	sb.WriteString(name)
+	sb.WriteString("|")
+	sb.WriteString(strings.TrimRight(strings.Join(fields, "|"), "|"))
+	sb.WriteString("\r")
+}
+
+// sex maps the belt's gender to HL7 table 0001.
+func sex(gender string) string {
+	switch strings.ToUpper(strings.TrimSpace(gender)) {
+	case "":
+		return ""
+	case "M", "MALE":
+		return "M"
+	case "F", "FEMALE":
+		return "F"
+	case "O", "OTHER":
+		return "O"
+	default:
+		return "U"
+	}
+}
+
+// when converts a timestamp to a time. Belt packets carry unix milliseconds
+// and BP/SpO2 readings unix seconds; values too small to be milliseconds are
+// taken as seconds.
+func when(t int64) time.Time {
+	if t < 1e12 {
+		t *= 1000
+	}
+	return time.UnixMilli(t)
+}
+
+func ts(t time.Time) string {
+	return t.UTC().Format("20060102150405.000-0700")
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\E\`, "|", `\F\`, "^", `\S\`, "~", `\R\`, "&", `\T\`, "\r", `\X0D\`, "\n", `\X0A\`)
+	unescaper = strings.NewReplacer(`\E\`, `\`, `\F\`, "|", `\S\`, "^", `\R\`, "~", `\T\`, "&", `\X0D\`, "\r", `\X0A\`, "\n")
+)
+
+func esc(s string) string {
+	return escaper.Replace(s)
+}
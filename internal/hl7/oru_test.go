@@ -0,0 +1,180 @@
+package hl7
+
+import (
+	"strings"
+	"testing"
+
+	"belt-presense/internal/models"
+)
+
+func testBatch() Batch {
+	return Batch{
+		ControlID:  ControlID("trace-1"),
+		PatientID:  "P-1",
+		FacilityID: "F-1",
+		Payload: &models.PresensePayload{
+			PatchID:     "patch-9",
+			PatientName: "Roe^Jane",
+			Gender:      "female",
+			BedID:       "B-4",
+			SPO2:        models.VitalSign{IsValid: true, Value: 97, Timestamp: 1700000000},
+			BP:          models.BloodPressure{IsValid: true, Sys: 120, Dia: 80, Timestamp: 1700000000},
+		},
+		Messages: []*models.ECGMessage{
+			{AdmissionID: "A-7", CurrentTimestamp: 1700000000000, HR: 70, RR: 14},
+			{AdmissionID: "A-7", CurrentTimestamp: 1700000001000, HR: 72},
+		},
+		VitalsDeviceID: "bp-3",
+	}
+}
+
+func TestEncodeFields(t *testing.T) {
+	b := testBatch()
+	msg := Encode(b, Options{SendingApplication: "BELT", ProcessingID: "P", PatientIDAuthority: "HOSP"})
+
+	if !strings.HasSuffix(string(msg), "\r") || strings.Contains(string(msg), "\n") {
+		t.Fatalf("segments must end in CR only: %q", msg)
+	}
+	tests := []struct {
+		segment string
+		field   int
+		want    string
+	}{
+		{"MSH", 3, "BELT"},
+		{"MSH", 4, "F-1"},
+		{"MSH", 9, "ORU^R01^ORU_R01"},
+		{"MSH", 10, b.ControlID},
+		{"MSH", 11, "P"},
+		{"MSH", 12, version},
+		{"PID", 3, "P-1^^^HOSP^MR"},
+		{"PID", 5, `Roe\S\Jane`},
+		{"PID", 8, "F"},
+		{"PV1", 3, "^^B-4^F-1"},
+		{"PV1", 19, "A-7"},
+		{"OBR", 3, b.ControlID},
+		{"OBR", 25, "F"},
+	}
+	for _, tt := range tests {
+		if got := Field(msg, tt.segment, tt.field); got != tt.want {
+			t.Errorf("%s-%d = %q, want %q", tt.segment, tt.field, got, tt.want)
+		}
+	}
+
+	var obx [][]string
+	for _, seg := range Segments(msg) {
+		if seg[0] == "OBX" {
+			obx = append(obx, seg)
+		}
+	}
+	want := []struct{ code, value, device string }{
+		{"8867-4", "72", "patch-9"},
+		{"9279-1", "14", "patch-9"},
+		{"59408-5", "97", "bp-3"},
+		{"8480-6", "120", "bp-3"},
+		{"8462-4", "80", "bp-3"},
+	}
+	if len(obx) != len(want) {
+		t.Fatalf("got %d OBX segments, want %d", len(obx), len(want))
+	}
+	for i, w := range want {
+		seg := obx[i]
+		if code := strings.SplitN(seg[3], "^", 2)[0]; code != w.code || seg[5] != w.value || seg[11] != "F" {
+			t.Errorf("OBX %d = %v, want code %s value %s", i+1, seg, w.code, w.value)
+		}
+		if len(seg) != 19 || seg[18] != w.device {
+			t.Errorf("OBX-18 of %s = %v, want %q", w.code, seg, w.device)
+		}
+	}
+}
+
+func TestEncodeEscapesDelimiters(t *testing.T) {
+	names := []string{
+		`O|Brien`,
+		`Roe^Jane`,
+		`Jane~Janet`,
+		`Roe & Roe`,
+		`C:\patients`,
+		"Jane\rOBX|99|ST",
+		"Jane\nRoe",
+		`\F\ literal`,
+	}
+	for _, name := range names {
+		b := testBatch()
+		b.Payload.PatientName = name
+		b.PatientID = name
+		msg := Encode(b, Options{})
+
+		segs := Segments(msg)
+		if len(segs) != 9 {
+			t.Errorf("%q: got %d segments, want 9", name, len(segs))
+		}
+		for _, seg := range segs {
+			if seg[0] == "PID" && len(seg) != 9 {
+				t.Errorf("%q: PID has %d fields, want 9: %v", name, len(seg), seg)
+			}
+		}
+		if got := unescaper.Replace(Field(msg, "PID", 5)); got != name {
+			t.Errorf("PID-5 round trip = %q, want %q", got, name)
+		}
+		if got := unescaper.Replace(strings.SplitN(Field(msg, "PID", 3), "^", 2)[0]); got != name {
+			t.Errorf("PID-3 round trip = %q, want %q", got, name)
+		}
+	}
+}
+
+func TestControlIDIsStable(t *testing.T) {
+	a, b := ControlID("trace-1"), ControlID("trace-1")
+	if a != b || len(a) != 20 {
+		t.Errorf("ControlID = %q, %q", a, b)
+	}
+	if ControlID("trace-2") == a {
+		t.Error("different traces share a control ID")
+	}
+}
+
+func TestAckRoundTrip(t *testing.T) {
+	msg := Encode(testBatch(), Options{SendingApplication: "BELT", ReceivingApplication: "EHR"})
+	tests := []struct {
+		code, text         string
+		accepted, rejected bool
+	}{
+		{"AA", "", true, false},
+		{"CA", "", true, false},
+		{"AE", "database busy | retry", false, false},
+		{"CE", "", false, false},
+		{"AR", "unknown patient ^ P-1", false, true},
+		{"CR", "", false, true},
+	}
+	for _, tt := range tests {
+		ack, err := ParseAck(NewAck(msg, tt.code, tt.text))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.code, err)
+		}
+		if ack.Code != tt.code || ack.Text != tt.text || ack.ControlID != Field(msg, "MSH", 10) {
+			t.Errorf("%s: parsed %+v", tt.code, ack)
+		}
+		if ack.Accepted() != tt.accepted || ack.Rejected() != tt.rejected {
+			t.Errorf("%s: accepted=%v rejected=%v", tt.code, ack.Accepted(), ack.Rejected())
+		}
+	}
+	if got := Field(NewAck(msg, "AA", ""), "MSH", 5); got != "BELT" {
+		t.Errorf("ACK MSH-5 = %q, want the sender", got)
+	}
+}
+
+func TestParseAckErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":  "",
+		"no MSH": "MSA|AA|1\r",
+		"no MSA": "MSH|^~\\&|A|B|C|D|20240101||ACK|1|P|2.5.1\r",
+	}
+	for name, in := range tests {
+		if _, err := ParseAck([]byte(in)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	ack, err := ParseAck([]byte("MSH|^~\\&|A|B|C|D|20240101||ACK|1|P|2.5.1\rMSA|AE|1\rERR||||||||bad\\F\\value\r"))
+	if err != nil || ack.Text != "bad|value" {
+		t.Errorf("ERR-8 fallback = %+v, %v", ack, err)
+	}
+}
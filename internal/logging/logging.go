@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Attribute keys shared by every log line that refers to these entities.
+const (
+	KeyPatient   = "patient"
+	KeyPatch     = "patch"
+	KeyFacility  = "facility"
+	KeyTraceID   = "traceID"
+	KeyTopic     = "topic"
+	KeyPartition = "partition"
+	KeyOffset    = "offset"
+)
+
+type Options struct {
+	Level   string
+	Format  string // "text" or "json"
+	File    string // empty disables file output
+	Console bool
+
+	// PHIPolicy, PHIFields and PHIHashKey configure redaction; see
+	// NewRedactor.
+	PHIPolicy  string
+	PHIFields  []string
+	PHIHashKey string
+
+	// Output replaces the file and console outputs when set.
+	Output io.Writer
+}
+
+var level = new(slog.LevelVar)
+
+// Setup builds the process-wide logger and installs it as the slog default,
+// which also routes the standard library's log package through it.
+func Setup(opts Options) (*slog.Logger, error) {
+	if err := SetLevel(opts.Level); err != nil {
+		return nil, err
+	}
+	redactor, err := NewRedactor(opts.PHIPolicy, opts.PHIFields, opts.PHIHashKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out := opts.Output
+	if out == nil {
+		var writers []io.Writer
+		if opts.File != "" {
+			writers = append(writers, &lumberjack.Logger{
+				Filename:   opts.File,
+				MaxSize:    5,
+				MaxBackups: 3,
+				MaxAge:     28,
+				Compress:   true,
+			})
+		}
+		if opts.Console || len(writers) == 0 {
+			writers = append(writers, os.Stdout)
+		}
+		out = io.MultiWriter(writers...)
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(out, handlerOpts)
+	case "json":
+		h = slog.NewJSONHandler(out, handlerOpts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", opts.Format)
+	}
+
+	logger := slog.New(newRedactingHandler(h, redactor))
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// SetLevel changes the verbosity of the running logger.
+func SetLevel(name string) error {
+	if name == "" {
+		name = "info"
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+func Level() string {
+	return strings.ToLower(level.Level().String())
+}
+
+type ctxKey struct{}
+
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
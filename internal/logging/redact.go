@@ -0,0 +1,222 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Redaction policies for PHI fields.
+const (
+	PolicyMask = "mask" // replace the value with a fixed marker
+	PolicyHash = "hash" // replace the value with a keyed hash so lines can still be correlated
+	PolicyOff  = "off"  // log values unchanged; local development only
+)
+
+const redactedMarker = "[REDACTED]"
+
+// DefaultPHIFields are the payload and attribute keys treated as PHI when no
+// explicit list is configured. Matching is case-insensitive.
+var DefaultPHIFields = []string{"patientName", "age", "gender", "dob", "dateOfBirth", "mrn", "address", "phone"}
+
+// Redactor masks or hashes PHI fields in log messages and attributes,
+// including fields inside JSON payloads logged as strings or bytes.
+type Redactor struct {
+	policy  string
+	hashKey []byte
+	fields  map[string]bool
+	// fieldPattern catches PHI fields in payloads too malformed to decode,
+	// which is exactly when raw messages end up in the logs.
+	fieldPattern *regexp.Regexp
+	// textPattern catches PHI fields formatted into free text as
+	// field=value or field: value, such as messages built with fmt or
+	// bridged from the log package.
+	textPattern *regexp.Regexp
+}
+
+// NewRedactor builds a redactor for policy. The hash policy needs hashKey:
+// an unkeyed hash of a name or date of birth is reversed by trying every
+// likely value.
+func NewRedactor(policy string, fields []string, hashKey string) (*Redactor, error) {
+	policy = strings.ToLower(policy)
+	switch policy {
+	case "":
+		policy = PolicyMask
+	case PolicyMask, PolicyOff:
+	case PolicyHash:
+		if hashKey == "" {
+			return nil, errors.New("the hash PHI redaction policy needs a hash key")
+		}
+	default:
+		return nil, fmt.Errorf("unknown PHI redaction policy %q (want mask, hash or off)", policy)
+	}
+	if len(fields) == 0 {
+		fields = DefaultPHIFields
+	}
+	r := &Redactor{policy: policy, hashKey: []byte(hashKey), fields: make(map[string]bool, len(fields))}
+	var quoted []string
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f != "" {
+			r.fields[strings.ToLower(f)] = true
+			quoted = append(quoted, regexp.QuoteMeta(f))
+		}
+	}
+	names := strings.Join(quoted, "|")
+	r.fieldPattern = regexp.MustCompile(`(?i)("(?:` + names + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`)
+	r.textPattern = regexp.MustCompile(`(?i)(\b(?:` + names + `)\b\\?"?\s*[=:]\s*)(\\?"(?:[^"\\]|\\[^"])*(?:\\?")?|[^,;}\]\s]*)`)
+	return r, nil
+}
+
+func (r *Redactor) isPHI(key string) bool {
+	return r.fields[strings.ToLower(key)]
+}
+
+func (r *Redactor) redactValue(v interface{}) string {
+	if r.policy == PolicyHash {
+		mac := hmac.New(sha256.New, r.hashKey)
+		mac.Write([]byte(fmt.Sprint(v)))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+	return redactedMarker
+}
+
+// RedactText returns s with PHI fields written as field=value or
+// field: value replaced, quoted or not.
+func (r *Redactor) RedactText(s string) string {
+	if r.policy == PolicyOff {
+		return s
+	}
+	return r.textPattern.ReplaceAllStringFunc(s, func(match string) string {
+		sub := r.textPattern.FindStringSubmatch(match)
+		value := strings.Trim(sub[2], `\"`)
+		return sub[1] + strconv.Quote(r.redactValue(value))
+	})
+}
+
+// RedactJSON returns data with PHI fields replaced. Input that is not valid
+// JSON is scrubbed by pattern instead.
+func (r *Redactor) RedactJSON(data []byte) []byte {
+	if r.policy == PolicyOff {
+		return data
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return r.fieldPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+			sub := r.fieldPattern.FindSubmatch(match)
+			value := strings.Trim(string(sub[2]), `"`)
+			return append(append([]byte{}, sub[1]...), strconv.Quote(r.redactValue(value))...)
+		})
+	}
+	out, err := json.Marshal(r.redactTree(doc))
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+func (r *Redactor) redactTree(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if r.isPHI(k) {
+				t[k] = r.redactValue(child)
+			} else {
+				t[k] = r.redactTree(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			t[i] = r.redactTree(child)
+		}
+	}
+	return v
+}
+
+func (r *Redactor) redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if r.isPHI(a.Key) {
+		return slog.String(a.Key, r.redactValue(a.Value.Any()))
+	}
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		attrs := a.Value.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, child := range attrs {
+			redacted[i] = r.redactAttr(child)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindString:
+		if s := a.Value.String(); looksLikeJSON(s) {
+			return slog.String(a.Key, string(r.RedactJSON([]byte(s))))
+		} else if redacted := r.RedactText(s); redacted != s {
+			return slog.String(a.Key, redacted)
+		}
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case []byte:
+			if looksLikeJSON(string(v)) {
+				return slog.String(a.Key, string(r.RedactJSON(v)))
+			}
+		case error:
+			if s := v.Error(); r.RedactText(s) != s {
+				return slog.String(a.Key, r.RedactText(s))
+			}
+		}
+	}
+	return a
+}
+
+func looksLikeJSON(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
+
+// redactingHandler applies a Redactor to every attribute before it reaches
+// the wrapped handler.
+type redactingHandler struct {
+	next     slog.Handler
+	redactor *Redactor
+}
+
+func newRedactingHandler(next slog.Handler, redactor *Redactor) slog.Handler {
+	if redactor == nil || redactor.policy == PolicyOff {
+		return next
+	}
+	return &redactingHandler{next: next, redactor: redactor}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.redactor.RedactText(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(h.redactor.redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactor.redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted), redactor: h.redactor}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), redactor: h.redactor}
+}
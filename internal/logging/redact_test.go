@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const patientName = "Jane Roe"
+
+func setupBuffer(t *testing.T, opts Options) *bytes.Buffer {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	opts.Output = &buf
+	if _, err := Setup(opts); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestPatientNameNeverReachesSink(t *testing.T) {
+	payload := fmt.Sprintf(`{"patientId":"p1","patientName":%q,"HR":72}`, patientName)
+	cases := []struct {
+		name string
+		log  func()
+	}{
+		{"attr", func() { slog.Info("Patient started", "patientName", patientName) }},
+		{"grouped attr", func() { slog.Info("Patient started", slog.Group("patient", "PatientName", patientName)) }},
+		{"logger attr", func() { slog.Default().With("patientName", patientName).Info("Patient started") }},
+		{"JSON payload", func() { slog.Warn("Bad packet", "payload", payload) }},
+		{"JSON payload bytes", func() { slog.Warn("Bad packet", "payload", []byte(payload)) }},
+		{"malformed JSON", func() { slog.Warn("Bad packet", "payload", payload[:len(payload)-10]) }},
+		{"error attr", func() {
+			slog.Error("Decode failed", "error", fmt.Errorf("decoding %s: %w", payload, errors.New("bad")))
+		}},
+		{"JSON in message", func() { slog.Warn("Bad packet " + payload) }},
+		{"key=value in message", func() { slog.Info(fmt.Sprintf("Starting patientName=%q age=42", patientName)) }},
+		{"standard log", func() { log.Printf("Received patientName: %q", patientName) }},
+	}
+	for _, format := range []string{"text", "json"} {
+		for _, policy := range []string{PolicyMask, PolicyHash} {
+			for _, tc := range cases {
+				t.Run(format+"/"+policy+"/"+tc.name, func(t *testing.T) {
+					buf := setupBuffer(t, Options{Format: format, PHIPolicy: policy, PHIHashKey: "test-key"})
+					tc.log()
+					out := buf.String()
+					if out == "" {
+						t.Fatal("nothing was logged")
+					}
+					for _, part := range strings.Fields(patientName) {
+						if strings.Contains(out, part) {
+							t.Errorf("log output contains %q:\n%s", part, out)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestHashPolicyIsKeyed(t *testing.T) {
+	if _, err := NewRedactor(PolicyHash, nil, ""); err == nil {
+		t.Fatal("hash policy without a key was accepted")
+	}
+	a, err := NewRedactor(PolicyHash, nil, "key-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRedactor(PolicyHash, nil, "key-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.redactValue(patientName) != a.redactValue(patientName) {
+		t.Error("hash is not stable for one key")
+	}
+	if a.redactValue(patientName) == b.redactValue(patientName) {
+		t.Error("hash does not depend on the key")
+	}
+	if !strings.HasPrefix(a.redactValue(patientName), "hmac:") {
+		t.Errorf("unexpected hash %q", a.redactValue(patientName))
+	}
+}
+
+func TestOffPolicyKeepsValues(t *testing.T) {
+	buf := setupBuffer(t, Options{Format: "json", PHIPolicy: PolicyOff})
+	slog.Info("Starting patientName="+patientName, "patientName", patientName)
+	if strings.Count(buf.String(), patientName) != 2 {
+		t.Errorf("off policy changed the output: %s", buf.String())
+	}
+}
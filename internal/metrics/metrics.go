@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "belt_presense"
+
+var (
+	KafkaMessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_messages_consumed_total",
+		Help:      "Kafka messages received by the consumer, by topic.",
+	}, []string{"topic"})
+
+	KafkaErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_errors_total",
+		Help:      "Errors reported by the Kafka consumer.",
+	})
+
+	KafkaConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Messages between the consumer position and the high watermark, by topic and partition.",
+	}, []string{"topic", "partition"})
+
+	ECGPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ecg_packets_total",
+		Help:      "ECG packets decoded, by facility.",
+	}, []string{"facility"})
+
+	BPSPO2Packets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bpspo2_packets_total",
+		Help:      "BP/SPO2 packets decoded, by facility.",
+	}, []string{"facility"})
+
+	RoutedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "routed_messages_total",
+		Help:      "Vitals messages routed to a handler, by message type and how the type was determined (header, field, device_type or sniffed).",
+	}, []string{"type", "via"})
+
+	SequenceEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sequence_events_total",
+		Help:      "ECG packet sequence anomalies, by facility and kind (gap, duplicate or reset).",
+	}, []string{"facility", "kind"})
+
+	MissingPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "missing_packets_total",
+		Help:      "ECG packets inferred missing from sequence gaps, by facility.",
+	}, []string{"facility"})
+
+	DuplicatesSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicates_suppressed_total",
+		Help:      "ECG packets dropped as repeats of a packet already accepted, by facility.",
+	}, []string{"facility"})
+
+	ReorderHeld = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reorder_held_total",
+		Help:      "ECG packets held in the reorder buffer because an earlier packet had not arrived, by facility.",
+	}, []string{"facility"})
+
+	LatePackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "late_packets_total",
+		Help:      "ECG packets that arrived after later ones were released, by facility and late policy.",
+	}, []string{"facility", "policy"})
+
+	UnknownMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unknown_messages_total",
+		Help:      "Vitals messages that matched no known type.",
+	})
+
+	DecodeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decode_errors_total",
+		Help:      "Messages that failed JSON decoding, by message type.",
+	}, []string{"type"})
+
+	BatchesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batches_sent_total",
+		Help:      "Batches delivered to the Presense API, by facility and result.",
+	}, []string{"facility", "result"})
+
+	PresenseItems = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "presense_items_total",
+		Help:      "Items the Presense API reported accepting or rejecting, by facility and result.",
+	}, []string{"facility", "result"})
+
+	DeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Messages dead-lettered, by origin and reason.",
+	}, []string{"origin", "reason"})
+
+	SinkDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_deliveries_total",
+		Help:      "Batches handed to each output sink, by sink and final result (success, error or dropped).",
+	}, []string{"sink", "result"})
+
+	SinkRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sink_retries_total",
+		Help:      "Delivery attempts retried after a failure, by sink.",
+	}, []string{"sink"})
+
+	SinkQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sink_queue_depth",
+		Help:      "Batches waiting to be delivered, by sink.",
+	}, []string{"sink"})
+
+	SinkQueueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sink_queue_wait_seconds",
+		Help:      "Time batches waited in a sink's queue before delivery started, by sink.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"sink"})
+
+	SinkQueueOldest = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sink_queue_oldest_seconds",
+		Help:      "Age of the oldest batch waiting in a sink's queue, by sink.",
+	}, []string{"sink"})
+
+	SinkInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sink_in_flight",
+		Help:      "Requests a sink has in progress, by sink.",
+	}, []string{"sink"})
+
+	SinkThrottleWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sink_throttle_wait_seconds",
+		Help:      "Time deliveries waited for the rate limit and in-flight cap, by sink.",
+		Buckets:   []float64{.001, .01, .05, .1, .5, 1, 5, 10, 30},
+	}, []string{"sink"})
+
+	APIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of Presense API requests, by HTTP status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of repository operations, by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	DBErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_errors_total",
+		Help:      "Failed repository operations, by operation.",
+	}, []string{"operation"})
+)
+
+// ObserveAPIRequest records a Presense API call. A zero code means the
+// request failed before a response was received.
+func ObserveAPIRequest(start time.Time, code int) {
+	label := "error"
+	if code != 0 {
+		label = strconv.Itoa(code)
+	}
+	APIRequestDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDBQuery is meant to be deferred with a pointer to the caller's named
+// error return.
+func ObserveDBQuery(operation string, start time.Time, errp *error) {
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if errp != nil && *errp != nil {
+		DBErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// FacilityLabel keeps label cardinality bounded when the facility is missing.
+func FacilityLabel(facilityID string) string {
+	if facilityID == "" {
+		return "unknown"
+	}
+	return facilityID
+}
+
+type cacheCollector struct {
+	desc  *prometheus.Desc
+	sizes func() map[string]int
+}
+
+// RegisterCacheSizes exposes the processor's in-memory cache sizes, sampled
+// at scrape time.
+func RegisterCacheSizes(sizes func() map[string]int) {
+	prometheus.MustRegister(&cacheCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "cache_entries"),
+			"Entries held in the processor's in-memory caches, by cache.",
+			[]string{"cache"}, nil,
+		),
+		sizes: sizes,
+	})
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, size := range c.sizes() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(size), name)
+	}
+}
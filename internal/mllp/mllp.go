@@ -0,0 +1,238 @@
+// Package mllp sends and receives messages framed with the Minimal Lower
+// Layer Protocol used to carry HL7 v2 over TCP.
+package mllp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	startBlock = 0x0b
+	endBlock   = 0x1c
+	carriage   = 0x0d
+)
+
+// maxFrame bounds a frame read, so a peer that never sends the end block
+// cannot grow the buffer indefinitely.
+const maxFrame = 1 << 20
+
+// WriteFrame writes msg wrapped in the MLLP start and end blocks.
+func WriteFrame(w *bufio.Writer, msg []byte) error {
+	w.WriteByte(startBlock)
+	w.Write(msg)
+	w.WriteByte(endBlock)
+	w.WriteByte(carriage)
+	return w.Flush()
+}
+
+// ReadFrame reads one framed message and returns its content.
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == startBlock {
+			break
+		}
+	}
+	var msg []byte
+	for {
+		chunk, err := r.ReadSlice(endBlock)
+		msg = append(msg, chunk...)
+		if len(msg) > maxFrame {
+			return nil, fmt.Errorf("frame longer than %d bytes", maxFrame)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if b, err := r.ReadByte(); err != nil {
+		return nil, err
+	} else if b != carriage {
+		return nil, errors.New("frame end block not followed by carriage return")
+	}
+	return msg[:len(msg)-1], nil
+}
+
+type ClientOptions struct {
+	Addr        string
+	DialTimeout time.Duration
+	// AckTimeout bounds the wait for the acknowledgement of each message.
+	AckTimeout time.Duration
+}
+
+// Client sends messages over one persistent connection, one at a time,
+// waiting for each acknowledgement before the next message. The connection
+// is opened on first use and reopened after any error.
+type Client struct {
+	opts ClientOptions
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func NewClient(opts ClientOptions) *Client {
+	return &Client{opts: opts}
+}
+
+// Send writes msg and returns the acknowledgement read back.
+func (c *Client) Send(ctx context.Context, msg []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", c.opts.Addr, err)
+		}
+		c.conn, c.r, c.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	}
+
+	var deadline time.Time
+	if c.opts.AckTimeout > 0 {
+		deadline = time.Now().Add(c.opts.AckTimeout)
+	}
+	conn := c.conn
+	conn.SetDeadline(deadline)
+	// ctx's own deadline is enforced here rather than copied to the
+	// connection, so that a cancelled Send always reports ctx.Err().
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := WriteFrame(c.w, msg); err != nil {
+		c.reset()
+		return nil, fmt.Errorf("writing message: %w", err)
+	}
+	ack, err := ReadFrame(c.r)
+	if err != nil {
+		c.reset()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("reading acknowledgement: %w", err)
+	}
+	return ack, nil
+}
+
+// Reset closes the connection, so the next Send opens a new one. It is
+// used when the peer's answers can no longer be trusted to match requests.
+func (c *Client) Reset() {
+	c.mu.Lock()
+	c.reset()
+	c.mu.Unlock()
+}
+
+func (c *Client) reset() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *Client) Close() {
+	c.Reset()
+}
+
+// Handler answers one received message with its acknowledgement, or with
+// nil to send nothing back.
+type Handler func(msg []byte) []byte
+
+// Server is an MLLP listener, for receivers and for exercising senders
+// against a local peer.
+type Server struct {
+	listener net.Listener
+	handler  Handler
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Listen starts a server on addr; ":0" picks a free port, reported by Addr.
+func Listen(addr string, handler Handler) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: l, handler: handler, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		msg, err := ReadFrame(r)
+		if err != nil {
+			return
+		}
+		ack := s.handler(msg)
+		if ack == nil {
+			continue
+		}
+		if err := WriteFrame(w, ack); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops listening, closes open connections and waits for their
+// handlers to return.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
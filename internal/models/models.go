@@ -1,5 +1,7 @@
 package models
 
+import "strings"
+
 // VitalsMessage is now split into ECGMessage and BPSPO2Message below.
 
 // NEW: ECGMessage represents the data from the ECG device.
@@ -23,19 +25,35 @@ type ECGMessage struct {
 
 // NEW: BPSPO2Message represents the data from the BP/SPO2 device.
 type BPSPO2Message struct {
+	PatientID   string      `json:"patientId"`
+	FacilityID  string      `json:"facilityId"`
+	AdmissionID string      `json:"admissionId"`
+	DeviceID    string      `json:"deviceID"`
+	EpochTime   int64       `json:"epochTime"`
+	BP          BPReading   `json:"bp"`
+	SPO2        SPO2Reading `json:"spo2"`
+}
+
+type BPReading struct {
+	BPSystolic  int `json:"bpSystolic"`
+	BPDiastolic int `json:"bpDiastolic"`
+}
+
+type SPO2Reading struct {
+	Spo2      int `json:"spo2"`
+	PulseRate int `json:"pulseRate"`
+}
+
+// TemperatureMessage is a reading from the belt's temperature sensors, in
+// the units the Presense payload uses.
+type TemperatureMessage struct {
 	PatientID   string `json:"patientId"`
 	FacilityID  string `json:"facilityId"`
-	AdmissionID string `json:"admissionId"`
-	DeviceID    string `json:"deviceID"`
+	DeviceID    string `json:"deviceId"`
 	EpochTime   int64  `json:"epochTime"`
-	BP          struct {
-		BPSystolic  int `json:"bpSystolic"`
-		BPDiastolic int `json:"bpDiastolic"`
-	} `json:"bp"`
-	SPO2 struct {
-		Spo2      int `json:"spo2"`
-		PulseRate int `json:"pulseRate"`
-	} `json:"spo2"`
+	BodyTemp    int    `json:"BODYTEMP"`
+	SkinTemp    int    `json:"SKINTEMP"`
+	AmbientTemp int    `json:"AMBTEMP_AVG"`
 }
 
 // --- Structs for the outgoing Presense API Payload ---
@@ -72,6 +90,17 @@ type SensorDataItem struct {
 	SKINTEMP    int       `json:"SKINTEMP,omitempty"`
 	AMBTEMP_AVG int       `json:"AMBTEMP_AVG,omitempty"`
 	TSECG       int64     `json:"TSECG,omitempty"`
+	// Gap, when gap markers are enabled, describes packets missing
+	// immediately before this one.
+	Gap *GapMarker `json:"gap,omitempty"`
+}
+
+type GapMarker struct {
+	Missing    int64 `json:"missing"`
+	DurationMs int64 `json:"durationMs"`
+	// Reset means the belt restarted its packet counter, so the number of
+	// packets lost is unknown.
+	Reset bool `json:"reset,omitempty"`
 }
 
 type VitalSign struct {
@@ -93,13 +122,32 @@ type ArrythmiaItem struct {
 
 // PatientStream represents a patient's monitoring session
 type PatientStream struct {
-	PatientID        string
-	DeviceID         string // MODIFIED: Added DeviceID to link to patchId
-	Status           string
-	FacilityID       string
-	StartTime        int64
-	EndTime          *int64
-	LastStreamedTime *int64
+	SessionID        int64         `json:"sessionId"`
+	PatientID        string        `json:"patientId"`
+	DeviceID         string        `json:"deviceId"` // MODIFIED: Added DeviceID to link to patchId
+	Status           string        `json:"status"`
+	FacilityID       string        `json:"facilityId"`
+	StartTime        int64         `json:"startTime"`
+	EndTime          *int64        `json:"endTime,omitempty"`
+	LastStreamedTime *int64        `json:"lastStreamedTime,omitempty"`
+	Sequence         SequenceStats `json:"sequence"`
+}
+
+// SequenceStats counts packet sequence anomalies over a monitoring session.
+type SequenceStats struct {
+	Gaps           int64 `json:"gaps"`
+	MissingPackets int64 `json:"missingPackets"`
+	GapMs          int64 `json:"gapMs"`
+	Duplicates     int64 `json:"duplicates"`
+	Resets         int64 `json:"resets"`
+}
+
+func (s *SequenceStats) Add(o SequenceStats) {
+	s.Gaps += o.Gaps
+	s.MissingPackets += o.MissingPackets
+	s.GapMs += o.GapMs
+	s.Duplicates += o.Duplicates
+	s.Resets += o.Resets
 }
 
 type SvcStartPayload struct {
@@ -115,3 +163,97 @@ type SvcActionPayload struct {
 	PatchID string `json:"patchId"`
 	Action  string `json:"action"`
 }
+
+// PacketWindow is the recent packets of one patient's stream, remembered to
+// suppress redelivered duplicates across restarts.
+type PacketWindow struct {
+	PatchID string      `json:"patchId"`
+	Packets []PacketRef `json:"packets"` // oldest first
+}
+
+type PacketRef struct {
+	PacketNo  int64 `json:"n"`
+	Timestamp int64 `json:"t"`
+}
+
+// DeadLetter is a message the service could not process or deliver, kept
+// with enough context to inspect and replay it.
+type DeadLetter struct {
+	ID int64 `json:"id"`
+	// Origin is "kafka" for consumed messages or "sink:<name>" for batches a
+	// sink rejected.
+	Origin     string `json:"origin"`
+	Reason     string `json:"reason"`
+	Error      string `json:"error"`
+	Topic      string `json:"topic,omitempty"`
+	Partition  int32  `json:"partition"`
+	Offset     int64  `json:"offset"`
+	PatientID  string `json:"patientId,omitempty"`
+	FacilityID string `json:"facilityId,omitempty"`
+	DeviceType string `json:"deviceType,omitempty"`
+	// Headers are the consumed message's Kafka headers, restored when it is
+	// republished.
+	Headers    []Header `json:"headers,omitempty"`
+	Payload    []byte   `json:"payload"`
+	CreatedAt  int64    `json:"createdAt"` // unix milliseconds
+	ReplayedAt *int64   `json:"replayedAt,omitempty"`
+}
+
+// Header is a Kafka message header.
+type Header struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// PresenseResponse is the body the Presense API answers a batch with. Fields
+// the API leaves out stay zero.
+type PresenseResponse struct {
+	// ID is the server-assigned batch ID.
+	ID       string          `json:"id,omitempty"`
+	Accepted int             `json:"accepted"`
+	Rejected int             `json:"rejected"`
+	Message  string          `json:"message,omitempty"`
+	Errors   []PresenseError `json:"errors,omitempty"`
+}
+
+// PresenseError is one validation error reported by the Presense API.
+type PresenseError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Reason summarises the response's message and validation errors.
+func (r *PresenseResponse) Reason() string {
+	if r == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(r.Errors)+1)
+	if r.Message != "" {
+		parts = append(parts, r.Message)
+	}
+	for _, e := range r.Errors {
+		if e.Field != "" {
+			parts = append(parts, e.Field+": "+e.Message)
+		} else {
+			parts = append(parts, e.Message)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Delivery records the outcome of sending one batch to the Presense API.
+type Delivery struct {
+	ID         int64  `json:"id"`
+	PatientID  string `json:"patientId"`
+	FacilityID string `json:"facilityId,omitempty"`
+	Route      string `json:"route,omitempty"`
+	TraceID    string `json:"traceId,omitempty"`
+	// Result is success, rejected, invalid, error or evicted.
+	Result     string            `json:"result"`
+	StatusCode int               `json:"statusCode,omitempty"`
+	Attempts   int               `json:"attempts"`
+	Error      string            `json:"error,omitempty"`
+	Response   *PresenseResponse `json:"response,omitempty"`
+	CreatedAt  int64             `json:"createdAt"` // unix milliseconds
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signature is the receiver's side of HMACAuth: HMAC-SHA256 over
+// "<timestamp>.<body>".
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + string(body)))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignatureVector(t *testing.T) {
+	// Computed independently of Go's crypto packages.
+	const want = "sha256=49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"
+	if got := signature("secret", "1700000000", []byte(`{"a":1}`)); got != want {
+		t.Fatalf("signature() = %s, want %s", got, want)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	body := []byte(`{"patientRef":"P-1"}`)
+	tests := []struct {
+		name  string
+		auth  HMACAuth
+		keyID string
+	}{
+		{"with key ID", HMACAuth{KeyID: "k1", Secret: "s3cret"}, "k1"},
+		{"without key ID", HMACAuth{Secret: "s3cret"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "https://example.com/data", nil)
+			before := time.Now().Unix()
+			if err := tt.auth.Authorize(context.Background(), req, body); err != nil {
+				t.Fatal(err)
+			}
+
+			ts := req.Header.Get("X-Timestamp")
+			sec, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil || sec < before || sec > time.Now().Unix() {
+				t.Errorf("X-Timestamp = %q, want the current unix time in seconds", ts)
+			}
+			if got, want := req.Header.Get("X-Signature"), signature(tt.auth.Secret, ts, body); got != want {
+				t.Errorf("X-Signature = %s, want %s", got, want)
+			}
+			if got := req.Header.Get("X-Key-Id"); got != tt.keyID {
+				t.Errorf("X-Key-Id = %q, want %q", got, tt.keyID)
+			}
+			if req.Header.Get("X-Signature") == signature(tt.auth.Secret, ts, append(body, ' ')) {
+				t.Error("signature does not cover the body")
+			}
+			if req.Header.Get("X-Signature") == signature("other", ts, body) {
+				t.Error("signature does not depend on the secret")
+			}
+		})
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/data", nil)
+	if err := (BearerAuth{Token: "tok"}).Authorize(context.Background(), req, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer tok" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
+
+func TestOAuth2AuthCachesAndInvalidates(t *testing.T) {
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			if r.PostFormValue("client_id") != "client" || r.PostFormValue("client_secret") != "secret" {
+				http.Error(w, "bad client", http.StatusUnauthorized)
+				return
+			}
+		}
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(int(n)) + `","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	auth := NewOAuth2(OAuth2Options{TokenURL: srv.URL, ClientID: "client", ClientSecret: "secret", Client: srv.Client()})
+	authorize := func() string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/data", nil)
+		if err := auth.Authorize(context.Background(), req, nil); err != nil {
+			t.Fatal(err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	if got := authorize(); got != "Bearer token-1" {
+		t.Fatalf("Authorization = %q", got)
+	}
+	if got := authorize(); got != "Bearer token-1" || issued.Load() != 1 {
+		t.Errorf("second request: Authorization = %q after %d token requests; want the cached token", got, issued.Load())
+	}
+	auth.Invalidate()
+	if got := authorize(); got != "Bearer token-2" {
+		t.Errorf("after Invalidate: Authorization = %q, want a new token", got)
+	}
+}
@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"belt-presense/internal/config"
+	"belt-presense/internal/fhir"
+	"belt-presense/internal/hl7"
+)
+
+// FromConfig builds a dispatcher with every sink enabled in cfg.
+func FromConfig(cfg *config.Config) (*Dispatcher, error) {
+	d := NewDispatcher()
+	s := cfg.Sinks
+	add := func(sk Sink, o config.SinkOptions) {
+		switch o.Format {
+		case config.FormatFHIR:
+			sk = WithFormat(sk, FHIREncoder(fhir.Options{
+				BundleType:      cfg.FHIR.BundleType,
+				PatientSystem:   cfg.FHIR.PatientSystem,
+				EncounterSystem: cfg.FHIR.EncounterSystem,
+				DeviceSystem:    cfg.FHIR.DeviceSystem,
+				ECGSampleRate:   cfg.FHIR.ECGSampleRate,
+			}))
+		case config.FormatHL7:
+			sk = WithFormat(sk, HL7Encoder(hl7.Options{
+				SendingApplication:   cfg.HL7.SendingApplication,
+				SendingFacility:      cfg.HL7.SendingFacility,
+				ReceivingApplication: cfg.HL7.ReceivingApplication,
+				ReceivingFacility:    cfg.HL7.ReceivingFacility,
+				ProcessingID:         cfg.HL7.ProcessingID,
+				PatientIDAuthority:   cfg.HL7.PatientIDAuthority,
+			}))
+		}
+		d.Add(sk, options(o))
+	}
+	if s.Presense.Enabled {
+		d.Add(PresenseFromConfig(cfg), options(s.Presense.SinkOptions))
+	}
+	if s.File.Enabled || cfg.Destinations.WriteToFile {
+		add(&File{Dir: s.File.Dir}, s.File.SinkOptions)
+	}
+	if s.MQTT.Enabled {
+		add(NewMQTT(MQTTOptions{
+			BrokerURL: cfg.MQTT.BrokerURL,
+			ClientID:  s.MQTT.ClientID,
+			Username:  cfg.MQTT.Username,
+			Password:  cfg.MQTT.Password,
+			Topic:     s.MQTT.Topic,
+			QoS:       byte(s.MQTT.QoS),
+			Retained:  s.MQTT.Retained,
+		}), s.MQTT.SinkOptions)
+	}
+	if s.Webhook.Enabled {
+		add(NewWebhook(s.Webhook.URL, s.Webhook.Headers, s.Webhook.Timeout), s.Webhook.SinkOptions)
+	}
+	if s.Kafka.Enabled {
+		brokers := s.Kafka.Brokers
+		if brokers == "" {
+			brokers = cfg.Kafka.Brokers
+		}
+		producer, err := NewKafka(KafkaOptions{
+			Brokers:          brokers,
+			Topic:            s.Kafka.Topic,
+			Compression:      strings.ToLower(s.Kafka.Compression),
+			MessageTimeoutMs: int(s.Kafka.MessageTimeout.Milliseconds()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		add(producer, s.Kafka.SinkOptions)
+	}
+	if s.MLLP.Enabled {
+		add(NewMLLP(MLLPOptions{
+			Addr:        s.MLLP.Addr,
+			DialTimeout: s.MLLP.DialTimeout,
+			AckTimeout:  s.MLLP.AckTimeout,
+		}), s.MLLP.SinkOptions)
+	}
+	return d, nil
+}
+
+// PresenseFromConfig builds the Presense sink configured by
+// sinks.presense, whether or not it is enabled.
+func PresenseFromConfig(cfg *config.Config) *Presense {
+	p := cfg.Sinks.Presense
+	return NewPresense(PresenseOptions{
+		Timeout:       p.Timeout,
+		MaxInFlight:   p.MaxInFlight,
+		RatePerSecond: p.RateLimit.PerSecond,
+		Burst:         p.RateLimit.Burst,
+	})
+}
+
+// AuthFromConfig builds the authenticator for a resolved route. OAuth2
+// tokens are requested with client.
+func AuthFromConfig(r config.RouteConfig, client *http.Client) Authenticator {
+	a := r.Auth
+	switch a.AuthType() {
+	case config.AuthOAuth2:
+		return NewOAuth2(OAuth2Options{
+			TokenURL:      a.TokenURL,
+			ClientID:      a.ClientID,
+			ClientSecret:  a.ClientSecret,
+			Scopes:        a.Scopes,
+			RefreshBefore: a.RefreshBefore,
+			Client:        client,
+		})
+	case config.AuthHMAC:
+		return HMACAuth{KeyID: a.KeyID, Secret: a.Secret}
+	default:
+		return BearerAuth{Token: r.APIKey}
+	}
+}
+
+// ClientFromConfig builds the HTTP client for a destination's transport,
+// with timeout as the request timeout unless the transport sets its own.
+func ClientFromConfig(t config.TransportConfig, timeout time.Duration) (*http.Client, error) {
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+	return NewHTTPClient(TransportOptions{
+		CAFile:                t.CAFile,
+		CertFile:              t.CertFile,
+		KeyFile:               t.KeyFile,
+		Proxy:                 t.Proxy,
+		Timeout:               timeout,
+		DialTimeout:           t.DialTimeout,
+		TLSHandshakeTimeout:   t.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+		IdleConnTimeout:       t.IdleConnTimeout,
+		MaxIdleConns:          t.MaxIdleConns,
+		MaxIdleConnsPerHost:   t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       t.MaxConnsPerHost,
+	})
+}
+
+func options(o config.SinkOptions) Options {
+	return Options{
+		QueueSize: o.QueueSize,
+		Workers:   o.Workers,
+		Retry: RetryPolicy{
+			MaxAttempts:    o.Retry.MaxAttempts,
+			InitialBackoff: o.Retry.InitialBackoff,
+			MaxBackoff:     o.Retry.MaxBackoff,
+		},
+		MaxQueueAge: o.MaxQueueAge,
+	}
+}
@@ -0,0 +1,334 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// drainTimeout bounds how long shutdown waits for queued envelopes.
+const drainTimeout = 10 * time.Second
+
+// ErrEvicted is reported for envelopes dropped from a sink's queue before
+// delivery was attempted.
+var ErrEvicted = errors.New("evicted from sink queue")
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	d := r.InitialBackoff
+	for i := 1; i < attempt && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+type Options struct {
+	QueueSize int
+	Workers   int
+	Retry     RetryPolicy
+	// MaxQueueAge evicts envelopes that have waited longer; zero disables.
+	MaxQueueAge time.Duration
+}
+
+// Result reports the final outcome of delivering an envelope to one sink.
+type Result struct {
+	Sink     string
+	Envelope *Envelope
+	Attempts int
+	Err      error
+	// Response is the answer to the last attempt, from sinks that implement
+	// ResponseSink.
+	Response *Response
+}
+
+type queued struct {
+	ctx      context.Context
+	env      *Envelope
+	enqueued time.Time
+}
+
+type worker struct {
+	sink  Sink
+	opts  Options
+	queue *patientQueue
+}
+
+type eviction struct {
+	w    *worker
+	item queued
+	err  error
+}
+
+// Dispatcher fans envelopes out to every registered sink. Each sink has its
+// own queue, workers and retry policy, so a slow or failing sink never
+// delays the others. When a sink's queue is full its waiting envelopes are
+// evicted, oldest first, as are envelopes waiting longer than the sink's
+// MaxQueueAge. Within a sink, each patient's envelopes are delivered one at a
+// time in the order they were dispatched; workers deliver for different
+// patients in parallel. Evictions are reported from Run, never from
+// Dispatch, so result callbacks do not run on the caller's goroutine.
+type Dispatcher struct {
+	mu       sync.RWMutex
+	workers  []*worker
+	onResult []func(Result)
+
+	evictMu   sync.Mutex
+	evictions []eviction
+	evicted   chan struct{} // signals Run that evictions are waiting
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{evicted: make(chan struct{}, 1)}
+}
+
+// Add registers a sink. It must be called before Run.
+func (d *Dispatcher) Add(s Sink, opts Options) {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Retry.MaxAttempts < 1 {
+		opts.Retry.MaxAttempts = 1
+	}
+	d.workers = append(d.workers, &worker{sink: s, opts: opts, queue: newPatientQueue(opts.QueueSize)})
+}
+
+// OnResult registers fn to be called after each delivery finishes, whether
+// it succeeded or not, and for each envelope evicted unsent. It must be
+// called before Run.
+func (d *Dispatcher) OnResult(fn func(Result)) {
+	d.onResult = append(d.onResult, fn)
+}
+
+func (d *Dispatcher) Sinks() []string {
+	names := make([]string, len(d.workers))
+	for i, w := range d.workers {
+		names[i] = w.sink.Name()
+	}
+	return names
+}
+
+// Dispatch queues env for every sink without blocking. Delivery outlives
+// ctx but keeps its values, so logs and spans stay attached to the batch.
+func (d *Dispatcher) Dispatch(ctx context.Context, env *Envelope) {
+	var evictions []eviction
+	d.mu.RLock()
+	logger := logging.FromContext(ctx)
+	ctx = context.WithoutCancel(ctx)
+	for _, w := range d.workers {
+		name := w.sink.Name()
+		evicted, ok := w.queue.push(queued{ctx: ctx, env: env, enqueued: time.Now()})
+		if !ok {
+			logger.Warn("Sink dispatcher stopped, dropping batch", "sink", name)
+			metrics.SinkDeliveries.WithLabelValues(name, "dropped").Inc()
+			continue
+		}
+		metrics.SinkQueueDepth.WithLabelValues(name).Inc()
+		for _, item := range evicted {
+			evictions = append(evictions, eviction{w, item, fmt.Errorf("%w: queue full (%d batches)", ErrEvicted, w.opts.QueueSize)})
+		}
+	}
+	d.mu.RUnlock()
+
+	if len(evictions) > 0 {
+		d.evictMu.Lock()
+		d.evictions = append(d.evictions, evictions...)
+		d.evictMu.Unlock()
+		select {
+		case d.evicted <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// reportEvictions reports the envelopes Dispatch evicted since it was last
+// called.
+func (d *Dispatcher) reportEvictions() {
+	d.evictMu.Lock()
+	evictions := d.evictions
+	d.evictions = nil
+	d.evictMu.Unlock()
+	for _, e := range evictions {
+		d.evict(e.w, e.item, e.err)
+	}
+}
+
+// evict reports an envelope removed from w's queue without delivery.
+func (d *Dispatcher) evict(w *worker, item queued, err error) {
+	name := w.sink.Name()
+	metrics.SinkQueueDepth.WithLabelValues(name).Dec()
+	metrics.SinkDeliveries.WithLabelValues(name, "evicted").Inc()
+	logging.FromContext(item.ctx).Warn("Evicted batch from sink queue", "sink", name,
+		"queuedFor", time.Since(item.enqueued).Round(time.Millisecond), "error", err)
+	for _, fn := range d.onResult {
+		fn(Result{Sink: name, Envelope: item.env, Err: err})
+	}
+}
+
+// sweep evicts envelopes older than each sink's MaxQueueAge and records the
+// age of the oldest one still waiting.
+func (d *Dispatcher) sweep() {
+	for _, w := range d.workers {
+		if age := w.opts.MaxQueueAge; age > 0 {
+			for _, item := range w.queue.expire(age) {
+				d.evict(w, item, fmt.Errorf("%w: waited longer than %s", ErrEvicted, age))
+			}
+		}
+		metrics.SinkQueueOldest.WithLabelValues(w.sink.Name()).Set(w.queue.oldestAge().Seconds())
+	}
+}
+
+// PatientQueues returns, for each sink, the batches not yet delivered by
+// patient, including the one in flight.
+func (d *Dispatcher) PatientQueues() map[string]map[string]int {
+	queues := make(map[string]map[string]int, len(d.workers))
+	for _, w := range d.workers {
+		queues[w.sink.Name()] = w.queue.depths()
+	}
+	return queues
+}
+
+// PatientQueueDepth returns, for each sink, the batches not yet delivered
+// for one patient.
+func (d *Dispatcher) PatientQueueDepth(patientID string) map[string]int {
+	depths := make(map[string]int)
+	for _, w := range d.workers {
+		if n := w.queue.depths()[patientID]; n > 0 {
+			depths[w.sink.Name()] = n
+		}
+	}
+	return depths
+}
+
+// Run delivers queued envelopes until ctx is cancelled, then spends up to
+// drainTimeout delivering what is still queued.
+func (d *Dispatcher) Run(ctx context.Context) {
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+
+	var wg sync.WaitGroup
+	for _, w := range d.workers {
+		for i := 0; i < w.opts.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					item, ok := w.queue.take()
+					if !ok {
+						return
+					}
+					metrics.SinkQueueDepth.WithLabelValues(w.sink.Name()).Dec()
+					metrics.SinkQueueWait.WithLabelValues(w.sink.Name()).Observe(time.Since(item.enqueued).Seconds())
+					d.deliver(drainCtx, w, item)
+					w.queue.done(item.env.PatientID)
+				}
+			}()
+		}
+	}
+
+	sweepTicker := time.NewTicker(time.Second)
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-d.evicted:
+			d.reportEvictions()
+		case <-sweepTicker.C:
+			d.sweep()
+		}
+	}
+	sweepTicker.Stop()
+	d.reportEvictions()
+
+	d.mu.Lock()
+	for _, w := range d.workers {
+		w.queue.close()
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		logging.FromContext(ctx).Warn("Sink queues not drained before shutdown")
+		cancelDrain()
+		<-done
+	}
+	for _, w := range d.workers {
+		if c, ok := w.sink.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+}
+
+// deliver sends one envelope with retries. stop aborts backoff waits during
+// shutdown.
+func (d *Dispatcher) deliver(stop context.Context, w *worker, item queued) {
+	name := w.sink.Name()
+	logger := logging.FromContext(item.ctx).With("sink", name)
+	ctx, span := tracing.Tracer().Start(item.ctx, "sink.deliver", trace.WithAttributes(
+		attribute.String("sink.name", name),
+		attribute.String("patient.id", item.env.PatientID),
+		attribute.String("batch.trace_id", item.env.TraceID),
+	))
+	defer span.End()
+	ctx = logging.WithContext(ctx, logger)
+
+	var err error
+	var resp *Response
+	attempt := 1
+	for ; ; attempt++ {
+		resp, err = send(ctx, w.sink, item.env)
+		if err == nil || IsPermanent(err) || attempt >= w.opts.Retry.MaxAttempts {
+			break
+		}
+		wait := w.opts.Retry.backoff(attempt)
+		logger.Warn("Sink delivery failed, retrying", "attempt", attempt, "retryIn", wait, "error", err)
+		metrics.SinkRetries.WithLabelValues(name).Inc()
+		select {
+		case <-time.After(wait):
+		case <-stop.Done():
+		}
+		if stop.Err() != nil {
+			break
+		}
+	}
+	span.SetAttributes(attribute.Int("sink.attempts", attempt))
+
+	result := "success"
+	if err != nil {
+		result = "error"
+		tracing.RecordError(span, err)
+		logger.Error("Sink delivery failed", "attempts", attempt, "error", err)
+	}
+	metrics.SinkDeliveries.WithLabelValues(name, result).Inc()
+	for _, fn := range d.onResult {
+		fn(Result{Sink: name, Envelope: item.env, Attempts: attempt, Err: err, Response: resp})
+	}
+}
+
+func send(ctx context.Context, s Sink, env *Envelope) (*Response, error) {
+	if rs, ok := s.(ResponseSink); ok {
+		return rs.SendWithResponse(ctx, env)
+	}
+	return nil, s.Send(ctx, env)
+}
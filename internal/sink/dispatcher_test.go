@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type nopSink struct{}
+
+func (nopSink) Name() string                          { return "nop" }
+func (nopSink) Send(context.Context, *Envelope) error { return nil }
+
+func TestDispatchReportsEvictionsFromRun(t *testing.T) {
+	d := NewDispatcher()
+	d.Add(nopSink{}, Options{QueueSize: 1})
+	results := make(chan Result, 10)
+	release := make(chan struct{})
+	d.OnResult(func(r Result) {
+		<-release // a slow callback, such as a dead-letter write
+		results <- r
+	})
+
+	dispatched := make(chan struct{})
+	go func() {
+		d.Dispatch(context.Background(), &Envelope{PatientID: "P-1", TraceID: "first"})
+		d.Dispatch(context.Background(), &Envelope{PatientID: "P-1", TraceID: "second"})
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch blocked on a result callback")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	close(release)
+
+	var evicted []string
+	timeout := time.After(2 * time.Second)
+	for len(evicted) == 0 {
+		select {
+		case r := <-results:
+			if errors.Is(r.Err, ErrEvicted) {
+				evicted = append(evicted, r.Envelope.TraceID)
+			}
+		case <-timeout:
+			t.Fatal("eviction never reported")
+		}
+	}
+	if evicted[0] != "first" {
+		t.Errorf("evicted %v, want the first envelope", evicted)
+	}
+}
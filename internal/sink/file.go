@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"belt-presense/internal/hl7"
+	"belt-presense/internal/tracing"
+)
+
+// File writes each payload under Dir/<patient>/, JSON indented.
+type File struct {
+	Dir string
+}
+
+// fileExtensions maps the content types of non-JSON formats to the
+// extension of the files they are written to.
+var fileExtensions = map[string]string{
+	hl7.ContentType: ".hl7",
+}
+
+func (s *File) Name() string { return "file" }
+
+func (s *File) Send(ctx context.Context, env *Envelope) error {
+	_, span := tracing.Tracer().Start(ctx, "file.write")
+	defer span.End()
+
+	dirPath := filepath.Join(s.Dir, env.PatientID)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	data, ext := env.Body, ".json"
+	if e, ok := fileExtensions[env.ContentType]; ok {
+		ext = e
+	} else {
+		var prettyJSON bytes.Buffer
+		if err := json.Indent(&prettyJSON, env.Body, "", "  "); err != nil {
+			tracing.RecordError(span, err)
+			return Permanent(fmt.Errorf("prettifying JSON: %w", err))
+		}
+		data = prettyJSON.Bytes()
+	}
+	filename := fmt.Sprintf("%s_%d%s", env.Payload.PatchID, env.Payload.Timestamp, ext)
+	if err := os.WriteFile(filepath.Join(dirPath, filename), data, 0644); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("writing file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"belt-presense/internal/fhir"
+	"belt-presense/internal/hl7"
+	"belt-presense/internal/models"
+)
+
+// Encoder turns an envelope into the body a sink sends in place of the
+// Presense payload.
+type Encoder struct {
+	Format      string
+	ContentType string
+	Encode      func(env *Envelope) ([]byte, error)
+}
+
+// FHIREncoder encodes envelopes as FHIR R4 Bundles.
+func FHIREncoder(opts fhir.Options) Encoder {
+	return Encoder{
+		Format:      "fhir",
+		ContentType: fhir.ContentType,
+		Encode: func(env *Envelope) ([]byte, error) {
+			payload, err := env.payload()
+			if err != nil {
+				return nil, err
+			}
+			return fhir.Encode(fhir.Batch{
+				PatientID:      env.PatientID,
+				Payload:        payload,
+				Messages:       env.Messages,
+				VitalsDeviceID: env.VitalsDeviceID,
+			}, opts)
+		},
+	}
+}
+
+// HL7Encoder encodes envelopes as HL7 v2 ORU^R01 messages, with a control
+// ID derived from the batch's trace ID.
+func HL7Encoder(opts hl7.Options) Encoder {
+	return Encoder{
+		Format:      "hl7v2",
+		ContentType: hl7.ContentType,
+		Encode: func(env *Envelope) ([]byte, error) {
+			payload, err := env.payload()
+			if err != nil {
+				return nil, err
+			}
+			return hl7.Encode(hl7.Batch{
+				ControlID:      hl7.ControlID(env.TraceID),
+				PatientID:      env.PatientID,
+				FacilityID:     env.FacilityID,
+				Payload:        payload,
+				Messages:       env.Messages,
+				VitalsDeviceID: env.VitalsDeviceID,
+			}, opts), nil
+		},
+	}
+}
+
+// WithFormat wraps s so that it sends each envelope as encoded by enc. An
+// envelope that cannot be encoded fails permanently.
+func WithFormat(s Sink, enc Encoder) Sink {
+	return &formatted{Sink: s, enc: enc}
+}
+
+type formatted struct {
+	Sink
+	enc Encoder
+}
+
+func (s *formatted) Send(ctx context.Context, env *Envelope) error {
+	body, err := s.enc.Encode(env)
+	if err != nil {
+		return Permanent(fmt.Errorf("encoding %s: %w", s.enc.Format, err))
+	}
+	out := *env
+	out.Body, out.ContentType = body, s.enc.ContentType
+	return s.Sink.Send(ctx, &out)
+}
+
+func (s *formatted) Close() {
+	if c, ok := s.Sink.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+// payload returns the decoded Presense payload, which replayed envelopes
+// carry only as Body.
+func (e *Envelope) payload() (*models.PresensePayload, error) {
+	if e.Payload != nil {
+		return e.Payload, nil
+	}
+	var p models.PresensePayload
+	if err := json.Unmarshal(e.Body, &p); err != nil {
+		return nil, fmt.Errorf("decoding payload: %w", err)
+	}
+	return &p, nil
+}
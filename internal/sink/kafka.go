@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/tracing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type KafkaOptions struct {
+	Brokers     string
+	Topic       string
+	Compression string
+	// MessageTimeoutMs bounds how long the producer keeps retrying a message
+	// internally before reporting it failed.
+	MessageTimeoutMs int
+}
+
+// Kafka publishes each payload to an output topic, keyed by patient ID so a
+// patient's batches stay in order on one partition. The producer is
+// idempotent, so its internal retries never duplicate or reorder messages.
+type Kafka struct {
+	producer *kafka.Producer
+	topic    string
+	// reportWait bounds the wait for a delivery report once Send's context
+	// is done: message.timeout.ms plus a margin.
+	reportWait time.Duration
+}
+
+func NewKafka(opts KafkaOptions) (*Kafka, error) {
+	producer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  opts.Brokers,
+		"enable.idempotence": true,
+		"compression.type":   opts.Compression,
+		"message.timeout.ms": opts.MessageTimeoutMs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating Kafka producer: %w", err)
+	}
+	s := &Kafka{producer: producer, topic: opts.Topic, reportWait: time.Duration(opts.MessageTimeoutMs)*time.Millisecond + 5*time.Second}
+	go s.logEvents()
+	return s, nil
+}
+
+// logEvents reports producer-level errors. Delivery reports go to the
+// per-message channels passed to Produce, not here.
+func (s *Kafka) logEvents() {
+	for ev := range s.producer.Events() {
+		if e, ok := ev.(kafka.Error); ok {
+			metrics.KafkaErrors.Inc()
+			slog.Error("Kafka producer error", logging.KeyTopic, s.topic, "error", e, "code", e.Code().String(), "fatal", e.IsFatal())
+		}
+	}
+}
+
+func (s *Kafka) Name() string { return "kafka" }
+
+func (s *Kafka) Send(ctx context.Context, env *Envelope) error {
+	ctx, span := tracing.Tracer().Start(ctx, "kafka.produce", trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", s.topic),
+		))
+	defer span.End()
+
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &s.topic, Partition: kafka.PartitionAny},
+		Key:            []byte(env.PatientID),
+		Value:          env.Body,
+		Headers: []kafka.Header{
+			{Key: "facilityId", Value: []byte(env.FacilityID)},
+			{Key: "traceId", Value: []byte(env.TraceID)},
+			{Key: "contentType", Value: []byte(env.contentType())},
+		},
+	}
+	tracing.InjectKafka(ctx, msg)
+
+	delivery := make(chan kafka.Event, 1)
+	if err := s.producer.Produce(msg, delivery); err != nil {
+		tracing.RecordError(span, err)
+		return kafkaError("producing", err)
+	}
+	report, err := s.awaitReport(ctx, delivery)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+	if err := report.TopicPartition.Error; err != nil {
+		tracing.RecordError(span, err)
+		return kafkaError("delivery", err)
+	}
+	span.SetAttributes(
+		attribute.Int("messaging.kafka.destination.partition", int(report.TopicPartition.Partition)),
+		attribute.Int64("messaging.kafka.message.offset", int64(report.TopicPartition.Offset)),
+	)
+	return nil
+}
+
+// awaitReport waits for the delivery report of a produced message. The
+// producer owns the message until it reports it, and the idempotent producer
+// only dedupes its own retries, so returning early would let the dispatcher
+// publish it a second time. Once ctx is done the wait therefore continues,
+// bounded by the message timeout; a report still missing after that leaves
+// the outcome unknown, which is not retried.
+func (s *Kafka) awaitReport(ctx context.Context, delivery chan kafka.Event) (*kafka.Message, error) {
+	var ev kafka.Event
+	select {
+	case ev = <-delivery:
+	case <-ctx.Done():
+		timer := time.NewTimer(s.reportWait)
+		defer timer.Stop()
+		select {
+		case ev = <-delivery:
+		case <-timer.C:
+			return nil, Permanent(fmt.Errorf("kafka delivery: no report within %s: %w", s.reportWait, ctx.Err()))
+		}
+	}
+	report, ok := ev.(*kafka.Message)
+	if !ok {
+		return nil, Permanent(fmt.Errorf("kafka delivery: unexpected event %v", ev))
+	}
+	return report, nil
+}
+
+// kafkaError marks errors the producer cannot recover from as permanent so
+// they are not retried.
+func kafkaError(op string, err error) error {
+	err = fmt.Errorf("kafka %s: %w", op, err)
+	var kerr kafka.Error
+	if errors.As(err, &kerr) && kerr.IsFatal() {
+		return Permanent(err)
+	}
+	return err
+}
+
+// Close delivers anything still buffered, for up to five seconds.
+func (s *Kafka) Close() {
+	if remaining := s.producer.Flush(5000); remaining > 0 {
+		slog.Warn("Kafka producer closed with undelivered messages", logging.KeyTopic, s.topic, "messages", remaining)
+	}
+	s.producer.Close()
+}
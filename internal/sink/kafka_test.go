@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// With no broker reachable, the producer reports the message failed once
+// message.timeout.ms passes. A Send cancelled before then must wait for that
+// report rather than hand the message back for a second Produce.
+func TestKafkaSendWaitsForReportAfterCancel(t *testing.T) {
+	s, err := NewKafka(KafkaOptions{Brokers: "127.0.0.1:1", Topic: "out", Compression: "none", MessageTimeoutMs: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = s.Send(ctx, &Envelope{PatientID: "P-1", Body: []byte("{}")})
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("Send() succeeded without a broker")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Send() = %v; it gave up on a message the producer still owned", err)
+	}
+	if elapsed < 900*time.Millisecond {
+		t.Errorf("Send() returned after %s, before the delivery report", elapsed)
+	}
+	if IsPermanent(err) {
+		t.Errorf("Send() = %v; a reported delivery failure should be retried", err)
+	}
+}
+
+func TestKafkaSendGivesUpWithoutReport(t *testing.T) {
+	s, err := NewKafka(KafkaOptions{Brokers: "127.0.0.1:1", Topic: "out", Compression: "none", MessageTimeoutMs: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.reportWait = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = s.Send(ctx, &Envelope{PatientID: "P-1", Body: []byte("{}")})
+	if !errors.Is(err, context.Canceled) || !IsPermanent(err) {
+		t.Errorf("Send() = %v, want a permanent error wrapping the cancellation", err)
+	}
+}
@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"belt-presense/internal/hl7"
+	"belt-presense/internal/mllp"
+	"belt-presense/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type MLLPOptions struct {
+	Addr        string
+	DialTimeout time.Duration
+	AckTimeout  time.Duration
+}
+
+// MLLP sends HL7 v2 messages to an MLLP receiver and checks the
+// acknowledgement of each one.
+type MLLP struct {
+	addr   string
+	client *mllp.Client
+}
+
+func NewMLLP(opts MLLPOptions) *MLLP {
+	return &MLLP{
+		addr: opts.Addr,
+		client: mllp.NewClient(mllp.ClientOptions{
+			Addr:        opts.Addr,
+			DialTimeout: opts.DialTimeout,
+			AckTimeout:  opts.AckTimeout,
+		}),
+	}
+}
+
+// NAKError is returned when the receiver acknowledges a message with an
+// error (AE/CE) or a rejection (AR/CR). Only errors are retried.
+type NAKError struct {
+	Code string
+	Text string
+}
+
+func (e *NAKError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("receiver answered %s", e.Code)
+	}
+	return fmt.Sprintf("receiver answered %s: %s", e.Code, e.Text)
+}
+
+func (s *MLLP) Name() string { return "mllp" }
+
+func (s *MLLP) Send(ctx context.Context, env *Envelope) error {
+	ctx, span := tracing.Tracer().Start(ctx, "mllp.send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", s.addr)))
+	defer span.End()
+
+	controlID := hl7.Field(env.Body, "MSH", 10)
+	span.SetAttributes(attribute.String("hl7.control_id", controlID))
+	reply, err := s.client.Send(ctx, env.Body)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("mllp: %w", err)
+	}
+	ack, err := hl7.ParseAck(reply)
+	if err == nil && ack.ControlID != controlID {
+		err = fmt.Errorf("acknowledgement is for message %q, not %q", ack.ControlID, controlID)
+	}
+	if err != nil {
+		// The connection is out of step with the receiver; start afresh.
+		s.client.Reset()
+		tracing.RecordError(span, err)
+		return fmt.Errorf("mllp: %w", err)
+	}
+	span.SetAttributes(attribute.String("hl7.ack_code", ack.Code))
+	if ack.Accepted() {
+		return nil
+	}
+	err = &NAKError{Code: ack.Code, Text: ack.Text}
+	tracing.RecordError(span, err)
+	if ack.Rejected() {
+		return Permanent(fmt.Errorf("mllp: %w", err))
+	}
+	return fmt.Errorf("mllp: %w", err)
+}
+
+func (s *MLLP) Close() {
+	s.client.Close()
+}
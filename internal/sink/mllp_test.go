@@ -0,0 +1,279 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"belt-presense/internal/hl7"
+	"belt-presense/internal/mllp"
+	"belt-presense/internal/models"
+)
+
+// peer runs an in-process MLLP receiver that answers each message with
+// answer and records what it received.
+type peer struct {
+	*mllp.Server
+	mu       sync.Mutex
+	received [][]byte
+}
+
+func startPeer(t *testing.T, answer func(n int, msg []byte) []byte) *peer {
+	t.Helper()
+	p := &peer{}
+	srv, err := mllp.Listen("127.0.0.1:0", func(msg []byte) []byte {
+		p.mu.Lock()
+		p.received = append(p.received, msg)
+		n := len(p.received)
+		p.mu.Unlock()
+		return answer(n, msg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Server = srv
+	t.Cleanup(func() { srv.Close() })
+	return p
+}
+
+func (p *peer) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.received)
+}
+
+func newTestMLLP(t *testing.T, addr string) *MLLP {
+	t.Helper()
+	s := NewMLLP(MLLPOptions{Addr: addr, DialTimeout: time.Second, AckTimeout: 200 * time.Millisecond})
+	t.Cleanup(s.Close)
+	return s
+}
+
+func oruEnvelope(traceID, name string) *Envelope {
+	b := hl7.Batch{
+		ControlID: hl7.ControlID(traceID),
+		PatientID: "P-1",
+		Payload:   &models.PresensePayload{PatientName: name},
+		Messages:  []*models.ECGMessage{{CurrentTimestamp: 1700000000000, HR: 72}},
+	}
+	return &Envelope{PatientID: "P-1", TraceID: traceID, Body: hl7.Encode(b, hl7.Options{}), ContentType: hl7.ContentType}
+}
+
+func TestMLLPAckHandling(t *testing.T) {
+	tests := []struct {
+		code      string
+		wantErr   bool
+		permanent bool
+	}{
+		{"AA", false, false},
+		{"CA", false, false},
+		{"AE", true, false},
+		{"CE", true, false},
+		{"AR", true, true},
+		{"CR", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			p := startPeer(t, func(_ int, msg []byte) []byte { return hl7.NewAck(msg, tt.code, "busy") })
+			s := newTestMLLP(t, p.Addr())
+
+			err := s.Send(context.Background(), oruEnvelope("trace-"+tt.code, "Roe^Jane"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() = %v, want error %v", err, tt.wantErr)
+			}
+			if IsPermanent(err) != tt.permanent {
+				t.Errorf("IsPermanent(%v) = %v, want %v", err, IsPermanent(err), tt.permanent)
+			}
+			var nak *NAKError
+			if tt.wantErr && (!errors.As(err, &nak) || nak.Code != tt.code || nak.Text != "busy") {
+				t.Errorf("Send() = %v, want a NAKError for %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestMLLPRetryAfterError(t *testing.T) {
+	p := startPeer(t, func(n int, msg []byte) []byte {
+		if n == 1 {
+			return hl7.NewAck(msg, "AE", "try again")
+		}
+		return hl7.NewAck(msg, "AA", "")
+	})
+	s := newTestMLLP(t, p.Addr())
+	env := oruEnvelope("trace-1", "Roe^Jane")
+
+	if err := s.Send(context.Background(), env); err == nil || IsPermanent(err) {
+		t.Fatalf("first Send() = %v, want a retryable error", err)
+	}
+	if err := s.Send(context.Background(), env); err != nil {
+		t.Fatalf("second Send() = %v", err)
+	}
+	if p.count() != 2 {
+		t.Errorf("peer received %d messages, want 2", p.count())
+	}
+}
+
+// A peer out of step answers with the acknowledgement of another message and
+// leaves a stale frame queued behind it. The sender must drop the connection,
+// or the stale frame would be read as the answer to the next message.
+func TestMLLPControlIDMismatchResets(t *testing.T) {
+	p := startPeer(t, func(n int, msg []byte) []byte {
+		if n == 1 {
+			other := hl7.NewAck([]byte("MSH|^~\\&|||||||ORU^R01|someone-else|P|2.5.1\r"), "AA", "")
+			stale := hl7.NewAck(msg, "AR", "stale")
+			// The handler's reply is framed once; splice a second frame in.
+			return append(append(other, 0x1c, 0x0d, 0x0b), stale...)
+		}
+		return hl7.NewAck(msg, "AA", "")
+	})
+	s := newTestMLLP(t, p.Addr())
+
+	err := s.Send(context.Background(), oruEnvelope("trace-1", "Roe^Jane"))
+	if err == nil || IsPermanent(err) || !strings.Contains(err.Error(), "someone-else") {
+		t.Fatalf("first Send() = %v, want a retryable control ID mismatch", err)
+	}
+	if err := s.Send(context.Background(), oruEnvelope("trace-2", "Roe^Jane")); err != nil {
+		t.Fatalf("Send() after mismatch = %v; the stale frame was read", err)
+	}
+}
+
+func TestMLLPTransportErrorsAreRetried(t *testing.T) {
+	t.Run("no ack", func(t *testing.T) {
+		p := startPeer(t, func(int, []byte) []byte { return nil })
+		s := newTestMLLP(t, p.Addr())
+		err := s.Send(context.Background(), oruEnvelope("trace-1", "Roe^Jane"))
+		if err == nil || IsPermanent(err) {
+			t.Errorf("Send() = %v, want a retryable error", err)
+		}
+	})
+	t.Run("garbage ack", func(t *testing.T) {
+		p := startPeer(t, func(int, []byte) []byte { return []byte("not hl7") })
+		s := newTestMLLP(t, p.Addr())
+		err := s.Send(context.Background(), oruEnvelope("trace-1", "Roe^Jane"))
+		if err == nil || IsPermanent(err) {
+			t.Errorf("Send() = %v, want a retryable error", err)
+		}
+	})
+	t.Run("peer gone", func(t *testing.T) {
+		p := startPeer(t, func(_ int, msg []byte) []byte { return hl7.NewAck(msg, "AA", "") })
+		addr := p.Addr()
+		p.Close()
+		s := newTestMLLP(t, addr)
+		err := s.Send(context.Background(), oruEnvelope("trace-1", "Roe^Jane"))
+		if err == nil || IsPermanent(err) {
+			t.Errorf("Send() = %v, want a retryable error", err)
+		}
+	})
+	t.Run("cancelled", func(t *testing.T) {
+		p := startPeer(t, func(int, []byte) []byte { return nil })
+		s := NewMLLP(MLLPOptions{Addr: p.Addr(), DialTimeout: time.Second})
+		defer s.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := s.Send(ctx, oruEnvelope("trace-1", "Roe^Jane"))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Send() = %v, want the context's error", err)
+		}
+	})
+}
+
+// A message much larger than the reader's buffer arrives over the
+// connection in pieces; the receiver must reassemble it intact.
+func TestMLLPLargeMessage(t *testing.T) {
+	p := startPeer(t, func(_ int, msg []byte) []byte { return hl7.NewAck(msg, "AA", "") })
+	s := newTestMLLP(t, p.Addr())
+	env := oruEnvelope("trace-1", strings.Repeat("Jane", 50000))
+
+	if err := s.Send(context.Background(), env); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	got := p.received[0]
+	p.mu.Unlock()
+	if !bytes.Equal(got, env.Body) {
+		t.Errorf("peer received %d bytes, want the %d sent", len(got), len(env.Body))
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	frame := func(body string) string { return "\x0b" + body + "\x1c\r" }
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "simple", in: frame("MSH|a"), want: "MSH|a"},
+		{name: "noise before start", in: "\r\n  " + frame("MSH|a"), want: "MSH|a"},
+		{name: "longer than buffer", in: frame(long), want: long},
+		{name: "empty", in: frame(""), want: ""},
+		{name: "missing carriage return", in: "\x0bMSH|a\x1cX", wantErr: true},
+		{name: "truncated after end block", in: "\x0bMSH|a\x1c", wantErr: true},
+		{name: "no end block", in: "\x0bMSH|a", wantErr: true},
+		{name: "no start block", in: "MSH|a\x1c\r", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A 16-byte buffer makes anything longer take the ErrBufferFull path.
+			r := bufio.NewReaderSize(strings.NewReader(tt.in), 16)
+			got, err := mllp.ReadFrame(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadFrame() = %q, %v", got, err)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("ReadFrame() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("two frames", func(t *testing.T) {
+		r := bufio.NewReaderSize(strings.NewReader(frame(long)+frame("second")), 16)
+		for _, want := range []string{long, "second"} {
+			got, err := mllp.ReadFrame(r)
+			if err != nil || string(got) != want {
+				t.Fatalf("ReadFrame() = %q, %v, want %q", got, err, want)
+			}
+		}
+		if _, err := mllp.ReadFrame(r); err != io.EOF {
+			t.Errorf("ReadFrame() at end = %v, want EOF", err)
+		}
+	})
+}
+
+func TestReadFrameCap(t *testing.T) {
+	// The peer never sends the end block; reading must stop at the cap
+	// rather than buffer the whole stream.
+	src := io.MultiReader(strings.NewReader("\x0b"), neverEnding('x'))
+	_, err := mllp.ReadFrame(bufio.NewReader(src))
+	if err == nil || !strings.Contains(err.Error(), "frame longer than") {
+		t.Fatalf("ReadFrame() = %v, want the frame cap error", err)
+	}
+
+	// The receiver drops a sender that overruns the cap, which the sender
+	// sees as a retryable transport error.
+	p := startPeer(t, func(_ int, msg []byte) []byte { return hl7.NewAck(msg, "AA", "") })
+	s := newTestMLLP(t, p.Addr())
+	err = s.Send(context.Background(), &Envelope{Body: bytes.Repeat([]byte("x"), 2<<20)})
+	if err == nil || IsPermanent(err) {
+		t.Errorf("Send() of an oversized frame = %v, want a retryable error", err)
+	}
+	if p.count() != 0 {
+		t.Errorf("peer accepted an oversized frame")
+	}
+}
+
+type neverEnding byte
+
+func (b neverEnding) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
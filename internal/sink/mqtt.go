@@ -0,0 +1,84 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"belt-presense/internal/tracing"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+const mqttPublishTimeout = 10 * time.Second
+
+type MQTTOptions struct {
+	BrokerURL string
+	ClientID  string
+	Username  string
+	Password  string
+	// Topic may contain {facility} and {patient} placeholders.
+	Topic    string
+	QoS      byte
+	Retained bool
+}
+
+// MQTT publishes the payload to a broker over its own connection, so
+// publishing problems cannot disturb the control-topic subscription.
+type MQTT struct {
+	client mqtt.Client
+	opts   MQTTOptions
+}
+
+// NewMQTT starts connecting in the background; publishes fail, and are
+// retried, until the connection is up.
+func NewMQTT(opts MQTTOptions) *MQTT {
+	clientOpts := mqtt.NewClientOptions()
+	clientOpts.AddBroker(opts.BrokerURL)
+	clientOpts.SetClientID(opts.ClientID)
+	clientOpts.SetUsername(opts.Username)
+	clientOpts.SetPassword(opts.Password)
+	clientOpts.SetConnectRetry(true)
+	clientOpts.SetAutoReconnect(true)
+	clientOpts.OnConnect = func(mqtt.Client) {
+		slog.Info("MQTT sink connected", "clientID", opts.ClientID)
+	}
+	clientOpts.OnConnectionLost = func(_ mqtt.Client, err error) {
+		slog.Warn("MQTT sink connection lost", "error", err)
+	}
+	client := mqtt.NewClient(clientOpts)
+	client.Connect()
+	return &MQTT{client: client, opts: opts}
+}
+
+func (s *MQTT) Name() string { return "mqtt" }
+
+func (s *MQTT) Send(ctx context.Context, env *Envelope) error {
+	topic := strings.NewReplacer("{facility}", env.FacilityID, "{patient}", env.PatientID).Replace(s.opts.Topic)
+	_, span := tracing.Tracer().Start(ctx, "mqtt.publish")
+	defer span.End()
+
+	if !s.client.IsConnectionOpen() {
+		err := errors.New("not connected to MQTT broker")
+		tracing.RecordError(span, err)
+		return err
+	}
+	token := s.client.Publish(topic, s.opts.QoS, s.opts.Retained, env.Body)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		err := fmt.Errorf("publish to %s timed out", topic)
+		tracing.RecordError(span, err)
+		return err
+	}
+	if err := token.Error(); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("publishing to %s: %w", topic, err)
+	}
+	return nil
+}
+
+func (s *MQTT) Close() {
+	s.client.Disconnect(250)
+}
@@ -0,0 +1,218 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"belt-presense/internal/logging"
+	"belt-presense/internal/metrics"
+	"belt-presense/internal/models"
+	"belt-presense/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+type PresenseOptions struct {
+	Timeout time.Duration
+	// MaxInFlight caps concurrent requests across all destinations.
+	MaxInFlight int
+	// RatePerSecond and Burst configure a token bucket for each destination
+	// URL; a zero RatePerSecond disables rate limiting.
+	RatePerSecond float64
+	Burst         int
+}
+
+// Presense posts the payload to the endpoint chosen by destination routing.
+type Presense struct {
+	client   *http.Client
+	opts     PresenseOptions
+	inFlight chan struct{}
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func NewPresense(opts PresenseOptions) *Presense {
+	if opts.MaxInFlight < 1 {
+		opts.MaxInFlight = 1
+	}
+	return &Presense{
+		client:   &http.Client{Timeout: opts.Timeout},
+		opts:     opts,
+		inFlight: make(chan struct{}, opts.MaxInFlight),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+func (s *Presense) limiter(url string) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.limiters[url]
+	if !ok {
+		l = rate.NewLimiter(rate.Limit(s.opts.RatePerSecond), s.opts.Burst)
+		s.limiters[url] = l
+	}
+	return l
+}
+
+// acquire waits for the destination's rate limit and a free request slot.
+// The caller must call release once the request is done.
+func (s *Presense) acquire(ctx context.Context, url string) error {
+	start := time.Now()
+	defer func() { metrics.SinkThrottleWait.WithLabelValues(s.Name()).Observe(time.Since(start).Seconds()) }()
+	if s.opts.RatePerSecond > 0 {
+		if err := s.limiter(url).Wait(ctx); err != nil {
+			return err
+		}
+	}
+	select {
+	case s.inFlight <- struct{}{}:
+		metrics.SinkInFlight.WithLabelValues(s.Name()).Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Presense) release() {
+	<-s.inFlight
+	metrics.SinkInFlight.WithLabelValues(s.Name()).Dec()
+}
+
+func (s *Presense) Name() string { return "presense" }
+
+func (s *Presense) Send(ctx context.Context, env *Envelope) error {
+	_, err := s.SendWithResponse(ctx, env)
+	return err
+}
+
+// SendWithResponse posts the batch and decodes what the API answers. A
+// response reporting validation errors, or one accepting none of the
+// batch's items, fails with ErrInvalid and the server's reason.
+func (s *Presense) SendWithResponse(ctx context.Context, env *Envelope) (*Response, error) {
+	dest := env.Destination
+	if dest.URL == "" || dest.Auth == nil {
+		return nil, Permanent(errors.New("route has no Presense endpoint or credentials"))
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "presense.send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", "POST"), attribute.String("url.full", dest.URL)))
+	defer span.End()
+
+	if err := s.acquire(ctx, dest.URL); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("waiting to send to Presense API: %w", err)
+	}
+	defer s.release()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", dest.URL, bytes.NewReader(env.Body))
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, Permanent(fmt.Errorf("creating API request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := dest.Auth.Authorize(ctx, req, env.Body); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("authorizing Presense API request: %w", err)
+	}
+	tracing.InjectHTTP(ctx, req.Header)
+	start := time.Now()
+	client := s.client
+	if dest.Client != nil {
+		client = dest.Client
+	}
+	httpResp, err := client.Do(req)
+	if err != nil {
+		tracing.RecordError(span, err)
+		metrics.ObserveAPIRequest(start, 0)
+		return nil, fmt.Errorf("sending data to Presense API: %w", err)
+	}
+	defer httpResp.Body.Close()
+	metrics.ObserveAPIRequest(start, httpResp.StatusCode)
+	span.SetAttributes(attribute.Int("http.response.status_code", httpResp.StatusCode))
+
+	logger := logging.FromContext(ctx)
+	resp := &Response{StatusCode: httpResp.StatusCode, Body: decodeResponse(logger, httpResp)}
+	if body := resp.Body; body != nil {
+		span.SetAttributes(attribute.String("presense.batch_id", body.ID),
+			attribute.Int("presense.accepted", body.Accepted), attribute.Int("presense.rejected", body.Rejected))
+		facility := metrics.FacilityLabel(env.FacilityID)
+		metrics.PresenseItems.WithLabelValues(facility, "accepted").Add(float64(body.Accepted))
+		metrics.PresenseItems.WithLabelValues(facility, "rejected").Add(float64(body.Rejected))
+	}
+
+	if httpResp.StatusCode == http.StatusUnauthorized {
+		// A rejected OAuth2 token may have been revoked early; retry with a
+		// fresh one.
+		if inv, ok := dest.Auth.(interface{ Invalidate() }); ok {
+			inv.Invalidate()
+			err := &StatusError{StatusCode: httpResp.StatusCode, Status: httpResp.Status}
+			tracing.RecordError(span, err)
+			return resp, fmt.Errorf("presense API: %w", err)
+		}
+	}
+	if httpResp.StatusCode >= 300 {
+		err := statusError(httpResp.StatusCode, httpResp.Status)
+		switch {
+		case IsPermanent(err) && invalid(resp.Body):
+			err = fmt.Errorf("presense API: %w: %w: %s", err, ErrInvalid, resp.Body.Reason())
+		case resp.Body.Reason() != "":
+			err = fmt.Errorf("presense API: %w: %s", err, resp.Body.Reason())
+		default:
+			err = fmt.Errorf("presense API: %w", err)
+		}
+		tracing.RecordError(span, err)
+		return resp, err
+	}
+	if body := resp.Body; body != nil && body.Rejected > 0 {
+		if body.Accepted == 0 {
+			err := Permanent(fmt.Errorf("presense API: %w: %s", ErrInvalid, body.Reason()))
+			tracing.RecordError(span, err)
+			return resp, err
+		}
+		logger.Warn("Presense API rejected part of batch", "batchId", body.ID, "accepted", body.Accepted,
+			"rejected", body.Rejected, "errors", body.Errors)
+	}
+	args := []interface{}{"status", httpResp.StatusCode, "duration", time.Since(start)}
+	if resp.Body != nil && resp.Body.ID != "" {
+		args = append(args, "batchId", resp.Body.ID)
+	}
+	logger.Info("Successfully sent batch to Presense API", args...)
+	return resp, nil
+}
+
+// maxResponseBytes bounds how much of a response body is read.
+const maxResponseBytes = 1 << 20
+
+// decodeResponse reads the API's JSON answer. Bodies that are empty or not
+// JSON are logged at debug level and ignored.
+func decodeResponse(logger *slog.Logger, resp *http.Response) *models.PresenseResponse {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		logger.Debug("Failed to read Presense API response", "error", err)
+		return nil
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	var body models.PresenseResponse
+	if err := json.Unmarshal(data, &body); err != nil {
+		logger.Debug("Presense API response is not JSON", "status", resp.StatusCode, "error", err)
+		return nil
+	}
+	return &body
+}
+
+// invalid reports whether an error response blames the batch's content.
+func invalid(body *models.PresenseResponse) bool {
+	return body != nil && (len(body.Errors) > 0 || body.Rejected > 0)
+}
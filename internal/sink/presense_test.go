@@ -0,0 +1,134 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// presenseServer answers every request with status and body.
+func presenseServer(t *testing.T, status int, body string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func presenseEnvelope(url string, auth Authenticator) *Envelope {
+	return &Envelope{PatientID: "P-1", FacilityID: "F-1", Body: []byte(`{"patientRef":"F-1-P-1-A-1"}`),
+		Destination: Destination{URL: url, Auth: auth}}
+}
+
+func TestPresenseResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantErr     bool
+		wantInvalid bool
+		permanent   bool
+		wantStatus  int    // StatusError code, if any
+		wantReason  string // in the error
+		wantID      string // decoded batch ID
+	}{
+		{name: "accepted", status: 200, body: `{"id":"b-1","accepted":3,"rejected":0}`, wantID: "b-1"},
+		{name: "partly rejected", status: 200, body: `{"id":"b-2","accepted":2,"rejected":1,"errors":[{"field":"HR","message":"out of range"}]}`, wantID: "b-2"},
+		{name: "not JSON", status: 200, body: `ok`},
+		{name: "all items rejected", status: 200, body: `{"id":"b-3","accepted":0,"rejected":3,"errors":[{"field":"SEQ","message":"duplicate"}]}`,
+			wantErr: true, wantInvalid: true, permanent: true, wantReason: "SEQ: duplicate", wantID: "b-3"},
+		{name: "validation errors", status: 400, body: `{"accepted":0,"rejected":0,"errors":[{"message":"patientRef unknown"}]}`,
+			wantErr: true, wantInvalid: true, permanent: true, wantStatus: 400, wantReason: "patientRef unknown"},
+		{name: "bad request without details", status: 400, body: ``,
+			wantErr: true, permanent: true, wantStatus: 400},
+		{name: "forbidden with a message", status: 403, body: `{"message":"facility not enabled"}`,
+			wantErr: true, permanent: true, wantStatus: 403, wantReason: "facility not enabled"},
+		{name: "unauthorized without OAuth2", status: 401, body: ``,
+			wantErr: true, permanent: true, wantStatus: 401},
+		{name: "rate limited", status: 429, body: ``, wantErr: true, wantStatus: 429},
+		{name: "server error", status: 503, body: `{"message":"maintenance"}`,
+			wantErr: true, wantStatus: 503, wantReason: "maintenance"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := presenseServer(t, tt.status, tt.body)
+			s := NewPresense(PresenseOptions{})
+			resp, err := s.SendWithResponse(context.Background(), presenseEnvelope(srv.URL, BearerAuth{Token: "tok"}))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendWithResponse() = %v, want error %t", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrInvalid); got != tt.wantInvalid {
+				t.Errorf("errors.Is(%v, ErrInvalid) = %t, want %t", err, got, tt.wantInvalid)
+			}
+			if got := IsPermanent(err); got != tt.permanent {
+				t.Errorf("IsPermanent(%v) = %t, want %t", err, got, tt.permanent)
+			}
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) != (tt.wantStatus != 0) || (statusErr != nil && statusErr.StatusCode != tt.wantStatus) {
+				t.Errorf("error %v, want StatusError %d", err, tt.wantStatus)
+			}
+			if tt.wantReason != "" && !strings.Contains(err.Error(), tt.wantReason) {
+				t.Errorf("error %q does not give the reason %q", err, tt.wantReason)
+			}
+			if resp == nil || resp.StatusCode != tt.status {
+				t.Fatalf("response = %+v, want status %d", resp, tt.status)
+			}
+			if tt.wantID != "" && (resp.Body == nil || resp.Body.ID != tt.wantID) {
+				t.Errorf("response body = %+v, want batch %s", resp.Body, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestPresenseRetriesUnauthorizedWithNewToken(t *testing.T) {
+	var issued atomic.Int32
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(int(n)) + `","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokens.Close()
+	// The first token was revoked before it expired.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id":"b-1","accepted":1}`))
+	}))
+	defer api.Close()
+
+	auth := NewOAuth2(OAuth2Options{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "secret", Client: tokens.Client()})
+	s := NewPresense(PresenseOptions{})
+	env := presenseEnvelope(api.URL, auth)
+
+	_, err := s.SendWithResponse(context.Background(), env)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized || IsPermanent(err) {
+		t.Fatalf("first send = %v, want a retryable 401", err)
+	}
+	if _, err := s.SendWithResponse(context.Background(), env); err != nil {
+		t.Fatalf("retry = %v, want success with a fresh token", err)
+	}
+	if n := issued.Load(); n != 2 {
+		t.Errorf("%d tokens issued, want 2", n)
+	}
+}
+
+func TestPresenseTransportError(t *testing.T) {
+	srv := presenseServer(t, 200, `{}`)
+	srv.Close()
+	s := NewPresense(PresenseOptions{})
+	resp, err := s.SendWithResponse(context.Background(), presenseEnvelope(srv.URL, BearerAuth{Token: "tok"}))
+	if err == nil || IsPermanent(err) || resp != nil {
+		t.Errorf("SendWithResponse() = %+v, %v; want a retryable error and no response", resp, err)
+	}
+}
@@ -0,0 +1,183 @@
+package sink
+
+import (
+	"sync"
+	"time"
+)
+
+// patientQueue holds a sink's queued envelopes by patient. A patient's
+// envelopes are handed out one at a time, and the next only after the
+// previous is done, so each patient's batches are delivered in order while
+// different patients are delivered in parallel.
+type patientQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	capacity int
+	queued   int
+	pending  map[string][]queued
+	ready    []string // patients with queued envelopes and none in flight
+	inFlight map[string]bool
+	closed   bool
+}
+
+func newPatientQueue(capacity int) *patientQueue {
+	q := &patientQueue{
+		capacity: capacity,
+		pending:  make(map[string][]queued),
+		inFlight: make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues item behind the patient's earlier envelopes. When the queue
+// is full the oldest waiting envelope is evicted to make room and returned.
+// It reports false when the queue is closed.
+func (q *patientQueue) push(item queued) (evicted []queued, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, false
+	}
+	for q.queued >= q.capacity {
+		oldest, found := q.removeOldest()
+		if !found {
+			break
+		}
+		evicted = append(evicted, oldest)
+	}
+	key := item.env.PatientID
+	q.pending[key] = append(q.pending[key], item)
+	q.queued++
+	if len(q.pending[key]) == 1 && !q.inFlight[key] {
+		q.ready = append(q.ready, key)
+		q.cond.Signal()
+	}
+	return evicted, true
+}
+
+// oldestKey returns the patient whose next waiting envelope was queued
+// earliest. The caller holds q.mu.
+func (q *patientQueue) oldestKey() (string, bool) {
+	var key string
+	var oldest time.Time
+	for k, items := range q.pending {
+		if oldest.IsZero() || items[0].enqueued.Before(oldest) {
+			key, oldest = k, items[0].enqueued
+		}
+	}
+	return key, !oldest.IsZero()
+}
+
+// removeOldest takes the oldest waiting envelope off the queue. The caller
+// holds q.mu.
+func (q *patientQueue) removeOldest() (queued, bool) {
+	key, ok := q.oldestKey()
+	if !ok {
+		return queued{}, false
+	}
+	item := q.pending[key][0]
+	if rest := q.pending[key][1:]; len(rest) > 0 {
+		q.pending[key] = rest
+	} else {
+		delete(q.pending, key)
+		for i, k := range q.ready {
+			if k == key {
+				q.ready = append(q.ready[:i], q.ready[i+1:]...)
+				break
+			}
+		}
+	}
+	q.queued--
+	return item, true
+}
+
+// expire evicts, oldest first, every waiting envelope queued more than
+// maxAge ago.
+func (q *patientQueue) expire(maxAge time.Duration) []queued {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var expired []queued
+	for {
+		key, ok := q.oldestKey()
+		if !ok || time.Since(q.pending[key][0].enqueued) <= maxAge {
+			break
+		}
+		item, _ := q.removeOldest()
+		expired = append(expired, item)
+	}
+	if len(expired) > 0 && q.closed && q.queued == 0 {
+		q.cond.Broadcast()
+	}
+	return expired
+}
+
+// oldestAge returns how long the oldest waiting envelope has been queued.
+func (q *patientQueue) oldestAge() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key, ok := q.oldestKey()
+	if !ok {
+		return 0
+	}
+	return time.Since(q.pending[key][0].enqueued)
+}
+
+// take blocks until an envelope is ready for delivery. It reports false
+// once the queue is closed and empty. The caller must call done with the
+// envelope's patient ID when delivery finishes.
+func (q *patientQueue) take() (queued, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.ready) == 0 {
+		if q.closed && q.queued == 0 {
+			return queued{}, false
+		}
+		q.cond.Wait()
+	}
+	key := q.ready[0]
+	q.ready = q.ready[1:]
+	item := q.pending[key][0]
+	if rest := q.pending[key][1:]; len(rest) > 0 {
+		q.pending[key] = rest
+	} else {
+		delete(q.pending, key)
+	}
+	q.queued--
+	q.inFlight[key] = true
+	return item, true
+}
+
+func (q *patientQueue) done(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inFlight, key)
+	if len(q.pending[key]) > 0 {
+		q.ready = append(q.ready, key)
+		q.cond.Signal()
+	} else if q.closed && q.queued == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+func (q *patientQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// depths returns, by patient, the envelopes not yet delivered, counting
+// one in flight.
+func (q *patientQueue) depths() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depths := make(map[string]int, len(q.pending)+len(q.inFlight))
+	for key, items := range q.pending {
+		depths[key] = len(items)
+	}
+	for key := range q.inFlight {
+		depths[key]++
+	}
+	return depths
+}
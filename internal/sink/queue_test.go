@@ -0,0 +1,182 @@
+package sink
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// item is an envelope for patient, labelled name, queued age ago.
+func item(patient, name string, age time.Duration) queued {
+	return queued{env: &Envelope{PatientID: patient, TraceID: name}, enqueued: time.Now().Add(-age)}
+}
+
+func names(items []queued) []string {
+	var out []string
+	for _, it := range items {
+		out = append(out, it.env.TraceID)
+	}
+	return out
+}
+
+func mustPush(t *testing.T, q *patientQueue, it queued) []queued {
+	t.Helper()
+	evicted, ok := q.push(it)
+	if !ok {
+		t.Fatalf("push(%s) on an open queue failed", it.env.TraceID)
+	}
+	return evicted
+}
+
+func TestQueueEvictsOldestWaiting(t *testing.T) {
+	tests := []struct {
+		name        string
+		capacity    int
+		push        []queued
+		takeFirst   int      // envelopes then taken and left in flight
+		then        []queued // pushed after the takes
+		last        queued
+		wantEvicted []string
+	}{
+		{
+			name:        "oldest across patients",
+			capacity:    3,
+			push:        []queued{item("A", "A1", 40*time.Second), item("B", "B1", 30*time.Second), item("A", "A2", 20*time.Second)},
+			last:        item("C", "C1", 0),
+			wantEvicted: []string{"A1"},
+		},
+		{
+			name:        "by queue time, not push order",
+			capacity:    2,
+			push:        []queued{item("A", "A1", 10*time.Second), item("B", "B1", 50*time.Second)},
+			last:        item("C", "C1", 0),
+			wantEvicted: []string{"B1"},
+		},
+		{
+			name:        "never the one in flight",
+			capacity:    2,
+			push:        []queued{item("A", "A1", 40*time.Second), item("A", "A2", 30*time.Second)},
+			takeFirst:   1,
+			then:        []queued{item("B", "B1", 20*time.Second)},
+			last:        item("C", "C1", 0),
+			wantEvicted: []string{"A2"},
+		},
+		{
+			name:     "room left",
+			capacity: 3,
+			push:     []queued{item("A", "A1", 10*time.Second)},
+			last:     item("A", "A2", 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newPatientQueue(tt.capacity)
+			pushAll := func(items []queued) {
+				for _, it := range items {
+					if evicted := mustPush(t, q, it); len(evicted) != 0 {
+						t.Fatalf("push(%s) evicted %v early", it.env.TraceID, names(evicted))
+					}
+				}
+			}
+			pushAll(tt.push)
+			for i := 0; i < tt.takeFirst; i++ {
+				q.take()
+			}
+			pushAll(tt.then)
+			if got := names(mustPush(t, q, tt.last)); !reflect.DeepEqual(got, tt.wantEvicted) {
+				t.Errorf("evicted %v, want %v", got, tt.wantEvicted)
+			}
+		})
+	}
+}
+
+func TestQueueEvictionKeepsPatientOrder(t *testing.T) {
+	q := newPatientQueue(3)
+	mustPush(t, q, item("A", "A1", 50*time.Second))
+	mustPush(t, q, item("B", "B1", 40*time.Second))
+	mustPush(t, q, item("A", "A2", 30*time.Second))
+	mustPush(t, q, item("A", "A3", 20*time.Second)) // evicts A1
+	mustPush(t, q, item("B", "B2", 10*time.Second)) // evicts B1
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		it, ok := q.take()
+		if !ok {
+			t.Fatal("take() on a non-empty queue failed")
+		}
+		got = append(got, it.env.TraceID)
+		q.done(it.env.PatientID)
+	}
+	if want := []string{"A2", "B2", "A3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestQueueExpire(t *testing.T) {
+	q := newPatientQueue(10)
+	mustPush(t, q, item("A", "A1", 90*time.Second))
+	mustPush(t, q, item("B", "B1", 70*time.Second))
+	mustPush(t, q, item("A", "A2", 80*time.Second))
+	mustPush(t, q, item("C", "C1", 5*time.Second))
+
+	if got, want := names(q.expire(time.Minute)), []string{"A1", "A2", "B1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expired %v, want %v", got, want)
+	}
+	if age := q.oldestAge(); age < 5*time.Second || age > 6*time.Second {
+		t.Errorf("oldestAge() = %s, want about 5s", age)
+	}
+	if got := q.depths(); !reflect.DeepEqual(got, map[string]int{"C": 1}) {
+		t.Errorf("depths() = %v", got)
+	}
+	it, _ := q.take()
+	if it.env.TraceID != "C1" {
+		t.Errorf("take() = %s after expiry, want C1", it.env.TraceID)
+	}
+}
+
+func TestQueueOnePatientAtATime(t *testing.T) {
+	q := newPatientQueue(10)
+	mustPush(t, q, item("A", "A1", 3*time.Second))
+	mustPush(t, q, item("A", "A2", 2*time.Second))
+	mustPush(t, q, item("B", "B1", time.Second))
+
+	first, _ := q.take()
+	second, _ := q.take()
+	if first.env.TraceID != "A1" || second.env.TraceID != "B1" {
+		t.Fatalf("took %s, %s; want A1 then B1 while A1 is in flight", first.env.TraceID, second.env.TraceID)
+	}
+	if got := q.depths(); !reflect.DeepEqual(got, map[string]int{"A": 2, "B": 1}) {
+		t.Errorf("depths() = %v", got)
+	}
+
+	next := make(chan queued)
+	go func() {
+		it, _ := q.take()
+		next <- it
+	}()
+	select {
+	case it := <-next:
+		t.Fatalf("take() returned %s while A1 was in flight", it.env.TraceID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.done("A")
+	if it := <-next; it.env.TraceID != "A2" {
+		t.Errorf("take() = %s, want A2", it.env.TraceID)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	q := newPatientQueue(10)
+	mustPush(t, q, item("A", "A1", 0))
+	q.close()
+	if _, ok := q.push(item("A", "A2", 0)); ok {
+		t.Error("push() after close succeeded")
+	}
+	if it, ok := q.take(); !ok || it.env.TraceID != "A1" {
+		t.Fatal("take() after close did not drain the queue")
+	}
+	q.done("A")
+	if _, ok := q.take(); ok {
+		t.Error("take() on a closed, empty queue succeeded")
+	}
+}
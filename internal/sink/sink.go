@@ -0,0 +1,111 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"belt-presense/internal/models"
+)
+
+// Envelope is one processed batch on its way to the sinks. Sinks must treat
+// it as read-only: the same envelope is handed to every sink.
+type Envelope struct {
+	PatientID  string
+	FacilityID string
+	DeviceType string
+	TraceID    string
+	// Route is the name of the destination route the batch matched, and
+	// Destination the Presense endpoint it selected.
+	Route       string
+	Destination Destination
+	Payload     *models.PresensePayload
+	// Body is Payload encoded as JSON, or in the sink's format, with
+	// ContentType its media type when that is not application/json.
+	Body        []byte
+	ContentType string
+	// Messages are the source packets the payload was built from.
+	Messages []*models.ECGMessage
+	// VitalsDeviceID is the BP/SpO2 device the payload's cached vitals came
+	// from.
+	VitalsDeviceID string
+}
+
+func (e *Envelope) contentType() string {
+	if e.ContentType == "" {
+		return "application/json"
+	}
+	return e.ContentType
+}
+
+type Destination struct {
+	URL  string
+	Auth Authenticator
+	// Client sends the request; nil uses the sink's default client.
+	Client *http.Client
+}
+
+// Sink delivers envelopes to one downstream system.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, env *Envelope) error
+}
+
+// ResponseSink is a Sink whose destination answers with a body worth
+// recording. The dispatcher calls SendWithResponse instead of Send.
+type ResponseSink interface {
+	Sink
+	SendWithResponse(ctx context.Context, env *Envelope) (*Response, error)
+}
+
+// Response is a destination's answer to one delivery attempt.
+type Response struct {
+	StatusCode int
+	// Body is nil when the response had no body or it could not be decoded.
+	Body *models.PresenseResponse
+}
+
+// ErrInvalid marks a batch the destination refused because it failed
+// validation; sending it again will not help.
+var ErrInvalid = errors.New("batch failed validation")
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// StatusError is returned by HTTP sinks for non-2xx responses.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned %s", e.Status)
+}
+
+// statusError classifies a non-2xx response: client errors other than
+// timeouts and rate limiting will fail the same way again.
+func statusError(code int, status string) error {
+	err := &StatusError{StatusCode: code, Status: status}
+	if code >= 400 && code < 500 && code != 408 && code != 429 {
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"belt-presense/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Webhook posts the payload to a generic HTTP endpoint.
+type Webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhook(url string, headers map[string]string, timeout time.Duration) *Webhook {
+	return &Webhook{url: url, headers: headers, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *Webhook) Name() string { return "webhook" }
+
+func (s *Webhook) Send(ctx context.Context, env *Envelope) error {
+	ctx, span := tracing.Tracer().Start(ctx, "webhook.send", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", "POST"), attribute.String("url.full", s.url)))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(env.Body))
+	if err != nil {
+		tracing.RecordError(span, err)
+		return Permanent(err)
+	}
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", env.contentType())
+	req.Header.Set("X-Patient-Id", env.PatientID)
+	req.Header.Set("X-Facility-Id", env.FacilityID)
+	tracing.InjectHTTP(ctx, req.Header)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("sending webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 300 {
+		err := statusError(resp.StatusCode, resp.Status)
+		tracing.RecordError(span, err)
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}